@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/jaxxk/anki-cards-generator/internal/cache"
+	"github.com/spf13/cobra"
+)
+
+// cacheCmd represents the cache command
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Inspects or clears the cache of generated decks",
+	Long: `Decks generated for each chunk of notes are cached in the processing directory,
+	keyed by the chunk, model, prompt and parameters.
+
+	Entries expire after POGGERS_CACHE_TTL (default 720h) and the oldest are evicted
+	once the cache holds more than POGGERS_CACHE_MAX_BYTES (default 64 MiB).
+
+	Example Usage:
+	poggers cache stats
+	poggers cache clear
+	`,
+}
+
+var cacheStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Shows the number and size of cached decks",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		responseCache, err := cache.Open()
+		if err != nil {
+			return err
+		}
+		stats, err := responseCache.Stats()
+		if err != nil {
+			return err
+		}
+		out := cmd.OutOrStdout()
+		fmt.Fprintf(out, "Directory: %s\n", responseCache.Dir)
+		fmt.Fprintf(out, "Entries:   %d (%d expired)\n", stats.Entries, stats.Expired)
+		fmt.Fprintf(out, "Size:      %d bytes (limit %d)\n", stats.Bytes, responseCache.MaxSize)
+		fmt.Fprintf(out, "TTL:       %s\n", responseCache.TTL)
+		if stats.Entries > 0 {
+			fmt.Fprintf(out, "Oldest:    %s\n", stats.Oldest.Format("2006-01-02 15:04"))
+			fmt.Fprintf(out, "Newest:    %s\n", stats.Newest.Format("2006-01-02 15:04"))
+		}
+		return nil
+	},
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Removes every cached deck",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		responseCache, err := cache.Open()
+		if err != nil {
+			return err
+		}
+		removed, err := responseCache.Clear()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Removed %d cached deck(s)\n", removed)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheStatsCmd)
+	cacheCmd.AddCommand(cacheClearCmd)
+}
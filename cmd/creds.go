@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jaxxk/anki-cards-generator/internal/encryption"
+	"github.com/jaxxk/anki-cards-generator/pkg/logging"
+	"github.com/spf13/cobra"
+)
+
+var Secret string
+
+// credsCmd represents the creds command
+var credsCmd = &cobra.Command{
+	Use:   "creds",
+	Short: "Manages the named credentials stored by poggers",
+	Long: `Stores several secrets side by side, keyed by name. Well known names are
+	openai, openai-gateway, anthropic and ankiconnect.
+
+	Set POGGERS_CREDENTIAL_HELPER to a command (e.g. a script wrapping pass or op) to have
+	secrets requested from it instead. The helper is run as "<command> get", receives
+	{"action":"get","name":"<name>"} on stdin and must print {"secret":"..."},
+	{"not_found":true} when it has no such secret, or {"error":"..."} when it fails.
+	Only not_found falls back to the local store.
+
+	Example Usage:
+	poggers creds add openai -s <your-key>
+	poggers creds list
+	poggers creds rotate ankiconnect -s <new-key>
+	poggers creds remove anthropic
+	`,
+}
+
+var credsAddCmd = &cobra.Command{
+	Use:   "add <name>",
+	Short: "Adds a new named credential",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := logging.FromContext(cmd.Context())
+		secret, err := readSecret(cmd)
+		if err != nil {
+			return err
+		}
+		store, err := encryption.LoadStore()
+		if err != nil {
+			logger.Errorf("Failed to load credential store: %v", err)
+			return fmt.Errorf("failed to load credential store: %w", err)
+		}
+		if err := store.Add(args[0], secret); err != nil {
+			return err
+		}
+		if err := store.Save(); err != nil {
+			return fmt.Errorf("failed to save credential store: %w", err)
+		}
+		logger.Infof("Added credential %s (%s)", args[0], encryption.Fingerprint(secret))
+		return nil
+	},
+}
+
+var credsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists stored credential names and fingerprints",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := encryption.LoadStore()
+		if err != nil {
+			return fmt.Errorf("failed to load credential store: %w", err)
+		}
+		out := cmd.OutOrStdout()
+		for _, info := range store.List() {
+			fmt.Fprintf(out, "%-20s %s  %s\n", info.Name, info.Fingerprint, info.UpdatedAt.Format("2006-01-02"))
+		}
+		return nil
+	},
+}
+
+var credsRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Removes a stored credential",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := logging.FromContext(cmd.Context())
+		store, err := encryption.LoadStore()
+		if err != nil {
+			return fmt.Errorf("failed to load credential store: %w", err)
+		}
+		if err := store.Remove(args[0]); err != nil {
+			return err
+		}
+		if err := store.Save(); err != nil {
+			return fmt.Errorf("failed to save credential store: %w", err)
+		}
+		logger.Infof("Removed credential %s", args[0])
+		return nil
+	},
+}
+
+var credsRotateCmd = &cobra.Command{
+	Use:   "rotate <name>",
+	Short: "Replaces the secret of a stored credential",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := logging.FromContext(cmd.Context())
+		secret, err := readSecret(cmd)
+		if err != nil {
+			return err
+		}
+		store, err := encryption.LoadStore()
+		if err != nil {
+			return fmt.Errorf("failed to load credential store: %w", err)
+		}
+		if err := store.Rotate(args[0], secret); err != nil {
+			return err
+		}
+		if err := store.Save(); err != nil {
+			return fmt.Errorf("failed to save credential store: %w", err)
+		}
+		logger.Infof("Rotated credential %s (%s)", args[0], encryption.Fingerprint(secret))
+		return nil
+	},
+}
+
+// readSecret returns the --secret flag, or the first line of stdin when the flag is not set.
+func readSecret(cmd *cobra.Command) (string, error) {
+	if Secret != "" {
+		return Secret, nil
+	}
+	line, err := bufio.NewReader(cmd.InOrStdin()).ReadString('\n')
+	line = strings.TrimSpace(line)
+	if line == "" {
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret from stdin: %w", err)
+		}
+		return "", errors.New("secret cannot be empty")
+	}
+	return line, nil
+}
+
+func init() {
+	credsAddCmd.Flags().StringVarP(&Secret, "secret", "s", "", "Secret value (read from stdin when omitted)")
+	credsRotateCmd.Flags().StringVarP(&Secret, "secret", "s", "", "New secret value (read from stdin when omitted)")
+	credsCmd.AddCommand(credsAddCmd, credsListCmd, credsRemoveCmd, credsRotateCmd)
+	rootCmd.AddCommand(credsCmd)
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/jaxxk/anki-cards-generator/internal/create/ankitest"
+	"github.com/jaxxk/anki-cards-generator/pkg/logging"
+	"github.com/spf13/cobra"
+)
+
+var FakeAnkiAddr string
+var FakeAnkiKey string
+
+// fakeAnkiCmd represents the fake-anki command
+var fakeAnkiCmd = &cobra.Command{
+	Use:   "fake-anki",
+	Short: "Runs an in-memory AnkiConnect server for demos and offline development",
+	Long: `The "fake-anki" command serves a fake AnkiConnect API that keeps decks and notes in
+	memory, so poggers can be tried without Anki desktop installed. State is lost on exit.
+
+	Example Usage:
+	poggers fake-anki --addr localhost:8765
+	`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		logger := logging.FromContext(ctx)
+
+		fake := ankitest.New()
+		fake.SetAPIKey(FakeAnkiKey)
+		server := &http.Server{Addr: FakeAnkiAddr, Handler: fake}
+
+		go func() {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			server.Shutdown(shutdownCtx)
+		}()
+
+		logger.Infof("Fake AnkiConnect listening on http://%s", FakeAnkiAddr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(fakeAnkiCmd)
+
+	fakeAnkiCmd.Flags().StringVar(&FakeAnkiAddr, "addr", "localhost:8765", "Address to listen on")
+	fakeAnkiCmd.Flags().StringVar(&FakeAnkiKey, "api-key", "", "Require this AnkiConnect key on every request (optional)")
+}
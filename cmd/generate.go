@@ -1,11 +1,20 @@
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/openai/openai-go"
+
+	"github.com/jaxxk/anki-cards-generator/internal/cache"
 	"github.com/jaxxk/anki-cards-generator/internal/create"
+	"github.com/jaxxk/anki-cards-generator/internal/gitdiff"
 	"github.com/jaxxk/anki-cards-generator/internal/transform"
+	"github.com/jaxxk/anki-cards-generator/internal/usage"
 	"github.com/jaxxk/anki-cards-generator/pkg/logging"
 	"github.com/jaxxk/anki-cards-generator/pkg/utils"
 	"github.com/spf13/cobra"
@@ -13,6 +22,30 @@ import (
 
 var FilePath string
 var Title string
+var DryRun bool
+var Model string
+var PriceTablePath string
+var MaxCost float64
+var MaxTokens int
+var NoCache bool
+var Full bool
+var Since string
+var DedupThreshold = transform.DefaultDuplicateThreshold
+var DedupExisting bool
+var Grounding = string(transform.GroundingFlag)
+var GroundingJudge bool
+var RefinePasses int
+var Summarize bool
+var RubricPath string
+var RepairAttempts = transform.DefaultRepairAttempts
+var NoStructuredOutput bool
+var CardsPer100Words float64
+var MinCards int
+var MaxCards int
+var LevelMix string
+
+// PRICE_TABLE_FILE is read from the processing dir when --price-table is not given.
+var PRICE_TABLE_FILE = "prices.json"
 
 // generateCmd represents the generate command
 var generateCmd = &cobra.Command{
@@ -21,59 +54,594 @@ var generateCmd = &cobra.Command{
 	Long: `The "generate" command processes the specified .md or .txt file to 
 	generate insightful Anki flashcards based on its content and saves the result in a temporary JSON file.
 
+	Notes are split into sections at headings and "---" lines. Running generate on the
+	same file again only sends new or modified sections to the model, reuses the cards
+	of the others, and reports which cards were added, changed and removed. Every card
+	gets a difficulty from 1 to 5 and a level, recall, understand, apply or analyze,
+	added to its Anki note as the tags difficulty::N and bloom::level, and is checked
+	against the notes it was generated from. Every call's token usage is appended to
+	the ledger shown by poggers usage. The flags below tune each of these steps.
+
+	Set POGGERS_ENCRYPT_AT_REST=true to encrypt the saved deck with the key from
+	poggers addKey generateEncryption.
+
 	Example Usage:
 	poggers generate -f /Users/jaxk/notes/notes.md
+	poggers generate -f notes.md --dry-run --summarize --refine-passes 1
+	poggers generate -f notes.md --mix recall=40,apply=40,analyze=20 --max-cost 0.05
+	poggers generate --since HEAD~1
 	`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := cmd.Context()
 		logger := logging.FromContext(ctx)
 
+		transform.DefaultDuplicateThreshold = DedupThreshold
+		transform.DefaultRepairAttempts = RepairAttempts
+		transform.DefaultStructuredOutput = !NoStructuredOutput
+
+		if FilePath == "" && Since == "" {
+			return errors.New("either --file or --since is required")
+		}
+		if _, err := transform.ParseGroundingPolicy(Grounding); err != nil {
+			return err
+		}
+		if err := flagDensity().Validate(); err != nil {
+			return err
+		}
+		if _, err := flagMix(); err != nil {
+			return err
+		}
+		if DryRun {
+			if Since != "" {
+				return errors.New("--dry-run cannot be combined with --since")
+			}
+			return dryRun(cmd)
+		}
+
+		ankiClient, err := newAnkiClient(cmd)
+		if err != nil {
+			return err
+		}
+
 		// ensures anki is running before processing the notes
-		if ok, err := create.EnsureAnkiConnect(); err != nil || !ok {
+		if ok, err := ankiClient.EnsureAnkiConnect(ctx); err != nil || !ok {
 			return errors.New("cannot connect to Anki Connect")
 		}
 
+		if Since != "" {
+			return generateSince(cmd, ankiClient)
+		}
+
 		// Validate and resolve file path
 		FilePath, err := utils.ValidateAndResolvePath(FilePath, logger)
 		if err != nil {
 			return fmt.Errorf("validation error for file path: %w", err)
 		}
+		return generateFile(ctx, cmd, ankiClient, FilePath)
+	},
+}
 
-		// Transforming notes into deck struct
-		newDeck, err := transform.TransformNote(ctx, FilePath)
+// generateFile runs the generate pipeline on the notes at path: transforms the sections that
+// changed since the last run, saves the deck and sends it to Anki.
+func generateFile(ctx context.Context, cmd *cobra.Command, ankiClient *create.AnkiClient, path string) error {
+	logger := logging.FromContext(ctx)
+
+	ctx, targeting, grounding, err := withGenerationSettings(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	// the estimate needs the settings above to tell which parts the last run can serve
+	run, err := newUsageRun(ctx, path)
+	if err != nil {
+		return err
+	}
+	ctx, err = withResponseCache(usage.WithRun(ctx, run))
+	if err != nil {
+		return err
+	}
+
+	// Transforming notes into deck struct
+	newDeck, diff, err := transform.TransformNoteIncremental(ctx, path, Full)
+	if err != nil {
+		logger.Errorf("Failed to transform %v: %v", path, err)
+		return fmt.Errorf("failed to transform notes: %w", err)
+	}
+	printDeckDiff(cmd, diff)
+	printDensityReport(cmd, targeting.Report(), len(newDeck.Cards))
+	printMixReport(cmd, targeting, newDeck.Cards)
+	printGroundingReport(cmd, grounding.Report())
+
+	// Update Title
+	if len(Title) > 0 {
+		newDeck.UpdateTitle(Title)
+	}
+
+	if DedupExisting {
+		if newDeck, err = dropExistingDuplicates(ctx, cmd, ankiClient, newDeck); err != nil {
+			return err
+		}
+	}
+
+	// Save Deck to Processing Dir For retry
+	jsonPath, err := transform.SaveDeck(newDeck)
+	if err != nil {
+		logger.Errorf("Failed to save deck to %v: %v", jsonPath, err)
+		return fmt.Errorf("failed to save deck to %v: %w", jsonPath, err)
+	}
+
+	logger.Infof("Successfully Created %v deck JSON", newDeck.Title)
+
+	if Review {
+		if newDeck, err = reviewDeck(ctx, cmd, jsonPath, newDeck, path); err != nil {
+			return err
+		}
+		if len(newDeck.Cards) == 0 {
+			fmt.Fprintln(cmd.OutOrStdout(), "No cards left to send to Anki")
+			return nil
+		}
+	}
+
+	if FailOn != "" {
+		if err := lintDeck(cmd, newDeck, FailOn); err != nil {
+			return fmt.Errorf("%w, fix %v and poggers push it", err, jsonPath)
+		}
+	}
+
+	report, err := ankiClient.SendToAnki(ctx, newDeck)
+	if err != nil {
+		return err
+	}
+	return printPushReport(cmd, report)
+}
+
+// generateSince generates and pushes one deck per Markdown or text file changed since the
+// --since revision, from the changed lines only.
+func generateSince(cmd *cobra.Command, ankiClient *create.AnkiClient) error {
+	ctx := cmd.Context()
+	logger := logging.FromContext(ctx)
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get working directory: %w", err)
+	}
+	file := ""
+	if FilePath != "" {
+		if file, err = utils.ValidateAndResolvePath(FilePath, logger); err != nil {
+			return fmt.Errorf("validation error for file path: %w", err)
+		}
+		dir = filepath.Dir(file)
+	}
+
+	repo, err := gitdiff.RepoRoot(ctx, dir)
+	if err != nil {
+		return fmt.Errorf("failed to find git repository: %w", err)
+	}
+	pathspecs := gitdiff.MarkdownPathspecs
+	if file != "" {
+		relative, err := repoRelative(repo, file)
+		if err != nil {
+			return err
+		}
+		pathspecs = []string{":(top,literal)" + relative}
+	}
+	changes, err := gitdiff.Changes(ctx, repo, Since, pathspecs...)
+	if err != nil {
+		return fmt.Errorf("failed to diff %v against HEAD: %w", Since, err)
+	}
+
+	prices, err := loadPriceTable()
+	if err != nil {
+		return err
+	}
+	run := usage.NewRun(repo, usage.Budget{MaxCost: MaxCost, MaxTokens: MaxTokens}, prices)
+	run.Deck = Title
+	ctx, err = withResponseCache(usage.WithRun(ctx, run))
+	if err != nil {
+		return err
+	}
+	refinement, err := newRefinement()
+	if err != nil {
+		return err
+	}
+	ctx = transform.WithSummaryPass(transform.WithRefinement(transform.WithModel(ctx, openai.ChatModel(Model)), refinement), Summarize)
+
+	out := cmd.OutOrStdout()
+	var pushErrs []error
+	generated := 0
+	for _, change := range changes {
+		hunks := change.Added()
+		if change.Deleted || len(hunks) == 0 {
+			continue
+		}
+		content, err := gitdiff.ShowFile(ctx, repo, "HEAD", change.Path)
 		if err != nil {
-			logger.Errorf("Error: ", err)
+			return fmt.Errorf("failed to read %v at HEAD: %w", change.Path, err)
+		}
+		changed := make([]transform.LineRange, 0, len(hunks))
+		for _, hunk := range hunks {
+			changed = append(changed, transform.LineRange{Start: hunk.Start, End: hunk.End()})
+		}
+		regions, err := transform.ChangedRegions(ctx, string(content), changed)
+		if err != nil {
+			return fmt.Errorf("failed to find changed sections of %v: %w", change.Path, err)
+		}
+		if len(regions) == 0 {
+			continue
 		}
 
-		// Update Title
+		source := filepath.Join(repo, filepath.FromSlash(change.Path))
+		fmt.Fprintf(out, "%s: %d changed section(s)\n", change.Path, len(regions))
+		grounding, err := newGrounding()
+		if err != nil {
+			return err
+		}
+		targeting, err := newTargeting(change.Path, string(content))
+		if err != nil {
+			return err
+		}
+		fileCtx := transform.WithTargeting(transform.WithGrounding(ctx, grounding), targeting)
+		newDeck, err := transform.TransformRegions(fileCtx, source, content, regions)
+		if err != nil {
+			logger.Errorf("Failed to transform %v: %v", source, err)
+			return fmt.Errorf("failed to transform notes: %w", err)
+		}
+		printDensityReport(cmd, targeting.Report(), len(newDeck.Cards))
+		printMixReport(cmd, targeting, newDeck.Cards)
+		printGroundingReport(cmd, grounding.Report())
 		if len(Title) > 0 {
 			newDeck.UpdateTitle(Title)
 		}
-
-		// Save Deck to Processing Dir For retry
+		if DedupExisting {
+			if newDeck, err = dropExistingDuplicates(ctx, cmd, ankiClient, newDeck); err != nil {
+				return err
+			}
+		}
 		jsonPath, err := transform.SaveDeck(newDeck)
 		if err != nil {
-			logger.Error("Failed to save deck to %v", jsonPath)
-			return fmt.Errorf("failed to save deck to %v", jsonPath)
+			logger.Errorf("Failed to save deck to %v: %v", jsonPath, err)
+			return fmt.Errorf("failed to save deck to %v: %w", jsonPath, err)
 		}
-
 		logger.Infof("Successfully Created %v deck JSON", newDeck.Title)
 
-		err = create.SendToAnki(newDeck, logger)
+		if Review {
+			if newDeck, err = reviewDeck(ctx, cmd, jsonPath, newDeck, source); err != nil {
+				return err
+			}
+			if len(newDeck.Cards) == 0 {
+				fmt.Fprintln(out, "No cards left to send to Anki")
+				generated++
+				continue
+			}
+		}
+		if FailOn != "" {
+			if err := lintDeck(cmd, newDeck, FailOn); err != nil {
+				return fmt.Errorf("%w, fix %v and poggers push it", err, jsonPath)
+			}
+		}
+
+		report, err := ankiClient.SendToAnki(ctx, newDeck)
 		if err != nil {
 			return err
 		}
-		logger.Infof("Successfully Created %v deck in anki", newDeck.Title)
-		return nil
-	},
+		if err := printPushReport(cmd, report); err != nil {
+			pushErrs = append(pushErrs, fmt.Errorf("%v: %w", change.Path, err))
+		}
+		generated++
+	}
+	if generated == 0 {
+		fmt.Fprintf(out, "No notes changed since %s\n", Since)
+	}
+	return errors.Join(pushErrs...)
+}
+
+// repoRelative returns path relative to the root of repo, in git's slash separated form.
+func repoRelative(repo, path string) (string, error) {
+	repo, err := filepath.EvalSymlinks(repo)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %v: %w", repo, err)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %v: %w", path, err)
+	}
+	relative, err := filepath.Rel(repo, resolved)
+	if err != nil {
+		return "", fmt.Errorf("failed to locate %v in %v: %w", path, repo, err)
+	}
+	return filepath.ToSlash(relative), nil
+}
+
+// dropExistingDuplicates drops the cards of deck that ask what a note already in the Anki deck
+// of the same name does, and lists them.
+func dropExistingDuplicates(ctx context.Context, cmd *cobra.Command, ankiClient *create.AnkiClient, deck transform.Deck) (transform.Deck, error) {
+	existing, err := ankiClient.DeckCards(ctx, deck.Title)
+	if err != nil {
+		return transform.Deck{}, err
+	}
+	var duplicates []transform.Duplicate
+	deck.Cards, duplicates = transform.Dedup(deck.Cards, transform.DedupOptions{Existing: existing})
+	if len(duplicates) == 0 {
+		return deck, nil
+	}
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Duplicates: %d card(s) already in %s or asked twice\n", len(duplicates), deck.Title)
+	for _, duplicate := range duplicates {
+		where := "new"
+		if duplicate.Existing {
+			where = "in Anki"
+		}
+		fmt.Fprintf(out, "  = %q (%s: %q, %.2f)\n", duplicate.Dropped.Front, where, duplicate.Kept.Front, duplicate.Similarity)
+	}
+	return deck, nil
+}
+
+// flagDensity returns the card density set by --cards-per-100-words, --min-cards and
+// --max-cards.
+func flagDensity() transform.Density {
+	return transform.Density{CardsPer100Words: CardsPer100Words, MinCards: MinCards, MaxCards: MaxCards}
+}
+
+// newTargeting returns the card density of the notes at path with the given content: the
+// flags, overridden by the notes' frontmatter.
+func newTargeting(path, content string) (*transform.Targeting, error) {
+	density, err := flagDensity().WithFrontmatter(content)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
+	}
+	mix, err := flagMix()
+	if err != nil {
+		return nil, err
+	}
+	return &transform.Targeting{Density: density, Mix: mix}, nil
+}
+
+// flagMix returns the mix of levels set by --mix, nil if there is none.
+func flagMix() (transform.Mix, error) {
+	if LevelMix == "" {
+		return nil, nil
+	}
+	mix, err := transform.ParseMix(LevelMix)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --mix: %w", err)
+	}
+	return mix, nil
+}
+
+// printMixReport compares the levels of the cards with the mix asked for, if any, and lists
+// the levels of the model's answers that were not recognized.
+func printMixReport(cmd *cobra.Command, targeting *transform.Targeting, cards []transform.Flashcards) {
+	out := cmd.OutOrStdout()
+	report := targeting.CheckMix(cards)
+	if len(targeting.Mix) == 0 {
+		if len(report.Unrecognized) > 0 {
+			fmt.Fprintf(out, "Mix: unrecognized level(s) %s\n", strings.Join(report.Unrecognized, ", "))
+		}
+		return
+	}
+	fmt.Fprintf(out, "Mix: %s\n", report.Summary())
+	for _, share := range report.Off() {
+		fmt.Fprintf(out, "  ! %s is %.0f%% of the cards, %g%% were asked for\n", share.Level, share.Actual, share.Target)
+	}
+}
+
+// printDensityReport reports the cards per 100 words of notes of a deck of cards cards.
+func printDensityReport(cmd *cobra.Command, report transform.DensityReport, cards int) {
+	// later steps drop cards too, the deck has the final count
+	report.Cards = cards
+	fmt.Fprintf(cmd.OutOrStdout(), "Density: %s\n", report.Summary())
+}
+
+// newRefinement returns the refinement set by --refine-passes and --rubric.
+func newRefinement() (transform.Refinement, error) {
+	if RefinePasses < 0 {
+		return transform.Refinement{}, errors.New("--refine-passes cannot be negative")
+	}
+	refinement := transform.Refinement{Passes: RefinePasses}
+	if RubricPath != "" {
+		rubric, err := os.ReadFile(RubricPath)
+		if err != nil {
+			return transform.Refinement{}, fmt.Errorf("failed to read rubric: %w", err)
+		}
+		refinement.Rubric = string(rubric)
+	}
+	return refinement, nil
+}
+
+// newGrounding returns the check of generated cards set by --grounding and --grounding-judge.
+func newGrounding() (*transform.Grounding, error) {
+	policy, err := transform.ParseGroundingPolicy(Grounding)
+	if err != nil {
+		return nil, err
+	}
+	return &transform.Grounding{Policy: policy, Judge: GroundingJudge}, nil
+}
+
+// printGroundingReport lists the cards the notes do not support, with each unsupported claim.
+func printGroundingReport(cmd *cobra.Command, report transform.GroundingReport) {
+	if report.Checked == 0 {
+		return
+	}
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Grounding: %s\n", report.Summary())
+	actions := map[transform.GroundingPolicy]string{
+		transform.GroundingFlag: "flagged",
+		transform.GroundingTag:  "tagged " + transform.UNGROUNDED_TAG,
+		transform.GroundingDrop: "dropped",
+	}
+	for _, finding := range report.Findings {
+		fmt.Fprintf(out, "  ! %q (%s)\n", finding.Card.Front, actions[finding.Action])
+		for _, claim := range finding.Claims {
+			fmt.Fprintf(out, "      %q: %s\n", claim.Claim, claim.Reason)
+		}
+	}
+}
+
+// withResponseCache adds the response cache to ctx unless --no-cache is set.
+func withResponseCache(ctx context.Context) (context.Context, error) {
+	if NoCache {
+		return ctx, nil
+	}
+	responseCache, err := cache.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open response cache: %w", err)
+	}
+	return cache.WithCache(ctx, responseCache), nil
+}
+
+// printDeckDiff reports how the cards changed since the last run on the same notes.
+func printDeckDiff(cmd *cobra.Command, diff transform.DeckDiff) {
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Cards: %s\n", diff.Summary())
+	for _, change := range []struct {
+		mark  string
+		cards []transform.Flashcards
+	}{{"+", diff.Added}, {"~", diff.Changed}, {"-", diff.Removed}} {
+		for _, card := range change.cards {
+			fmt.Fprintf(out, "  %s %s\n", change.mark, card.Front)
+		}
+	}
+}
+
+// newUsageRun starts the usage run of generating path with the settings in ctx, refusing runs
+// whose estimate is already over --max-cost or --max-tokens. Only the parts that changed since
+// the last run are estimated, unless --full is set.
+func newUsageRun(ctx context.Context, path string) (*usage.Run, error) {
+	prices, err := loadPriceTable()
+	if err != nil {
+		return nil, err
+	}
+	run := usage.NewRun(path, usage.Budget{MaxCost: MaxCost, MaxTokens: MaxTokens}, prices)
+	run.Deck = Title
+	if MaxCost <= 0 && MaxTokens <= 0 {
+		return run, nil
+	}
+
+	plan, err := transform.PlanChanges(ctx, path, Full, prices)
+	if err != nil {
+		return nil, fmt.Errorf("failed to estimate usage of %v: %w", path, err)
+	}
+	if err := run.Allow(plan.Model, plan.InputTokens, plan.OutputTokens); err != nil {
+		return nil, fmt.Errorf("estimated usage of %v: %w", path, err)
+	}
+	return run, nil
+}
+
+// withGenerationSettings puts the model, refinement, grounding, summary pass and targeting of the
+// flags, and of the frontmatter of path, in ctx.
+func withGenerationSettings(ctx context.Context, path string) (context.Context, *transform.Targeting, *transform.Grounding, error) {
+	refinement, err := newRefinement()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	grounding, err := newGrounding()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ctx = transform.WithModel(ctx, openai.ChatModel(Model))
+	ctx = transform.WithGrounding(transform.WithRefinement(ctx, refinement), grounding)
+	ctx = transform.WithSummaryPass(ctx, Summarize)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to read %v: %w", path, err)
+	}
+	targeting, err := newTargeting(path, string(content))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return transform.WithTargeting(ctx, targeting), targeting, grounding, nil
+}
+
+// dryRun prints the chunks, prompts and estimated cost of generating FilePath.
+func dryRun(cmd *cobra.Command) error {
+	logger := logging.FromContext(cmd.Context())
+	path, err := utils.ValidateAndResolvePath(FilePath, logger)
+	if err != nil {
+		return fmt.Errorf("validation error for file path: %w", err)
+	}
+
+	prices, err := loadPriceTable()
+	if err != nil {
+		return err
+	}
+	ctx, _, _, err := withGenerationSettings(cmd.Context(), path)
+	if err != nil {
+		return err
+	}
+	plan, err := transform.PlanDocument(ctx, path, prices)
+	if err != nil {
+		return fmt.Errorf("failed to plan %v: %w", path, err)
+	}
+
+	out := cmd.OutOrStdout()
+	for _, chunk := range plan.Chunks {
+		fmt.Fprintf(out, "=== chunk %d/%d: %d words, ~%d tokens (input ~%d, output ~%d) ===\n",
+			chunk.Index, len(plan.Chunks), chunk.Words, chunk.ChunkTokens, chunk.InputTokens, chunk.OutputTokens)
+		fmt.Fprintln(out, chunk.Prompt)
+	}
+	fmt.Fprintf(out, "Model: %s\n", plan.Model)
+	fmt.Fprintf(out, "Chunks: %d\n", len(plan.Chunks))
+	for _, stage := range plan.Stages {
+		fmt.Fprintf(out, "Calls to %s: %d (input ~%d, output ~%d)\n", stage.Name, stage.Calls, stage.InputTokens, stage.OutputTokens)
+	}
+	fmt.Fprintf(out, "Estimated tokens: ~%d input, ~%d output\n", plan.InputTokens, plan.OutputTokens)
+	fmt.Fprintf(out, "Repair calls if every answer needs them: up to %d (input ~%d, output ~%d)\n", plan.Repairs.Calls, plan.Repairs.InputTokens, plan.Repairs.OutputTokens)
+	if plan.Priced {
+		fmt.Fprintf(out, "Estimated cost: ~%s, at most ~%s with every repair\n", usage.FormatCost(plan.Cost), usage.FormatCost(plan.MaxCost))
+	} else {
+		fmt.Fprintf(out, "Estimated cost: unknown, no price for %s (add it with --price-table)\n", plan.Model)
+	}
+	return nil
+}
+
+// loadPriceTable loads --price-table, or prices.json from the processing dir if there is one.
+func loadPriceTable() (usage.PriceTable, error) {
+	path := PriceTablePath
+	if path == "" {
+		if dir, err := utils.CreateProcessingDir(); err == nil {
+			candidate := filepath.Join(dir, PRICE_TABLE_FILE)
+			if _, err := os.Stat(candidate); err == nil {
+				path = candidate
+			}
+		}
+	}
+	return usage.LoadPriceTable(path)
 }
 
 func init() {
 	rootCmd.AddCommand(generateCmd)
 
 	// Add file flag
-	generateCmd.Flags().StringVarP(&FilePath, "file", "f", "", "Path to .md/.txt file (required)")
-	generateCmd.MarkFlagRequired("file")
+	generateCmd.Flags().StringVarP(&FilePath, "file", "f", "", "Path to .md/.txt file (required unless --since is given)")
 	// Add title flag
 	generateCmd.Flags().StringVarP(&Title, "title", "t", "", "Title for the generated deck of flashcards (optional) will be automatically generated")
+	generateCmd.Flags().BoolVar(&DryRun, "dry-run", false, "Show chunks, prompts and estimated tokens and cost, with the summary, refinement and judge calls and at most every repair, without calling the model or Anki")
+	generateCmd.Flags().Float64Var(&MaxCost, "max-cost", 0, "Abort before the run would cost more than this many US dollars")
+	generateCmd.Flags().IntVar(&MaxTokens, "max-tokens", 0, "Abort before the run would use more than this many tokens")
+	generateCmd.Flags().StringVar(&Since, "since", "", "Only generate cards for the lines of notes changed between this git revision and HEAD, each with its section as context and one deck per file; --file narrows it to one file")
+	generateCmd.Flags().StringVar(&FailOn, "fail-on", "", "Lint the deck like poggers lint and do not send it to Anki if a finding is at least this severe: info, warning or error")
+	generateCmd.Flags().StringVar(&LintConfigPath, "lint-config", "", "JSON file setting lint rule severities and limits")
+	generateCmd.Flags().BoolVar(&Review, "review", false, "Accept, reject, edit, retag or regenerate every card in the terminal before sending the deck to Anki, see poggers review")
+	generateCmd.Flags().BoolVar(&NoCache, "no-cache", false, "Ask the model again instead of reusing decks cached for unchanged chunks, see poggers cache")
+	addGenerationFlags(generateCmd)
+}
+
+// addGenerationFlags adds the flags shared by generate and watch that shape the cards of a file.
+func addGenerationFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&Model, "model", "", "OpenAI model to generate with (default "+string(transform.DefaultModel)+")")
+	cmd.Flags().StringVar(&PriceTablePath, "price-table", "", "JSON file of model prices in USD per million tokens, e.g. {\"gpt-4o-mini\": {\"input\": 0.15, \"output\": 0.6}}, over the built-in prices (default prices.json in the processing dir)")
+	cmd.Flags().BoolVar(&Full, "full", false, "Regenerate every section instead of reusing the cards of unchanged ones")
+	cmd.Flags().Float64Var(&DedupThreshold, "dedup-threshold", DedupThreshold, "Similarity of two fronts, from 0 to 1, from which cards are duplicates and only the one with the most informative answer is kept (0 disables)")
+	cmd.Flags().BoolVar(&DedupExisting, "dedup-existing", false, "Drop cards that are already in the target Anki deck")
+	cmd.Flags().Float64Var(&CardsPer100Words, "cards-per-100-words", 0, "How many cards to ask for per 100 words of notes (0 leaves it to the model); the cards_per_100_words key of a note's frontmatter overrides it")
+	cmd.Flags().IntVar(&MinCards, "min-cards", 0, "Fewest cards to ask for per chunk; the min_cards frontmatter key overrides it")
+	cmd.Flags().IntVar(&MaxCards, "max-cards", 0, "Most cards to keep per chunk, the best ones by answer detail; the max_cards frontmatter key overrides it")
+	cmd.Flags().StringVar(&LevelMix, "mix", "", "Share of cards per level in percent, e.g. recall=40,apply=40,analyze=20, kept when trimming and reported against the deck")
+	cmd.Flags().IntVar(&RepairAttempts, "repair-attempts", RepairAttempts, "How many times to show the model why its answer is not a valid deck and ask it to fix it")
+	cmd.Flags().BoolVar(&NoStructuredOutput, "no-structured-output", false, "Write the JSON schema of answers in the prompt, for servers that reject the json_schema response format")
+	cmd.Flags().BoolVar(&Summarize, "summarize", false, "Summarize the whole file first, once per content, and send its outline and glossary along with every chunk; a changed summary alone does not regenerate unchanged sections")
+	cmd.Flags().IntVar(&RefinePasses, "refine-passes", 0, "Have the model critique and revise the cards of every chunk against the rubric this many times, logged to refinements.jsonl in the processing dir")
+	cmd.Flags().StringVar(&RubricPath, "rubric", "", "Text file with the rubric the refinement passes hold cards to (default atomicity, clarity, correctness and difficulty mix)")
+	cmd.Flags().StringVar(&Grounding, "grounding", Grounding, "What to do with cards whose numbers, key terms or words are not in the notes: flag, tag (\"ungrounded\"), drop or off")
+	cmd.Flags().BoolVar(&GroundingJudge, "grounding-judge", false, "Also ask the model whether the notes support every card, and let its verdict decide")
 }
@@ -4,6 +4,8 @@ Copyright © 2025 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+	"fmt"
+
 	"github.com/jaxxk/anki-cards-generator/internal/encryption"
 	"github.com/jaxxk/anki-cards-generator/pkg/logging"
 	"github.com/spf13/cobra"
@@ -16,10 +18,22 @@ var generateEncryptionCmd = &cobra.Command{
 	Long: `To generate an encryption key, run:
 poggers addKey generateEncryption
 
-make sure to source the shell first`,
+make sure to source the shell first.
+
+If secrets are already encrypted with an existing key, use poggers addKey rotate instead.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := cmd.Context()
 		logger := logging.FromContext(ctx)
+		if _, err := encryption.GetEncKey(); err == nil && !ForceEncryption {
+			files, err := encryption.EncryptedFiles()
+			if err != nil {
+				return err
+			}
+			if len(files) > 0 {
+				logger.Errorf("An encryption key already protects %d file(s)", len(files))
+				return fmt.Errorf("encryption key already in use, run poggers addKey rotate or pass --force to discard stored secrets")
+			}
+		}
 		err := encryption.CreateEncryptionKey()
 		if err != nil {
 			logger.Errorf("Failed to generate encryption key: %v", err)
@@ -29,6 +43,8 @@ make sure to source the shell first`,
 	},
 }
 
-func init() {
+var ForceEncryption bool
 
+func init() {
+	generateEncryptionCmd.Flags().BoolVar(&ForceEncryption, "force", false, "Generate a new key even if existing secrets can no longer be decrypted")
 }
@@ -2,14 +2,25 @@ package cmd
 
 import (
 	"bytes"
+	"context"
+	"flag"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/jaxxk/anki-cards-generator/internal/create"
+	"github.com/jaxxk/anki-cards-generator/internal/create/ankitest"
+	"github.com/jaxxk/anki-cards-generator/internal/transform"
+	"github.com/jaxxk/anki-cards-generator/internal/transform/llmtest"
 	"github.com/spf13/cobra"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 )
 
+var record = flag.Bool("record", false, "regenerate the cassettes in testdata/cassettes from the llmtest fake")
+
 func TestGenerateCmd(t *testing.T) {
 	// Path to testdata directory
 	testdataDir := "testdata"
@@ -53,6 +64,12 @@ func TestGenerateCmd(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			output := new(bytes.Buffer)
 
+			// Run offline against a fake Anki and the recorded LLM exchanges
+			fakeAnki, ankiURL := ankitest.Start(t)
+			t.Setenv(create.ANKI_URL_ENV, ankiURL)
+			cassetteName := strings.ReplaceAll(strings.ToLower(tt.name), " ", "_")
+			llmtest.UseCassette(t, filepath.Join(sampleDataPath, "cassettes", cassetteName+".json"), *record && !tt.expectError)
+
 			// Initialize the command
 			cmd := &cobra.Command{
 				Use:   generateCmd.Use,
@@ -71,7 +88,222 @@ func TestGenerateCmd(t *testing.T) {
 				assert.Error(t, err, "expected an error but got none")
 			} else {
 				assert.NoError(t, err, "expected no error")
+				assert.NotEmpty(t, fakeAnki.Notes("Fake Deck"), "cards should have been pushed to Anki")
 			}
 		})
 	}
 }
+
+func TestGenerateDryRun(t *testing.T) {
+	wd, _ := os.Getwd()
+	samplePath := filepath.Join(wd, "testdata", "sample1.md")
+
+	// no cassette or Anki: a dry run must not touch either
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(create.ANKI_URL_ENV, "http://127.0.0.1:1")
+
+	output := new(bytes.Buffer)
+	cmd := &cobra.Command{
+		Use:  generateCmd.Use,
+		RunE: generateCmd.RunE,
+	}
+	cmd.Flags().StringVarP(&FilePath, "file", "f", "", "path to md/txt file (required)")
+	cmd.Flags().BoolVar(&DryRun, "dry-run", false, "")
+	cmd.Flags().StringVar(&Model, "model", "", "")
+	cmd.Flags().StringVar(&PriceTablePath, "price-table", "", "")
+	t.Cleanup(func() { DryRun, Model = false, "" })
+	cmd.SetArgs([]string{"-f", samplePath, "--dry-run"})
+	cmd.SetOut(output)
+	cmd.SetErr(output)
+
+	assert.NoError(t, cmd.Execute())
+	assert.Contains(t, output.String(), "=== chunk 1/")
+	assert.Contains(t, output.String(), "[developer]")
+	assert.Contains(t, output.String(), "Estimated cost: ~$")
+}
+
+func TestGenerateSince(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	repo := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", repo}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_SYSTEM=/dev/null",
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		out, err := cmd.CombinedOutput()
+		assert.NoError(t, err, string(out))
+	}
+	notesPath := filepath.Join(repo, "go.md")
+	git("init", "-q")
+	assert.NoError(t, os.WriteFile(notesPath, []byte("# Go\n## Goroutines\nGoroutines are cheap.\n"), 0644))
+	git("add", "-A")
+	git("commit", "-q", "-m", "first")
+	assert.NoError(t, os.WriteFile(notesPath, []byte("# Go\n## Goroutines\nGoroutines are cheap.\n\n## Channels\nChannels are typed conduits.\n"), 0644))
+	git("commit", "-q", "-am", "second")
+
+	t.Setenv("HOME", t.TempDir())
+	fakeAnki, ankiURL := ankitest.Start(t)
+	t.Setenv(create.ANKI_URL_ENV, ankiURL)
+	wd, _ := os.Getwd()
+	llmtest.UseCassette(t, filepath.Join(wd, "testdata", "cassettes", "since.json"), *record)
+
+	output := new(bytes.Buffer)
+	cmd := &cobra.Command{
+		Use:  generateCmd.Use,
+		RunE: generateCmd.RunE,
+	}
+	cmd.Flags().StringVarP(&FilePath, "file", "f", "", "")
+	cmd.Flags().StringVar(&Since, "since", "", "")
+	t.Cleanup(func() { Since = "" })
+	cmd.SetArgs([]string{"-f", notesPath, "--since", "HEAD~1"})
+	cmd.SetOut(output)
+	cmd.SetErr(output)
+
+	assert.NoError(t, cmd.Execute())
+	assert.Contains(t, output.String(), "go.md: 1 changed section(s)")
+	assert.NotEmpty(t, fakeAnki.Notes("Fake Deck"))
+
+	// nothing changed since HEAD
+	output.Reset()
+	cmd.SetArgs([]string{"-f", notesPath, "--since", "HEAD"})
+	assert.NoError(t, cmd.Execute())
+	assert.Contains(t, output.String(), "No notes changed since HEAD")
+}
+
+func TestGenerateDedupExisting(t *testing.T) {
+	wd, _ := os.Getwd()
+	samplePath := filepath.Join(wd, "testdata", "sample1.md")
+	t.Setenv("HOME", t.TempDir())
+	fakeAnki, ankiURL := ankitest.Start(t)
+	t.Setenv(create.ANKI_URL_ENV, ankiURL)
+	// always replay, this test makes the same calls as "Valid File Path"
+	llmtest.UseCassette(t, filepath.Join(wd, "testdata", "cassettes", "valid_file_path.json"), false)
+
+	// the deck already asks the first question, in other words
+	client := create.NewAnkiClient(create.AnkiClientConfig{BaseURL: ankiURL, Logger: zap.NewNop().Sugar()})
+	_, err := client.SendToAnki(context.Background(), transform.Deck{Title: "Fake Deck", Cards: []transform.Flashcards{
+		{Front: "What does the note say about Sample Markdown", Back: "It is a sample."},
+	}})
+	assert.NoError(t, err)
+
+	output := new(bytes.Buffer)
+	cmd := &cobra.Command{
+		Use:  generateCmd.Use,
+		RunE: generateCmd.RunE,
+	}
+	cmd.Flags().StringVarP(&FilePath, "file", "f", "", "")
+	cmd.Flags().BoolVar(&DedupExisting, "dedup-existing", false, "")
+	t.Cleanup(func() { DedupExisting = false })
+	cmd.SetArgs([]string{"-f", samplePath, "--dedup-existing"})
+	cmd.SetOut(output)
+	cmd.SetErr(output)
+
+	assert.NoError(t, cmd.Execute())
+	assert.Contains(t, output.String(), "Duplicates: 1 card(s) already in Fake Deck")
+	assert.Contains(t, output.String(), `(in Anki: "What does the note say about Sample Markdown"`)
+	assert.Len(t, fakeAnki.Notes("Fake Deck"), 3)
+}
+
+func TestGenerateGrounding(t *testing.T) {
+	wd, _ := os.Getwd()
+	samplePath := filepath.Join(wd, "testdata", "sample1.md")
+	t.Setenv("HOME", t.TempDir())
+	_, ankiURL := ankitest.Start(t)
+	t.Setenv(create.ANKI_URL_ENV, ankiURL)
+	// always replay, this test makes the same calls as "Valid File Path"
+	llmtest.UseCassette(t, filepath.Join(wd, "testdata", "cassettes", "valid_file_path.json"), false)
+
+	run := func(args ...string) (string, error) {
+		output := new(bytes.Buffer)
+		cmd := &cobra.Command{
+			Use:  generateCmd.Use,
+			RunE: generateCmd.RunE,
+		}
+		cmd.Flags().StringVarP(&FilePath, "file", "f", "", "")
+		cmd.Flags().StringVar(&Grounding, "grounding", string(transform.GroundingFlag), "")
+		cmd.SetArgs(append([]string{"-f", samplePath}, args...))
+		cmd.SetOut(output)
+		cmd.SetErr(output)
+		err := cmd.Execute()
+		return output.String(), err
+	}
+	t.Cleanup(func() { Grounding = string(transform.GroundingFlag) })
+
+	_, err := run("--grounding", "ignore")
+	assert.ErrorContains(t, err, "unknown grounding policy")
+
+	// the fake quotes its answers from the notes
+	output, err := run()
+	assert.NoError(t, err)
+	assert.Contains(t, output, "Grounding: 0 of 3 card(s) unsupported by the notes")
+	assert.Contains(t, output, "Density: 3 card(s) from ")
+}
+
+func TestPrintGroundingReport(t *testing.T) {
+	output := new(bytes.Buffer)
+	cmd := &cobra.Command{}
+	cmd.SetOut(output)
+	printGroundingReport(cmd, transform.GroundingReport{Checked: 2, Findings: []transform.GroundingFinding{{
+		Card:   transform.Flashcards{Front: "What is the default capacity?"},
+		Claims: []transform.UnsupportedClaim{{Claim: "The default capacity is 64.", Reason: "64 not in the notes"}},
+		Action: transform.GroundingTag,
+	}}})
+	assert.Equal(t, `Grounding: 1 of 2 card(s) unsupported by the notes
+  ! "What is the default capacity?" (tagged ungrounded)
+      "The default capacity is 64.": 64 not in the notes
+`, output.String())
+}
+
+func TestNewRefinement(t *testing.T) {
+	t.Cleanup(func() { RefinePasses, RubricPath = 0, "" })
+
+	RefinePasses = -1
+	_, err := newRefinement()
+	assert.ErrorContains(t, err, "cannot be negative")
+
+	rubricPath := filepath.Join(t.TempDir(), "rubric.md")
+	assert.NoError(t, os.WriteFile(rubricPath, []byte("- One fact per card."), 0600))
+	RefinePasses, RubricPath = 2, rubricPath
+	refinement, err := newRefinement()
+	assert.NoError(t, err)
+	assert.Equal(t, transform.Refinement{Passes: 2, Rubric: "- One fact per card."}, refinement)
+
+	RubricPath = filepath.Join(t.TempDir(), "missing.md")
+	_, err = newRefinement()
+	assert.ErrorContains(t, err, "failed to read rubric")
+}
+
+func TestNewTargeting(t *testing.T) {
+	t.Cleanup(func() { CardsPer100Words, MinCards, MaxCards = 0, 0, 0 })
+	CardsPer100Words, MaxCards = 2, 5
+
+	targeting, err := newTargeting("notes.md", "---\nmin_cards: 1\nmax_cards: 3\n---\n# Notes\n")
+	assert.NoError(t, err)
+	assert.Equal(t, transform.Density{CardsPer100Words: 2, MinCards: 1, MaxCards: 3}, targeting.Density)
+
+	_, err = newTargeting("notes.md", "---\nmin_cards: 9\n---\n")
+	assert.ErrorContains(t, err, "notes.md: invalid card density in frontmatter")
+}
+
+func TestPrintMixReport(t *testing.T) {
+	t.Cleanup(func() { LevelMix = "" })
+	LevelMix = "recall=50,apply=50"
+	mix, err := flagMix()
+	assert.NoError(t, err)
+
+	output := new(bytes.Buffer)
+	cmd := &cobra.Command{}
+	cmd.SetOut(output)
+	printMixReport(cmd, &transform.Targeting{Mix: mix}, []transform.Flashcards{{Level: transform.LevelRecall}, {Level: transform.LevelRecall}, {Level: transform.LevelRecall}, {Level: transform.LevelApply}})
+	assert.Equal(t, `Mix: recall 75% (target 50%), apply 25% (target 50%)
+  ! recall is 75% of the cards, 50% were asked for
+  ! apply is 25% of the cards, 50% were asked for
+`, output.String())
+
+	LevelMix = "recall=50"
+	_, err = flagMix()
+	assert.ErrorContains(t, err, "invalid --mix")
+}
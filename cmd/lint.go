@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/jaxxk/anki-cards-generator/internal/lint"
+	"github.com/jaxxk/anki-cards-generator/internal/transform"
+	"github.com/jaxxk/anki-cards-generator/pkg/logging"
+	"github.com/jaxxk/anki-cards-generator/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+// LINT_CONFIG_FILE is read from the processing dir when --lint-config is not given.
+var LINT_CONFIG_FILE = "lint.json"
+
+var LintConfigPath string
+var LintFailOn string
+var FailOn string
+
+// lintCmd represents the lint command
+var lintCmd = &cobra.Command{
+	Use:   "lint <deck.json>",
+	Short: "Checks the cards of a saved deck for common quality problems",
+	Long: `The "lint" command checks every card of a deck saved by "generate" against rules
+	based on the minimum information principle:
+
+	  empty-field     the front or the back is empty (error)
+	  answer-leakage  the front gives the answer away (error)
+	  not-a-question  the front is neither a question nor a prompt (warning)
+	  back-length     the back is longer than maxBackWords, 80 by default (warning)
+	  multiple-facts  the card asks several questions or lists many items (warning)
+	  vague-pronoun   the front asks about "it" or "this" (warning)
+	  code-fence      code in the back is not in a ` + "```" + ` block (info)
+
+	Severities and limits are read from --lint-config, or lint.json in the processing
+	dir, e.g. {"rules": {"back-length": "error", "vague-pronoun": "off"}, "maxBackWords": 60}.
+
+	The command fails when a finding is at least as severe as --fail-on, so it can
+	gate a CI job. generate --fail-on lints the new deck the same way before pushing it.
+
+	Example Usage:
+	poggers lint ~/.anki-cards-generator/deck-<id>.json
+	poggers lint deck.json --fail-on warning
+	`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := logging.FromContext(cmd.Context())
+		deckPath, err := utils.ValidateAndResolvePath(args[0], logger)
+		if err != nil {
+			return fmt.Errorf("validation error for deck path: %w", err)
+		}
+		deck, err := transform.LoadDeck(deckPath)
+		if err != nil {
+			return err
+		}
+		return lintDeck(cmd, deck, LintFailOn)
+	},
+}
+
+// lintDeck prints the findings of linting deck and fails if one is at least as severe as failOn.
+func lintDeck(cmd *cobra.Command, deck transform.Deck, failOn string) error {
+	threshold, err := lint.ParseSeverity(failOn)
+	if err != nil {
+		return fmt.Errorf("invalid --fail-on: %w", err)
+	}
+	config, err := loadLintConfig()
+	if err != nil {
+		return err
+	}
+
+	report := lint.Lint(deck, config)
+	out := cmd.OutOrStdout()
+	for _, finding := range report.Findings {
+		fmt.Fprintf(out, "card %d %q: %s %s: %s\n", finding.Card+1, finding.Front, finding.Severity, finding.Rule, finding.Message)
+	}
+	fmt.Fprintf(out, "Lint: %s\n", report.Summary())
+	if report.Fails(threshold) {
+		return fmt.Errorf("lint found problems of severity %s or higher", threshold)
+	}
+	return nil
+}
+
+// loadLintConfig loads --lint-config, or lint.json from the processing dir if there is one.
+func loadLintConfig() (lint.Config, error) {
+	path := LintConfigPath
+	if path == "" {
+		if dir, err := utils.CreateProcessingDir(); err == nil {
+			candidate := filepath.Join(dir, LINT_CONFIG_FILE)
+			if _, err := os.Stat(candidate); err == nil {
+				path = candidate
+			}
+		}
+	}
+	return lint.LoadConfig(path)
+}
+
+func init() {
+	rootCmd.AddCommand(lintCmd)
+
+	lintCmd.Flags().StringVar(&LintFailOn, "fail-on", "error", "Fail when a finding is at least this severe: info, warning, error or none")
+	lintCmd.Flags().StringVar(&LintConfigPath, "lint-config", "", "JSON file setting rule severities and limits")
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jaxxk/anki-cards-generator/internal/transform"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLintCmd(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	deckPath, err := transform.SaveDeck(transform.Deck{Title: "Lint", Cards: []transform.Flashcards{
+		{Front: "What is a goroutine?", Back: "A function running concurrently."},
+		{Front: "What does it return?", Back: "An error."},
+		{Front: "Which package provides Mutex, the sync package?", Back: "sync"},
+	}})
+	assert.NoError(t, err)
+
+	run := func(args ...string) (string, error) {
+		output := new(bytes.Buffer)
+		cmd := &cobra.Command{
+			Use:  lintCmd.Use,
+			Args: lintCmd.Args,
+			RunE: lintCmd.RunE,
+		}
+		cmd.Flags().StringVar(&LintFailOn, "fail-on", "error", "")
+		cmd.Flags().StringVar(&LintConfigPath, "lint-config", "", "")
+		cmd.SetArgs(args)
+		cmd.SetOut(output)
+		cmd.SetErr(output)
+		err := cmd.Execute()
+		return output.String(), err
+	}
+	t.Cleanup(func() { LintFailOn, LintConfigPath = "error", "" })
+
+	output, err := run(deckPath)
+	assert.ErrorContains(t, err, "severity error or higher")
+	assert.Contains(t, output, `card 2 "What does it return?": warning vague-pronoun:`)
+	assert.Contains(t, output, `card 3 "Which package provides Mutex, the sync package?": error answer-leakage:`)
+	assert.Contains(t, output, "Lint: 3 card(s): 1 error(s), 1 warning(s), 0 info")
+
+	_, err = run(deckPath, "--fail-on", "none")
+	assert.NoError(t, err)
+
+	// the config turns rules off
+	configPath := filepath.Join(t.TempDir(), "lint.json")
+	assert.NoError(t, os.WriteFile(configPath, []byte(`{"rules": {"answer-leakage": "off"}}`), 0644))
+	output, err = run(deckPath, "--lint-config", configPath)
+	assert.NoError(t, err)
+	assert.Contains(t, output, "0 error(s), 1 warning(s)")
+	_, err = run(deckPath, "--lint-config", configPath, "--fail-on", "warning")
+	assert.Error(t, err)
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/jaxxk/anki-cards-generator/internal/transform"
+	"github.com/spf13/cobra"
+)
+
+// listCmd represents the list command
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the decks saved in the processing directory",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		decks, err := transform.ListDecks()
+		if err != nil {
+			return fmt.Errorf("failed to list decks: %w", err)
+		}
+		out := cmd.OutOrStdout()
+		for _, deck := range decks {
+			fmt.Fprintf(out, "%s  %4d cards  %-30s %s\n", deck.ModTime.Format("2006-01-02 15:04"), deck.Cards, deck.Title, deck.Path)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(listCmd)
+}
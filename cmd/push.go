@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/jaxxk/anki-cards-generator/internal/create"
+	"github.com/jaxxk/anki-cards-generator/internal/transform"
+	"github.com/jaxxk/anki-cards-generator/pkg/logging"
+	"github.com/jaxxk/anki-cards-generator/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+// pushCmd represents the push command
+var pushCmd = &cobra.Command{
+	Use:   "push <deck.json>",
+	Short: "Sends a saved deck JSON to Anki",
+	Long: `The "push" command retries sending a deck saved by "generate" to Anki.
+	Encrypted decks are decrypted transparently.
+
+	Example Usage:
+	poggers push ~/.anki-cards-generator/deck-<id>.json
+	`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		logger := logging.FromContext(ctx)
+
+		deckPath, err := utils.ValidateAndResolvePath(args[0], logger)
+		if err != nil {
+			return fmt.Errorf("validation error for deck path: %w", err)
+		}
+		deck, err := transform.LoadDeck(deckPath)
+		if err != nil {
+			return err
+		}
+
+		ankiClient, err := newAnkiClient(cmd)
+		if err != nil {
+			return err
+		}
+		report, err := ankiClient.SendToAnki(ctx, deck)
+		if err != nil {
+			return err
+		}
+		return printPushReport(cmd, report)
+	},
+}
+
+// printPushReport prints the outcome of a push and fails if any card could not be added.
+func printPushReport(cmd *cobra.Command, report create.PushReport) error {
+	out := cmd.OutOrStdout()
+	fmt.Fprintln(out, report.Summary())
+	for _, result := range report.Filter(create.CardSkipped) {
+		fmt.Fprintf(out, "  skipped: %q (%s)\n", result.Card.Front, result.Reason)
+	}
+	failed := report.Filter(create.CardFailed)
+	for _, result := range failed {
+		fmt.Fprintf(out, "  failed:  %q (%s)\n", result.Card.Front, result.Reason)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d card(s) could not be added to %s", len(failed), report.Deck)
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(pushCmd)
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jaxxk/anki-cards-generator/internal/review"
+	"github.com/jaxxk/anki-cards-generator/internal/transform"
+	"github.com/jaxxk/anki-cards-generator/pkg/logging"
+	"github.com/jaxxk/anki-cards-generator/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var Review bool
+var NoPush bool
+
+// reviewCmd represents the review command
+var reviewCmd = &cobra.Command{
+	Use:   "review <deck.json>",
+	Short: "Reviews the cards of a saved deck one at a time, then sends it to Anki",
+	Long: `The "review" command walks through the cards of a deck saved by "generate" and asks,
+	for each card, to accept, reject, edit it in $EDITOR, replace its tags or regenerate
+	it. The decisions are saved back into the deck JSON, which is then sent to Anki
+	unless --no-push is given. Quitting leaves the deck unchanged.
+
+	In the editor the front and the back of the card are separated by a "---" line.
+
+	Example Usage:
+	poggers review ~/.anki-cards-generator/deck-<id>.json
+	`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		logger := logging.FromContext(ctx)
+
+		deckPath, err := utils.ValidateAndResolvePath(args[0], logger)
+		if err != nil {
+			return fmt.Errorf("validation error for deck path: %w", err)
+		}
+		deck, err := transform.LoadDeck(deckPath)
+		if err != nil {
+			return err
+		}
+		deck, err = reviewDeck(ctx, cmd, deckPath, deck, deckPath)
+		if err != nil || NoPush {
+			return err
+		}
+		if len(deck.Cards) == 0 {
+			fmt.Fprintln(cmd.OutOrStdout(), "No cards left to send to Anki")
+			return nil
+		}
+
+		ankiClient, err := newAnkiClient(cmd)
+		if err != nil {
+			return err
+		}
+		report, err := ankiClient.SendToAnki(ctx, deck)
+		if err != nil {
+			return err
+		}
+		return printPushReport(cmd, report)
+	},
+}
+
+// reviewDeck reviews deck, saved at jsonPath, in the terminal and saves the decisions back into
+// it. Cards are regenerated from source.
+func reviewDeck(ctx context.Context, cmd *cobra.Command, jsonPath string, deck transform.Deck, source string) (transform.Deck, error) {
+	reviewer := &review.Reviewer{
+		In:   cmd.InOrStdin(),
+		Out:  cmd.OutOrStdout(),
+		Edit: review.ExternalEditor,
+		Regenerate: func(ctx context.Context, card transform.Flashcards) (transform.Flashcards, error) {
+			return transform.RegenerateCard(ctx, source, card)
+		},
+	}
+	reviewed, summary, err := reviewer.Review(ctx, deck)
+	if errors.Is(err, review.ErrAborted) {
+		return transform.Deck{}, fmt.Errorf("%w, %v was left unchanged", err, jsonPath)
+	}
+	if err != nil {
+		return transform.Deck{}, err
+	}
+
+	jsonPath, err = transform.WriteDeck(jsonPath, reviewed)
+	if err != nil {
+		return transform.Deck{}, fmt.Errorf("failed to save reviewed deck: %w", err)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Review: %s, saved to %s\n", summary, jsonPath)
+	return reviewed, nil
+}
+
+func init() {
+	rootCmd.AddCommand(reviewCmd)
+
+	reviewCmd.Flags().BoolVar(&NoPush, "no-push", false, "Only save the reviewed deck, without sending it to Anki")
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jaxxk/anki-cards-generator/internal/create"
+	"github.com/jaxxk/anki-cards-generator/internal/create/ankitest"
+	"github.com/jaxxk/anki-cards-generator/internal/transform"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func newReviewCmd(input string, output *bytes.Buffer, args ...string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:  reviewCmd.Use,
+		Args: reviewCmd.Args,
+		RunE: reviewCmd.RunE,
+	}
+	cmd.Flags().BoolVar(&NoPush, "no-push", false, "")
+	cmd.SetArgs(args)
+	cmd.SetIn(strings.NewReader(input))
+	cmd.SetOut(output)
+	cmd.SetErr(output)
+	return cmd
+}
+
+func TestReviewCmd(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	fakeAnki, ankiURL := ankitest.Start(t)
+	t.Setenv(create.ANKI_URL_ENV, ankiURL)
+	deckPath, err := transform.SaveDeck(transform.Deck{Title: "Review", Cards: []transform.Flashcards{
+		{Front: "Q1", Back: "A1"},
+		{Front: "Q2", Back: "A2"},
+	}})
+	assert.NoError(t, err)
+
+	// quitting changes nothing
+	output := new(bytes.Buffer)
+	err = newReviewCmd("q\n", output, deckPath).Execute()
+	assert.ErrorContains(t, err, "review aborted")
+	deck, err := transform.LoadDeck(deckPath)
+	assert.NoError(t, err)
+	assert.Len(t, deck.Cards, 2)
+
+	output.Reset()
+	assert.NoError(t, newReviewCmd("r\nt\nfavorite\na\n", output, deckPath).Execute())
+	assert.Contains(t, output.String(), "Review: 1 accepted, 1 rejected")
+
+	// the decisions are saved before the push
+	deck, err = transform.LoadDeck(deckPath)
+	assert.NoError(t, err)
+	assert.Equal(t, []transform.Flashcards{{Front: "Q2", Back: "A2", Tags: []string{"favorite"}}}, deck.Cards)
+	notes := fakeAnki.Notes("Review")
+	assert.Len(t, notes, 1)
+	assert.Equal(t, []string{"favorite"}, notes[0].Tags)
+}
+
+func TestReviewCmdNoPush(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(create.ANKI_URL_ENV, "http://127.0.0.1:1")
+	deckPath, err := transform.SaveDeck(transform.Deck{Title: "Review", Cards: []transform.Flashcards{{Front: "Q1", Back: "A1"}}})
+	assert.NoError(t, err)
+
+	t.Cleanup(func() { NoPush = false })
+	assert.NoError(t, newReviewCmd("r\n", new(bytes.Buffer), deckPath, "--no-push").Execute())
+	deck, err := transform.LoadDeck(deckPath)
+	assert.NoError(t, err)
+	assert.Empty(t, deck.Cards)
+}
@@ -3,7 +3,11 @@ package cmd
 import (
 	"context"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/jaxxk/anki-cards-generator/internal/create"
 	"github.com/jaxxk/anki-cards-generator/pkg/logging"
 	"github.com/spf13/cobra"
 )
@@ -24,15 +28,28 @@ func Execute() {
 	// Initialize the logger
 	logger := logging.NewLoggerFromEnv() // or use logging.DefaultLogger()
 
+	// Cancel the context on Ctrl-C so long running commands can stop cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	// Store the logger in the root command's context
-	ctx := logging.WithLogger(context.Background(), logger)
+	ctx = logging.WithLogger(ctx, logger)
 	rootCmd.SetContext(ctx)
 	err := rootCmd.Execute()
+	stop()
 	if err != nil {
 		os.Exit(1)
 	}
 }
 
+var AnkiURL string
+var AnkiTimeout time.Duration
+
+// newAnkiClient creates an AnkiConnect client from the --anki-url and --anki-timeout flags,
+// falling back to the ANKI_URL and ANKI_TIMEOUT environment variables.
+func newAnkiClient(cmd *cobra.Command) (*create.AnkiClient, error) {
+	logger := logging.FromContext(cmd.Context())
+	return create.NewAnkiClientFromEnv(AnkiURL, AnkiTimeout, logger)
+}
+
 func init() {
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
@@ -42,4 +59,6 @@ func init() {
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
 	rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	rootCmd.PersistentFlags().StringVar(&AnkiURL, "anki-url", "", "AnkiConnect endpoint, e.g. http://host.docker.internal:8765 (default "+create.ANKI_ENDPOINT+")")
+	rootCmd.PersistentFlags().DurationVar(&AnkiTimeout, "anki-timeout", 0, "Timeout for each AnkiConnect request (default 30s)")
 }
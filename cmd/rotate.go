@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"github.com/jaxxk/anki-cards-generator/internal/encryption"
+	"github.com/jaxxk/anki-cards-generator/pkg/logging"
+	"github.com/spf13/cobra"
+)
+
+// rotateCmd represents the addKey rotate command
+var rotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Rotates the encryption key and re-encrypts every stored secret",
+	Long: `To replace the encryption key without losing stored secrets, run:
+poggers addKey rotate
+
+Every encrypted file is decrypted with the current key, re-encrypted with a new one
+and verified before being replaced. The old key is removed from your shell
+configuration only after everything succeeded; make sure to source the shell afterwards.
+Until then the new key is kept in rotate.key in the processing dir, and running the
+command again after an interruption finishes the rotation.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		logger := logging.FromContext(ctx)
+		err := encryption.RotateEncryptionKey(logger)
+		if err != nil {
+			logger.Errorf("Failed to rotate encryption key: %v", err)
+			return err
+		}
+		return nil
+	},
+}
+
+func init() {
+	addKeyCmd.AddCommand(rotateCmd)
+}
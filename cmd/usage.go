@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jaxxk/anki-cards-generator/internal/usage"
+	"github.com/spf13/cobra"
+)
+
+var UsageGroupBy string
+var UsageSince string
+
+// usageCmd represents the usage command
+var usageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Reports the tokens and cost of past LLM calls",
+	Long: `The "usage" command sums the usage ledger kept in the processing directory,
+	grouped by day, model and deck by default.
+
+	Example Usage:
+	poggers usage
+	poggers usage --group-by model --since 2026-10-01
+	`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dims, err := usage.ParseGroupBy(UsageGroupBy)
+		if err != nil {
+			return err
+		}
+		entries, err := usage.Load()
+		if err != nil {
+			return err
+		}
+		if UsageSince != "" {
+			since, err := time.ParseInLocation("2006-01-02", UsageSince, time.Local)
+			if err != nil {
+				return fmt.Errorf("invalid --since date, expected YYYY-MM-DD: %w", err)
+			}
+			filtered := entries[:0]
+			for _, entry := range entries {
+				if !entry.Time.Before(since) {
+					filtered = append(filtered, entry)
+				}
+			}
+			entries = filtered
+		}
+
+		out := cmd.OutOrStdout()
+		if len(entries) == 0 {
+			fmt.Fprintln(out, "No usage recorded")
+			return nil
+		}
+		header := make([]string, len(dims))
+		for i, dim := range dims {
+			header[i] = strings.ToUpper(dim)
+		}
+		rows := usage.Summarize(entries, dims)
+		total := usage.Summarize(entries, nil)[0]
+		total.Key = make([]string, len(dims))
+		if len(dims) > 0 {
+			total.Key[0] = "TOTAL"
+		}
+
+		printUsageRow(cmd, header, "CALLS", "PROMPT", "COMPLETION", "COST")
+		for _, row := range append(rows, total) {
+			printUsageRow(cmd, row.Key, fmt.Sprint(row.Calls), fmt.Sprint(row.PromptTokens), fmt.Sprint(row.CompletionTokens), usage.FormatCost(row.Cost))
+		}
+		return nil
+	},
+}
+
+func printUsageRow(cmd *cobra.Command, key []string, calls, prompt, completion, cost string) {
+	out := cmd.OutOrStdout()
+	for _, value := range key {
+		fmt.Fprintf(out, "%-24s ", value)
+	}
+	fmt.Fprintf(out, "%6s %10s %10s %10s\n", calls, prompt, completion, cost)
+}
+
+func init() {
+	rootCmd.AddCommand(usageCmd)
+
+	usageCmd.Flags().StringVar(&UsageGroupBy, "group-by", "day,model,deck", "Comma separated dimensions to group by: day, model, deck")
+	usageCmd.Flags().StringVar(&UsageSince, "since", "", "Only count calls made on or after this date (YYYY-MM-DD)")
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jaxxk/anki-cards-generator/internal/transform"
+	"github.com/jaxxk/anki-cards-generator/internal/watch"
+	"github.com/jaxxk/anki-cards-generator/pkg/logging"
+	"github.com/spf13/cobra"
+)
+
+var WatchInterval time.Duration
+var WatchDebounce time.Duration
+
+// watchCmd represents the watch command
+var watchCmd = &cobra.Command{
+	Use:   "watch <dir>",
+	Short: "Regenerates cards whenever a note in a directory is saved",
+	Long: `The "watch" command polls a directory of .md and .txt notes and, once a file has
+	stopped changing for --debounce, runs the generate pipeline on it and sends the cards
+	to Anki, like poggers generate -f <file>.
+
+	Handled files are remembered in the processing dir, so after a restart only notes
+	that changed in the meantime are processed. Stop it with Ctrl-C.
+
+	The generate flags below apply to every file.
+
+	Example Usage:
+	poggers watch ~/notes
+	poggers watch ~/notes --interval 5s --debounce 10s
+	`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		logger := logging.FromContext(ctx)
+
+		transform.DefaultDuplicateThreshold = DedupThreshold
+		transform.DefaultRepairAttempts = RepairAttempts
+		transform.DefaultStructuredOutput = !NoStructuredOutput
+		if _, err := transform.ParseGroundingPolicy(Grounding); err != nil {
+			return err
+		}
+		if err := flagDensity().Validate(); err != nil {
+			return err
+		}
+		if _, err := flagMix(); err != nil {
+			return err
+		}
+
+		watcher, err := watch.New(args[0], WatchInterval, WatchDebounce)
+		if err != nil {
+			return err
+		}
+		ankiClient, err := newAnkiClient(cmd)
+		if err != nil {
+			return err
+		}
+		if ok, err := ankiClient.EnsureAnkiConnect(ctx); err != nil || !ok {
+			return errors.New("cannot connect to Anki Connect")
+		}
+
+		fmt.Fprintf(cmd.OutOrStdout(), "Watching %s, press Ctrl-C to stop\n", watcher.Dir)
+		err = watcher.Run(ctx, func(ctx context.Context, path string) error {
+			fmt.Fprintf(cmd.OutOrStdout(), "Processing %s\n", path)
+			return generateFile(ctx, cmd, ankiClient, path)
+		})
+		if err != nil {
+			return err
+		}
+		logger.Infof("Stopped watching %v", watcher.Dir)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+
+	watchCmd.Flags().DurationVar(&WatchInterval, "interval", watch.DefaultInterval, "How often to look for changed notes")
+	watchCmd.Flags().DurationVar(&WatchDebounce, "debounce", watch.DefaultDebounce, "How long a note must stay unchanged before it is processed")
+	watchCmd.Flags().StringVarP(&Title, "title", "t", "", "Title for the generated decks (optional) will be automatically generated")
+	watchCmd.Flags().Float64Var(&MaxCost, "max-cost", 0, "Skip a file when generating it would cost more than this many US dollars")
+	watchCmd.Flags().IntVar(&MaxTokens, "max-tokens", 0, "Skip a file when generating it would use more than this many tokens")
+	watchCmd.Flags().BoolVar(&NoCache, "no-cache", false, "Ask the model again instead of reusing cached decks")
+	addGenerationFlags(watchCmd)
+}
@@ -0,0 +1,253 @@
+// Package cache is a content-addressed store of generated results in the processing
+// directory, so work that was already paid for is not requested again.
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jaxxk/anki-cards-generator/internal/encryption"
+	"github.com/jaxxk/anki-cards-generator/pkg/utils"
+)
+
+// CACHE_DIR is the cache directory inside the processing directory.
+var CACHE_DIR = "cache"
+
+// TTL_ENV and MAX_SIZE_ENV name the environment variables overriding how long entries are
+// kept (a duration such as 168h) and how many bytes the cache may hold.
+var TTL_ENV = "POGGERS_CACHE_TTL"
+var MAX_SIZE_ENV = "POGGERS_CACHE_MAX_BYTES"
+
+// DefaultTTL and DefaultMaxSize apply when the environment does not override them.
+var DefaultTTL = 30 * 24 * time.Hour
+var DefaultMaxSize int64 = 64 << 20
+
+const entryExt = ".json"
+
+// Cache stores JSON values by key in a directory. Entries expire TTL after they were stored,
+// and the oldest ones are evicted once the directory grows past MaxSize.
+type Cache struct {
+	Dir     string
+	TTL     time.Duration
+	MaxSize int64
+	// now is replaced in tests
+	now func() time.Time
+}
+
+type entry struct {
+	Key       string          `json:"key"`
+	CreatedAt time.Time       `json:"createdAt"`
+	Value     json.RawMessage `json:"value"`
+}
+
+// Stats describes the contents of a cache.
+type Stats struct {
+	Entries int
+	Expired int
+	Bytes   int64
+	Oldest  time.Time
+	Newest  time.Time
+}
+
+// New returns a cache in dir.
+func New(dir string, ttl time.Duration, maxSize int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	return &Cache{Dir: dir, TTL: ttl, MaxSize: maxSize, now: time.Now}, nil
+}
+
+// Open returns the cache in the processing directory, configured from TTL_ENV and MAX_SIZE_ENV.
+func Open() (*Cache, error) {
+	processingPath, err := utils.CreateProcessingDir()
+	if err != nil {
+		return nil, err
+	}
+	ttl := DefaultTTL
+	if value := os.Getenv(TTL_ENV); value != "" {
+		if ttl, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", TTL_ENV, err)
+		}
+	}
+	maxSize := DefaultMaxSize
+	if value := os.Getenv(MAX_SIZE_ENV); value != "" {
+		if maxSize, err = strconv.ParseInt(value, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", MAX_SIZE_ENV, err)
+		}
+	}
+	return New(filepath.Join(processingPath, CACHE_DIR), ttl, maxSize)
+}
+
+// Key hashes parts into a cache key. Parts are length-prefixed so they cannot run together.
+func Key(parts ...[]byte) string {
+	sum := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintf(sum, "%d:", len(part))
+		sum.Write(part)
+	}
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.Dir, key+entryExt)
+}
+
+// Get reads the value stored under key into v. It reports false for missing and expired entries.
+func (c *Cache) Get(key string, v interface{}) (bool, error) {
+	var stored entry
+	if err := encryption.ReadJSONFile(c.path(key), &stored); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if stored.Key != key || c.expired(stored.CreatedAt) {
+		c.remove(key)
+		return false, nil
+	}
+	if err := json.Unmarshal(stored.Value, v); err != nil {
+		return false, fmt.Errorf("failed to parse cached value: %w", err)
+	}
+	return true, nil
+}
+
+// Put stores v under key, then evicts entries over the size limit.
+func (c *Cache) Put(key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to serialize cached value: %w", err)
+	}
+	if _, err := encryption.WriteJSONFile(entry{Key: key, CreatedAt: c.now().UTC(), Value: value}, c.Dir, key+entryExt); err != nil {
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	_, err = c.Evict()
+	return err
+}
+
+// Evict removes expired entries, then the oldest ones until the cache fits in MaxSize.
+// It returns the number of entries removed.
+func (c *Cache) Evict() (int, error) {
+	files, err := c.list()
+	if err != nil {
+		return 0, err
+	}
+	// entries are never rewritten, so the modification time is when they were stored
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+
+	var size int64
+	for _, file := range files {
+		size += file.Size()
+	}
+	removed := 0
+	for _, file := range files {
+		if !c.expired(file.ModTime()) && (c.MaxSize <= 0 || size <= c.MaxSize) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.Dir, file.Name())); err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("failed to evict cache entry: %w", err)
+		}
+		size -= file.Size()
+		removed++
+	}
+	return removed, nil
+}
+
+// Stats returns the number and size of entries.
+func (c *Cache) Stats() (Stats, error) {
+	files, err := c.list()
+	if err != nil {
+		return Stats{}, err
+	}
+	stats := Stats{}
+	for _, file := range files {
+		stats.Entries++
+		stats.Bytes += file.Size()
+		if c.expired(file.ModTime()) {
+			stats.Expired++
+		}
+		if stats.Oldest.IsZero() || file.ModTime().Before(stats.Oldest) {
+			stats.Oldest = file.ModTime()
+		}
+		if file.ModTime().After(stats.Newest) {
+			stats.Newest = file.ModTime()
+		}
+	}
+	return stats, nil
+}
+
+// Clear removes every entry and returns how many there were.
+func (c *Cache) Clear() (int, error) {
+	files, err := c.list()
+	if err != nil {
+		return 0, err
+	}
+	for _, file := range files {
+		if err := os.Remove(filepath.Join(c.Dir, file.Name())); err != nil && !os.IsNotExist(err) {
+			return 0, fmt.Errorf("failed to clear cache: %w", err)
+		}
+	}
+	return len(files), nil
+}
+
+func (c *Cache) expired(t time.Time) bool {
+	return c.TTL > 0 && c.now().Sub(t) > c.TTL
+}
+
+// files returns the paths an entry may be stored at, plain or encrypted.
+func (c *Cache) files(key string) []string {
+	return []string{c.path(key), c.path(key) + encryption.ENCRYPTED_EXT}
+}
+
+func (c *Cache) remove(key string) {
+	for _, path := range c.files(key) {
+		os.Remove(path)
+	}
+}
+
+// list returns the entries of the cache directory.
+func (c *Cache) list() ([]os.FileInfo, error) {
+	dirEntries, err := os.ReadDir(c.Dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+	var files []os.FileInfo
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if dirEntry.IsDir() || !(strings.HasSuffix(name, entryExt) || strings.HasSuffix(name, entryExt+encryption.ENCRYPTED_EXT)) {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, info)
+	}
+	return files, nil
+}
+
+type contextKey string
+
+const cacheKey = contextKey("cache")
+
+// WithCache stores c in the context. Code that generates results uses it when present.
+func WithCache(ctx context.Context, c *Cache) context.Context {
+	return context.WithValue(ctx, cacheKey, c)
+}
+
+// FromContext returns the cache stored in the context, or nil.
+func FromContext(ctx context.Context) *Cache {
+	c, _ := ctx.Value(cacheKey).(*Cache)
+	return c
+}
@@ -0,0 +1,121 @@
+package cache
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jaxxk/anki-cards-generator/internal/encryption"
+	"github.com/stretchr/testify/assert"
+)
+
+type value struct {
+	Title string
+	Cards []string
+}
+
+func newTestCache(t *testing.T, ttl time.Duration, maxSize int64) *Cache {
+	t.Helper()
+	c, err := New(t.TempDir(), ttl, maxSize)
+	assert.NoError(t, err)
+	return c
+}
+
+func TestGetPut(t *testing.T) {
+	c := newTestCache(t, time.Hour, 0)
+	key := Key([]byte("chunk"), []byte("model"))
+
+	var got value
+	ok, err := c.Get(key, &got)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	want := value{Title: "Go", Cards: []string{"a", "b"}}
+	assert.NoError(t, c.Put(key, want))
+	ok, err = c.Get(key, &got)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, want, got)
+}
+
+func TestKeySeparatesParts(t *testing.T) {
+	assert.NotEqual(t, Key([]byte("ab"), []byte("c")), Key([]byte("a"), []byte("bc")))
+	assert.Equal(t, Key([]byte("a")), Key([]byte("a")))
+}
+
+func TestTTL(t *testing.T) {
+	c := newTestCache(t, time.Hour, 0)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	assert.NoError(t, c.Put("k", value{Title: "old"}))
+
+	c.now = func() time.Time { return now.Add(2 * time.Hour) }
+	// the file's mtime is real time, push it back to match the fake clock
+	assert.NoError(t, os.Chtimes(c.path("k"), now, now))
+	stats, err := c.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats.Expired)
+
+	var got value
+	ok, err := c.Get("k", &got)
+	assert.NoError(t, err)
+	assert.False(t, ok, "expired entries are misses")
+	assert.NoFileExists(t, c.path("k"))
+}
+
+func TestEvictOldestOverSize(t *testing.T) {
+	c := newTestCache(t, 0, 0)
+	big := value{Title: strings.Repeat("x", 1000)}
+	base := time.Now().Add(-time.Hour)
+	for i, key := range []string{"first", "second", "third"} {
+		assert.NoError(t, c.Put(key, big))
+		stamp := base.Add(time.Duration(i) * time.Minute)
+		assert.NoError(t, os.Chtimes(c.path(key), stamp, stamp))
+	}
+
+	stats, err := c.Stats()
+	assert.NoError(t, err)
+	c.MaxSize = stats.Bytes - 1
+	removed, err := c.Evict()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.NoFileExists(t, c.path("first"))
+	assert.FileExists(t, c.path("third"))
+}
+
+func TestClear(t *testing.T) {
+	c := newTestCache(t, 0, 0)
+	assert.NoError(t, c.Put("a", value{}))
+	assert.NoError(t, c.Put("b", value{}))
+
+	removed, err := c.Clear()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	stats, err := c.Stats()
+	assert.NoError(t, err)
+	assert.Zero(t, stats.Entries)
+}
+
+func TestEncryptedAtRest(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	key, err := encryption.GenerateRandomKey()
+	assert.NoError(t, err)
+	t.Setenv(encryption.ENC_KEY, base64.StdEncoding.EncodeToString(key))
+	t.Setenv(encryption.ENCRYPT_AT_REST, "true")
+
+	c, err := Open()
+	assert.NoError(t, err)
+	assert.NoError(t, c.Put("k", value{Title: "private"}))
+	raw, err := os.ReadFile(filepath.Join(c.Dir, "k.json"+encryption.ENCRYPTED_EXT))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(raw), "private")
+
+	var got value
+	ok, err := c.Get("k", &got)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "private", got.Title)
+}
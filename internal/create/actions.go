@@ -0,0 +1,112 @@
+package create
+
+import (
+	"context"
+)
+
+// DeckParams are the params of actions that take a single deck name.
+type DeckParams struct {
+	Deck string `json:"deck"`
+}
+
+// DeleteDecksParams are the params of the deleteDecks action.
+type DeleteDecksParams struct {
+	Decks    []string `json:"decks"`
+	CardsToo bool     `json:"cardsToo"`
+}
+
+// FindNotesParams are the params of the findNotes action.
+type FindNotesParams struct {
+	Query string `json:"query"`
+}
+
+// NotesInfoParams are the params of the notesInfo action.
+type NotesInfoParams struct {
+	Notes []int64 `json:"notes"`
+}
+
+// NoteField is a single field of a note returned by notesInfo.
+type NoteField struct {
+	Value string `json:"value"`
+	Order int    `json:"order"`
+}
+
+// NoteInfo is a note returned by notesInfo.
+type NoteInfo struct {
+	NoteID    int64                `json:"noteId"`
+	ModelName string               `json:"modelName"`
+	Tags      []string             `json:"tags"`
+	Fields    map[string]NoteField `json:"fields"`
+	Cards     []int64              `json:"cards"`
+}
+
+// DeckConfig is the options group of a deck returned by getDeckConfig.
+type DeckConfig struct {
+	ID       int64  `json:"id"`
+	Name     string `json:"name"`
+	MaxTaken int    `json:"maxTaken"`
+	Autoplay bool   `json:"autoplay"`
+	New      struct {
+		PerDay int `json:"perDay"`
+	} `json:"new"`
+	Rev struct {
+		PerDay int `json:"perDay"`
+	} `json:"rev"`
+}
+
+// NoteParams are the params of the addNote action.
+type NoteParams struct {
+	Note Note `json:"note"`
+}
+
+// CanAddResult is the per-note result of canAddNotesWithErrorDetail.
+type CanAddResult struct {
+	CanAdd bool   `json:"canAdd"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Version returns the AnkiConnect API version.
+func (c *AnkiClient) Version(ctx context.Context) (int, error) {
+	return call[int](ctx, c, "version", nil)
+}
+
+// DeckNames returns the names of every deck in Anki.
+func (c *AnkiClient) DeckNames(ctx context.Context) ([]string, error) {
+	return call[[]string](ctx, c, "deckNames", nil)
+}
+
+// AddNotes adds notes to Anki. The result has one entry per note: its new ID, or nil when
+// that note could not be added.
+func (c *AnkiClient) AddNotes(ctx context.Context, notes []Note) ([]*int64, error) {
+	return call[[]*int64](ctx, c, "addNotes", Notes{ListOfNotes: notes})
+}
+
+// CanAddNotes reports for each note whether it could be added.
+func (c *AnkiClient) CanAddNotes(ctx context.Context, notes []Note) ([]bool, error) {
+	return call[[]bool](ctx, c, "canAddNotes", Notes{ListOfNotes: notes})
+}
+
+// FindNotes returns the IDs of the notes matching an Anki search query.
+func (c *AnkiClient) FindNotes(ctx context.Context, query string) ([]int64, error) {
+	return call[[]int64](ctx, c, "findNotes", FindNotesParams{Query: query})
+}
+
+// NotesInfo returns the fields, tags and cards of the given notes.
+func (c *AnkiClient) NotesInfo(ctx context.Context, noteIDs []int64) ([]NoteInfo, error) {
+	return call[[]NoteInfo](ctx, c, "notesInfo", NotesInfoParams{Notes: noteIDs})
+}
+
+// GetDeckConfig returns the options group of a deck.
+func (c *AnkiClient) GetDeckConfig(ctx context.Context, deckName string) (DeckConfig, error) {
+	return call[DeckConfig](ctx, c, "getDeckConfig", DeckParams{Deck: deckName})
+}
+
+// AddNote adds a single note and returns its ID.
+func (c *AnkiClient) AddNote(ctx context.Context, note Note) (int64, error) {
+	return call[int64](ctx, c, "addNote", NoteParams{Note: note})
+}
+
+// CanAddNotesWithErrorDetail reports for each note whether it could be added, and why not.
+func (c *AnkiClient) CanAddNotesWithErrorDetail(ctx context.Context, notes []Note) ([]CanAddResult, error) {
+	return call[[]CanAddResult](ctx, c, "canAddNotesWithErrorDetail", Notes{ListOfNotes: notes})
+}
@@ -0,0 +1,68 @@
+package create
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newCannedServer answers each AnkiConnect action with a fixed raw JSON reply.
+func newCannedServer(t *testing.T, replies map[string]string) *AnkiClient {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req AnkiRequestBody
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		reply, ok := replies[req.Action]
+		if !ok {
+			reply = `{"result": null, "error": "unsupported action"}`
+		}
+		w.Write([]byte(reply))
+	}))
+	t.Cleanup(server.Close)
+	return NewAnkiClient(AnkiClientConfig{BaseURL: server.URL})
+}
+
+func TestTypedActions(t *testing.T) {
+	client := newCannedServer(t, map[string]string{
+		"addNotes":  `{"result": [1496198395707, null], "error": null}`,
+		"findNotes": `{"result": [1496198395707], "error": null}`,
+		"notesInfo": `{"result": [{"noteId": 1496198395707, "modelName": "Basic", "tags": ["poggers"],
+			"fields": {"Front": {"value": "Q", "order": 0}, "Back": {"value": "A", "order": 1}}, "cards": [1498938915662]}], "error": null}`,
+		"canAddNotes":   `{"result": [true, false], "error": null}`,
+		"getDeckConfig": `{"result": {"id": 1, "name": "Default", "new": {"perDay": 20}, "rev": {"perDay": 200}}, "error": null}`,
+		"createDeck":    `{"result": null, "error": "deck was not found"}`,
+	})
+	ctx := context.Background()
+
+	ids, err := client.AddNotes(ctx, []Note{NewNote("Q", "A", "d"), NewNote("Q", "A", "d")})
+	assert.NoError(t, err)
+	assert.Len(t, ids, 2)
+	assert.Equal(t, int64(1496198395707), *ids[0])
+	assert.Nil(t, ids[1])
+
+	found, err := client.FindNotes(ctx, "deck:d")
+	assert.NoError(t, err)
+	assert.Equal(t, []int64{1496198395707}, found)
+
+	infos, err := client.NotesInfo(ctx, found)
+	assert.NoError(t, err)
+	assert.Equal(t, "A", infos[0].Fields["Back"].Value)
+	assert.Equal(t, []string{"poggers"}, infos[0].Tags)
+
+	canAdd, err := client.CanAddNotes(ctx, []Note{NewNote("Q", "A", "d"), NewNote("Q", "A", "d")})
+	assert.NoError(t, err)
+	assert.Equal(t, []bool{true, false}, canAdd)
+
+	config, err := client.GetDeckConfig(ctx, "Default")
+	assert.NoError(t, err)
+	assert.Equal(t, 20, config.New.PerDay)
+
+	_, err = client.CreateDeck(ctx, "d")
+	var ankiErr *AnkiError
+	assert.ErrorAs(t, err, &ankiErr)
+	assert.Equal(t, "deck was not found", ankiErr.Message)
+}
@@ -2,27 +2,34 @@ package create
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
-
-	"go.uber.org/zap"
 )
 
 var ANKI_ENDPOINT = "http://localhost:8765"
 
 // var ANKI_ENDPOINT = "http://host.docker.internal:8765"
 
-type AnkiConnectGenericResponse struct {
-	Result json.RawMessage `json:"result"`
-	Error  string          `json:"error"`
+// ankiResponse is the envelope of every AnkiConnect reply, with Result typed per action.
+type ankiResponse[T any] struct {
+	Result T       `json:"result"`
+	Error  *string `json:"error"`
 }
 
 // EnsureAnkiConnect checks if AnkiConnect is running
-func EnsureAnkiConnect() (bool, error) {
-	resp, err := http.Get(ANKI_ENDPOINT)
+func (c *AnkiClient) EnsureAnkiConnect(ctx context.Context) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return false, fmt.Errorf("failed to connect to AnkiConnect: %w", err)
 	}
@@ -34,8 +41,41 @@ func EnsureAnkiConnect() (bool, error) {
 	return true, nil
 }
 
-func processRequest(reqBody interface{}) (interface{}, error) {
-	var genericResp AnkiConnectGenericResponse
+// call invokes an AnkiConnect action and decodes its result into T.
+func call[T any](ctx context.Context, c *AnkiClient, action string, params AnkiParams) (T, error) {
+	var resp ankiResponse[T]
+
+	rawResp, err := c.post(ctx, NewAnkiRequestBody(action, params))
+	if err != nil {
+		return resp.Result, err
+	}
+
+	// Unmarshal into the typed response structure
+	if err := json.Unmarshal(rawResp, &resp); err != nil {
+		return resp.Result, fmt.Errorf("failed to parse %s response: %w", action, err)
+	}
+
+	// Handle errors from AnkiConnect
+	if resp.Error != nil && *resp.Error != "" {
+		return resp.Result, &AnkiError{Action: action, Message: *resp.Error}
+	}
+	return resp.Result, nil
+}
+
+// AnkiError is an error reported by AnkiConnect itself.
+type AnkiError struct {
+	Action  string
+	Message string
+}
+
+func (e *AnkiError) Error() string {
+	return fmt.Sprintf("anki error: %s: %s", e.Action, e.Message)
+}
+
+// post sends a request body to AnkiConnect and returns the raw response.
+func (c *AnkiClient) post(ctx context.Context, reqBody AnkiRequestBody) ([]byte, error) {
+	// Authenticate when AnkiConnect's apiKey setting is used
+	reqBody.Key = c.apiKey
 
 	// Serialize the request body to JSON
 	bodyBytes, err := json.Marshal(reqBody)
@@ -43,8 +83,16 @@ func processRequest(reqBody interface{}) (interface{}, error) {
 		return nil, fmt.Errorf("failed to serialize request body: %w", err)
 	}
 
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
 	// Make the HTTP POST request to AnkiConnect
-	resp, err := http.Post(ANKI_ENDPOINT, "application/json", bytes.NewReader(bodyBytes))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL, bytes.NewReader(bodyBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make POST request: %w", err)
 	}
@@ -60,114 +108,46 @@ func processRequest(reqBody interface{}) (interface{}, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response body: %w", err)
 	}
-
-	// Unmarshal into the generic response structure
-	if err := json.Unmarshal(rawResp, &genericResp); err != nil {
-		return nil, fmt.Errorf("failed to parse response: %w", err)
-	}
-
-	// Handle errors from AnkiConnect
-	if genericResp.Error != "" {
-		var decodedError string
-		if err := json.Unmarshal([]byte(genericResp.Error), &decodedError); err == nil {
-			return nil, fmt.Errorf("anki error: %s", decodedError)
-		}
-		return nil, fmt.Errorf("anki error: %s", genericResp.Error)
-	}
-
-	// Decode `Result` dynamically based on its type
-	var result interface{}
-	if err := decodeResult(genericResp.Result, &result); err != nil {
-		return nil, fmt.Errorf("failed to decode result: %w", err)
-	}
-
-	return result, nil
-}
-
-// Helper function to decode `Result` into its appropriate type
-func decodeResult(rawMessage json.RawMessage, result *interface{}) error {
-	// Attempt to decode as []string
-	var resultArray []string
-	if err := json.Unmarshal(rawMessage, &resultArray); err == nil {
-		*result = resultArray
-		return nil
-	}
-
-	// Attempt to decode as string
-	var resultString string
-	if err := json.Unmarshal(rawMessage, &resultString); err == nil {
-		*result = resultString
-		return nil
-	}
-
-	// Attempt to decode as json.Number
-	var resultNumber json.Number
-	if err := json.Unmarshal(rawMessage, &resultNumber); err == nil {
-		*result = resultNumber
-		return nil
-	}
-	// Attempt to decode as []int64
-	var resultArrayInt []int64
-	if err := json.Unmarshal(rawMessage, &resultArrayInt); err == nil {
-		*result = resultArrayInt
-		return nil
-	}
-	// If no known type matches, return an error
-	return fmt.Errorf("unknown result format: %s", string(rawMessage))
+	return rawResp, nil
 }
 
 // GetDeck checks if a given deck exists in Anki
-func GetDeck(deckName string, logger *zap.SugaredLogger) (bool, error) {
-	reqBody := map[string]interface{}{
-		"action":  "deckNames",
-		"version": 6,
-	}
-
-	resp, err := processRequest(reqBody)
+func (c *AnkiClient) GetDeck(ctx context.Context, deckName string) (bool, error) {
+	deckNames, err := c.DeckNames(ctx)
 	if err != nil {
-		logger.Errorf("Failed to process request: %v", err)
+		c.logger.Errorf("Failed to process request: %v", err)
 		return false, err
 	}
 
-	// Check if the result is a []string
-	if deckNames, ok := resp.([]string); ok {
-		for _, name := range deckNames {
-			if name == deckName {
-				return true, nil
-			}
+	for _, name := range deckNames {
+		if name == deckName {
+			return true, nil
 		}
-		return false, nil
 	}
-
-	return false, fmt.Errorf("unexpected result format for deckNames")
+	return false, nil
 }
 
 // CreateDeck creates a new deck in Anki
-func CreateDeck(deckName string, logger *zap.SugaredLogger) (bool, error) {
-	reqBody := NewAnkiRequestBody("createDeck", map[string]string{
-		"deck": deckName,
-	})
-
-	resp, err := processRequest(reqBody)
+func (c *AnkiClient) CreateDeck(ctx context.Context, deckName string) (bool, error) {
+	deckID, err := call[int64](ctx, c, "createDeck", DeckParams{Deck: deckName})
 	if err != nil {
-		logger.Errorf("Failed to process request: %v", err)
+		c.logger.Errorf("Failed to process request: %v", err)
 		return false, err
 	}
 
-	if resp == nil {
-		return false, errors.New("reponse for createdeck is nil")
+	if deckID == 0 {
+		return false, errors.New("reponse for createdeck is empty")
 	}
 	return true, nil
 }
 
-func deleteDeck(deckName string, logger *zap.SugaredLogger) (bool, error) {
-	reqBody := NewAnkiRequestBody("deleteDecks", map[string]interface{}{
-		"decks":    []string{deckName},
-		"cardsToo": true,
+func (c *AnkiClient) deleteDeck(ctx context.Context, deckName string) (bool, error) {
+	_, err := call[json.RawMessage](ctx, c, "deleteDecks", DeleteDecksParams{
+		Decks:    []string{deckName},
+		CardsToo: true,
 	})
-	_, err := processRequest(reqBody)
 	if err != nil {
-		logger.Errorf("Failed to process request: %v", err)
+		c.logger.Errorf("Failed to process request: %v", err)
 		return false, err
 	}
 	return true, nil
@@ -1,15 +1,23 @@
 package create
 
 import (
+	"context"
 	"testing"
 
+	"github.com/jaxxk/anki-cards-generator/internal/create/ankitest"
 	"github.com/jaxxk/anki-cards-generator/internal/transform"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 )
 
+// newTestClient returns a client for a fresh in-memory AnkiConnect.
+func newTestClient(t *testing.T) *AnkiClient {
+	_, url := ankitest.Start(t)
+	return NewAnkiClient(AnkiClientConfig{BaseURL: url, Logger: zap.NewExample().Sugar()})
+}
+
 func TestEnsureAnkiConnect(t *testing.T) {
-	ok, err := EnsureAnkiConnect()
+	ok, err := newTestClient(t).EnsureAnkiConnect(context.Background())
 	assert.NoError(t, err, "expected no error, but got one")
 	if !ok {
 		t.Fatal("expected true but got false")
@@ -17,7 +25,7 @@ func TestEnsureAnkiConnect(t *testing.T) {
 }
 
 func TestCreateDeck(t *testing.T) {
-	ok, err := CreateDeck("test", zap.NewExample().Sugar())
+	ok, err := newTestClient(t).CreateDeck(context.Background(), "test")
 	assert.NoError(t, err, "expected no error, but got one")
 	if !ok {
 		t.Fatal("true but got false")
@@ -45,9 +53,13 @@ func TestGetDeck(t *testing.T) {
 		},
 	}
 
+	client := newTestClient(t)
+	_, err := client.CreateDeck(context.Background(), "test")
+	assert.NoError(t, err)
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			output, err := GetDeck(tt.input, zap.NewExample().Sugar())
+			output, err := client.GetDeck(context.Background(), tt.input)
 
 			// Check for errors
 			if (err != nil) != tt.expectedError {
@@ -59,19 +71,20 @@ func TestGetDeck(t *testing.T) {
 				t.Errorf("GetDeck(%q) = %v, expectedOutput = %v", tt.input, output, tt.expectedOutput)
 			}
 
-			// Clean up
-			deleteDeck(tt.input, zap.NewExample().Sugar())
 		})
 	}
 }
 
-func TestSendToAnkiLive(t *testing.T) {
+func TestSendToAnki(t *testing.T) {
 	logger := zap.NewExample().Sugar()
 	defer logger.Sync()
+	_, url := ankitest.Start(t)
+	client := NewAnkiClient(AnkiClientConfig{BaseURL: url, Logger: logger})
+	ctx := context.Background()
 
 	// Define a test deck
 	testDeck := transform.Deck{
-		Title: "TestDeck_Live",
+		Title: "TestDeck",
 		Cards: []transform.Flashcards{
 			{Front: "Front 1", Back: "Back 1"},
 			{Front: "Front 2", Back: "Back 2"},
@@ -80,19 +93,20 @@ func TestSendToAnkiLive(t *testing.T) {
 
 	// Cleanup function to remove the test deck after the test
 	defer func() {
-		if _, err := deleteDeck(testDeck.Title, logger); err != nil {
+		if _, err := client.deleteDeck(ctx, testDeck.Title); err != nil {
 			logger.Errorf("Failed to clean up test deck: %v", err)
 		}
 	}()
 
 	// Run the function
-	err := sendToAnki(testDeck, logger)
+	report, err := client.SendToAnki(ctx, testDeck)
 	if err != nil {
 		t.Fatalf("SendToAnki failed: %v", err)
 	}
+	assert.Len(t, report.Filter(CardAdded), len(testDeck.Cards))
 
 	// Verify that the deck exists
-	exists, err := GetDeck(testDeck.Title, logger)
+	exists, err := client.GetDeck(ctx, testDeck.Title)
 	if err != nil {
 		t.Fatalf("Failed to check if deck exists: %v", err)
 	}
@@ -101,3 +115,49 @@ func TestSendToAnkiLive(t *testing.T) {
 	}
 
 }
+
+func TestSendToAnkiTags(t *testing.T) {
+	fake, url := ankitest.Start(t)
+	client := NewAnkiClient(AnkiClientConfig{BaseURL: url, Logger: zap.NewExample().Sugar()})
+	deck := transform.Deck{Title: "Tagged", Cards: []transform.Flashcards{
+		{Front: "Front 1", Back: "Back 1", Tags: []string{"go", "concurrency"}},
+		{Front: "Front 2", Back: "Back 2"},
+		{Front: "Front 3", Back: "Back 3", Difficulty: 4, Level: transform.LevelApply, Tags: []string{"go"}},
+	}}
+
+	_, err := client.SendToAnki(context.Background(), deck)
+	assert.NoError(t, err)
+	tags := map[string][]string{}
+	for _, note := range fake.Notes("Tagged") {
+		tags[note.Fields["Front"]] = note.Tags
+	}
+	assert.Equal(t, []string{"go", "concurrency"}, tags["Front 1"])
+	assert.Empty(t, tags["Front 2"])
+	assert.Equal(t, []string{"go", "difficulty::4", "bloom::apply"}, tags["Front 3"])
+}
+
+func TestDeckCards(t *testing.T) {
+	_, url := ankitest.Start(t)
+	client := NewAnkiClient(AnkiClientConfig{BaseURL: url, Logger: zap.NewExample().Sugar()})
+	ctx := context.Background()
+
+	cards, err := client.DeckCards(ctx, "Go Notes")
+	assert.NoError(t, err)
+	assert.Empty(t, cards, "a missing deck has no cards")
+
+	_, err = client.SendToAnki(ctx, transform.Deck{Title: "Go Notes", Cards: []transform.Flashcards{
+		{Front: "What is a <b>goroutine</b>?", Back: "A function &amp; its stack.", Tags: []string{"go"}},
+	}})
+	assert.NoError(t, err)
+	_, err = client.SendToAnki(ctx, transform.Deck{Title: "Other", Cards: []transform.Flashcards{{Front: "Q", Back: "A"}}})
+	assert.NoError(t, err)
+
+	cards, err = client.DeckCards(ctx, "Go Notes")
+	assert.NoError(t, err)
+	assert.Equal(t, []transform.Flashcards{{Front: "What is a goroutine ?", Back: "A function & its stack.", Tags: []string{"go"}}}, cards)
+}
+
+func TestDeckQuery(t *testing.T) {
+	assert.Equal(t, `"deck:Go Notes"`, deckQuery("Go Notes"))
+	assert.Equal(t, `"deck:a\_b \*\"x\""`, deckQuery(`a_b *"x"`))
+}
@@ -0,0 +1,585 @@
+// Package ankitest provides an in-memory fake of the AnkiConnect API for tests and offline use.
+package ankitest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version is the AnkiConnect API version reported by the fake.
+const Version = 6
+
+// Note is a note stored by the fake server.
+type Note struct {
+	ID        int64
+	DeckName  string
+	ModelName string
+	Fields    map[string]string
+	Tags      []string
+	Cards     []int64
+	Modified  time.Time
+}
+
+// models lists the note types the fake knows about, with their fields in order.
+var models = map[string][]string{
+	"Basic":                     {"Front", "Back"},
+	"Basic (and reversed card)": {"Front", "Back"},
+	"Cloze":                     {"Text", "Back Extra"},
+}
+
+// Server is an in-memory AnkiConnect. It implements http.Handler.
+type Server struct {
+	mu sync.Mutex
+	// apiKey, when set, must be sent as "key" with every request
+	apiKey string
+	decks  map[string]int64
+	notes  map[int64]*Note
+	media  map[string][]byte
+	nextID int64
+}
+
+// New returns an empty collection containing only the "Default" deck.
+func New() *Server {
+	return &Server{
+		decks:  map[string]int64{"Default": 1},
+		notes:  map[int64]*Note{},
+		media:  map[string][]byte{},
+		nextID: 1700000000000,
+	}
+}
+
+// SetAPIKey makes the fake require key as "key" with every request. An empty key turns the
+// check off.
+func (s *Server) SetAPIKey(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apiKey = key
+}
+
+// TB is the part of testing.TB that Start needs, so the package does not import testing.
+type TB interface {
+	Helper()
+	Cleanup(func())
+	Fatalf(format string, args ...interface{})
+}
+
+// Start serves a new fake on a local port until the test ends and returns it with its URL.
+func Start(tb TB) (*Server, string) {
+	tb.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("failed to listen for the fake AnkiConnect: %v", err)
+	}
+	server := New()
+	httpServer := &http.Server{Handler: server}
+	go httpServer.Serve(listener)
+	tb.Cleanup(func() { httpServer.Close() })
+	return server, "http://" + listener.Addr().String()
+}
+
+// request is the envelope of every AnkiConnect request.
+type request struct {
+	Action  string          `json:"action"`
+	Version int             `json:"version"`
+	Params  json.RawMessage `json:"params"`
+	Key     string          `json:"key"`
+}
+
+type response struct {
+	Result interface{} `json:"result"`
+	Error  *string     `json:"error"`
+}
+
+// ankiError is returned by handlers to produce an AnkiConnect style error reply.
+type ankiError string
+
+func (e ankiError) Error() string { return string(e) }
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// AnkiConnect answers plain GETs with its name, which clients use as a health check
+	if r.Method == http.MethodGet {
+		fmt.Fprintf(w, "AnkiConnect v.%d", Version)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		writeResponse(w, nil, fmt.Errorf("failed to parse request: %v", err))
+		return
+	}
+
+	result, err := s.handle(req)
+	writeResponse(w, result, err)
+}
+
+func writeResponse(w http.ResponseWriter, result interface{}, err error) {
+	resp := response{Result: result}
+	if err != nil {
+		message := err.Error()
+		resp = response{Error: &message}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *Server) handle(req request) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.apiKey != "" && req.Key != s.apiKey {
+		return nil, ankiError("valid api key must be provided")
+	}
+
+	switch req.Action {
+	case "version":
+		return Version, nil
+	case "deckNames":
+		return s.deckNames(), nil
+	case "createDeck":
+		var params struct {
+			Deck string `json:"deck"`
+		}
+		if err := decodeParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.createDeck(params.Deck), nil
+	case "deleteDecks":
+		var params struct {
+			Decks    []string `json:"decks"`
+			CardsToo bool     `json:"cardsToo"`
+		}
+		if err := decodeParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.deleteDecks(params.Decks, params.CardsToo)
+	case "addNote":
+		var params struct {
+			Note noteParams `json:"note"`
+		}
+		if err := decodeParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.addNote(params.Note)
+	case "addNotes":
+		var params struct {
+			Notes []noteParams `json:"notes"`
+		}
+		if err := decodeParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		ids := make([]*int64, len(params.Notes))
+		for i, note := range params.Notes {
+			if id, err := s.addNote(note); err == nil {
+				ids[i] = &id
+			}
+		}
+		return ids, nil
+	case "canAddNotes", "canAddNotesWithErrorDetail":
+		var params struct {
+			Notes []noteParams `json:"notes"`
+		}
+		if err := decodeParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.canAddNotes(params.Notes, req.Action == "canAddNotesWithErrorDetail"), nil
+	case "findNotes":
+		var params struct {
+			Query string `json:"query"`
+		}
+		if err := decodeParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.findNotes(params.Query), nil
+	case "notesInfo":
+		var params struct {
+			Notes []int64 `json:"notes"`
+		}
+		if err := decodeParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.notesInfo(params.Notes), nil
+	case "updateNoteFields":
+		var params struct {
+			Note struct {
+				ID     int64             `json:"id"`
+				Fields map[string]string `json:"fields"`
+			} `json:"note"`
+		}
+		if err := decodeParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return nil, s.updateNoteFields(params.Note.ID, params.Note.Fields)
+	case "storeMediaFile":
+		var params struct {
+			Filename string `json:"filename"`
+			Data     string `json:"data"`
+		}
+		if err := decodeParams(req.Params, &params); err != nil {
+			return nil, err
+		}
+		return s.storeMediaFile(params.Filename, params.Data)
+	default:
+		return nil, ankiError("unsupported action")
+	}
+}
+
+func decodeParams(raw json.RawMessage, v interface{}) error {
+	if len(raw) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("invalid params: %v", err)
+	}
+	return nil
+}
+
+func (s *Server) newID() int64 {
+	s.nextID++
+	return s.nextID
+}
+
+func (s *Server) deckNames() []string {
+	names := make([]string, 0, len(s.decks))
+	for name := range s.decks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *Server) createDeck(name string) int64 {
+	if id, ok := s.decks[name]; ok {
+		return id
+	}
+	// Anki creates missing parent decks as well
+	parts := strings.Split(name, "::")
+	for i := 1; i < len(parts); i++ {
+		parent := strings.Join(parts[:i], "::")
+		if _, ok := s.decks[parent]; !ok {
+			s.decks[parent] = s.newID()
+		}
+	}
+	id := s.newID()
+	s.decks[name] = id
+	return id
+}
+
+func (s *Server) deleteDecks(names []string, cardsToo bool) error {
+	if !cardsToo {
+		return ankiError("since AnkiConnect v6.0.0.0, deleteDecks requires the cardsToo parameter be set to true")
+	}
+	for _, name := range names {
+		for deck := range s.decks {
+			if deck == name || strings.HasPrefix(deck, name+"::") {
+				delete(s.decks, deck)
+				for id, note := range s.notes {
+					if note.DeckName == deck {
+						delete(s.notes, id)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// noteParams is a note as sent to addNote and addNotes.
+type noteParams struct {
+	DeckName  string            `json:"deckName"`
+	ModelName string            `json:"modelName"`
+	Fields    map[string]string `json:"fields"`
+	Tags      []string          `json:"tags"`
+	Options   struct {
+		AllowDuplicate bool   `json:"allowDuplicate"`
+		DuplicateScope string `json:"duplicateScope"`
+	} `json:"options"`
+}
+
+// validate applies Anki's rules for adding a note.
+func (s *Server) validate(note noteParams) error {
+	if _, ok := s.decks[note.DeckName]; !ok {
+		return ankiError("deck was not found: " + note.DeckName)
+	}
+	fields, ok := models[note.ModelName]
+	if !ok {
+		return ankiError("model was not found: " + note.ModelName)
+	}
+	for name := range note.Fields {
+		if !contains(fields, name) {
+			return ankiError(fmt.Sprintf("field was not found in model: %s", name))
+		}
+	}
+	first := strings.TrimSpace(note.Fields[fields[0]])
+	if first == "" {
+		return ankiError("cannot create note because it is empty")
+	}
+	if note.Options.AllowDuplicate {
+		return nil
+	}
+	for _, existing := range s.notes {
+		if existing.ModelName != note.ModelName || strings.TrimSpace(existing.Fields[fields[0]]) != first {
+			continue
+		}
+		if note.Options.DuplicateScope == "deck" && existing.DeckName != note.DeckName {
+			continue
+		}
+		return ankiError("cannot create note because it is a duplicate")
+	}
+	return nil
+}
+
+func (s *Server) addNote(params noteParams) (int64, error) {
+	if err := s.validate(params); err != nil {
+		return 0, err
+	}
+	note := &Note{
+		ID:        s.newID(),
+		DeckName:  params.DeckName,
+		ModelName: params.ModelName,
+		Fields:    map[string]string{},
+		Tags:      append([]string{}, params.Tags...),
+		Modified:  time.Now(),
+	}
+	for _, field := range models[params.ModelName] {
+		note.Fields[field] = params.Fields[field]
+	}
+	note.Cards = []int64{s.newID()}
+	s.notes[note.ID] = note
+	return note.ID, nil
+}
+
+type canAddResult struct {
+	CanAdd bool   `json:"canAdd"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (s *Server) canAddNotes(notes []noteParams, detail bool) interface{} {
+	details := make([]canAddResult, len(notes))
+	plain := make([]bool, len(notes))
+	for i, note := range notes {
+		if err := s.validate(note); err != nil {
+			details[i] = canAddResult{Error: err.Error()}
+			continue
+		}
+		details[i] = canAddResult{CanAdd: true}
+		plain[i] = true
+	}
+	if detail {
+		return details
+	}
+	return plain
+}
+
+// findNotes supports a subset of Anki's search syntax: deck:, tag:, nid:, note: and
+// plain terms matched against every field. Terms are combined with AND.
+func (s *Server) findNotes(query string) []int64 {
+	terms := splitQuery(query)
+	ids := []int64{}
+	for id, note := range s.notes {
+		if matchesAll(note, terms) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func splitQuery(query string) []string {
+	var terms []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ' ' && !quoted:
+			if current.Len() > 0 {
+				terms = append(terms, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		terms = append(terms, current.String())
+	}
+	return terms
+}
+
+func matchesAll(note *Note, terms []string) bool {
+	for _, term := range terms {
+		if !matches(note, term) {
+			return false
+		}
+	}
+	return true
+}
+
+func matches(note *Note, term string) bool {
+	lower := strings.ToLower(term)
+	switch {
+	case term == "*":
+		return true
+	case strings.HasPrefix(lower, "deck:"):
+		deck := strings.ToLower(term[len("deck:"):])
+		name := strings.ToLower(note.DeckName)
+		if deck == "*" {
+			return true
+		}
+		return name == deck || strings.HasPrefix(name, deck+"::")
+	case strings.HasPrefix(lower, "tag:"):
+		tag := strings.ToLower(term[len("tag:"):])
+		for _, t := range note.Tags {
+			if strings.ToLower(t) == tag {
+				return true
+			}
+		}
+		return false
+	case strings.HasPrefix(lower, "nid:"):
+		for _, id := range strings.Split(term[len("nid:"):], ",") {
+			if parsed, err := strconv.ParseInt(id, 10, 64); err == nil && parsed == note.ID {
+				return true
+			}
+		}
+		return false
+	case strings.HasPrefix(lower, "note:"):
+		return strings.EqualFold(note.ModelName, term[len("note:"):])
+	default:
+		for _, value := range note.Fields {
+			if strings.Contains(strings.ToLower(value), lower) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+type noteField struct {
+	Value string `json:"value"`
+	Order int    `json:"order"`
+}
+
+type noteInfo struct {
+	NoteID    int64                `json:"noteId"`
+	ModelName string               `json:"modelName"`
+	Tags      []string             `json:"tags"`
+	Fields    map[string]noteField `json:"fields"`
+	Cards     []int64              `json:"cards"`
+	Mod       int64                `json:"mod"`
+}
+
+// notesInfo returns an empty object for unknown IDs, like AnkiConnect.
+func (s *Server) notesInfo(ids []int64) []interface{} {
+	infos := make([]interface{}, len(ids))
+	for i, id := range ids {
+		note, ok := s.notes[id]
+		if !ok {
+			infos[i] = struct{}{}
+			continue
+		}
+		fields := map[string]noteField{}
+		for order, name := range models[note.ModelName] {
+			fields[name] = noteField{Value: note.Fields[name], Order: order}
+		}
+		infos[i] = noteInfo{
+			NoteID:    note.ID,
+			ModelName: note.ModelName,
+			Tags:      append([]string{}, note.Tags...),
+			Fields:    fields,
+			Cards:     append([]int64{}, note.Cards...),
+			Mod:       note.Modified.Unix(),
+		}
+	}
+	return infos
+}
+
+func (s *Server) updateNoteFields(id int64, fields map[string]string) error {
+	note, ok := s.notes[id]
+	if !ok {
+		return ankiError(fmt.Sprintf("note was not found: %d", id))
+	}
+	for name := range fields {
+		if !contains(models[note.ModelName], name) {
+			return ankiError(fmt.Sprintf("field was not found in model: %s", name))
+		}
+	}
+	for name, value := range fields {
+		note.Fields[name] = value
+	}
+	note.Modified = time.Now()
+	return nil
+}
+
+func (s *Server) storeMediaFile(filename, data string) (string, error) {
+	if filename == "" {
+		return "", ankiError("filename is required")
+	}
+	content, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", ankiError("invalid base64 data")
+	}
+	s.media[filename] = content
+	return filename, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Decks returns the names of every deck in the fake collection.
+func (s *Server) Decks() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.deckNames()
+}
+
+// Notes returns copies of the notes in deck, ordered by ID.
+func (s *Server) Notes(deck string) []Note {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	notes := []Note{}
+	for _, note := range s.notes {
+		if note.DeckName == deck {
+			copied := *note
+			copied.Fields = map[string]string{}
+			for k, v := range note.Fields {
+				copied.Fields[k] = v
+			}
+			notes = append(notes, copied)
+		}
+	}
+	sort.Slice(notes, func(i, j int) bool { return notes[i].ID < notes[j].ID })
+	return notes
+}
+
+// Media returns the content of a stored media file.
+func (s *Server) Media(filename string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	content, ok := s.media[filename]
+	return content, ok
+}
@@ -0,0 +1,94 @@
+package ankitest
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// post sends an action to the fake and returns its decoded result and error.
+func post(t *testing.T, url, action string, params interface{}) (json.RawMessage, *string) {
+	t.Helper()
+	body, err := json.Marshal(map[string]interface{}{"action": action, "version": Version, "params": params})
+	assert.NoError(t, err)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+
+	var decoded struct {
+		Result json.RawMessage `json:"result"`
+		Error  *string         `json:"error"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&decoded))
+	return decoded.Result, decoded.Error
+}
+
+func TestAddAndFindNotes(t *testing.T) {
+	server, url := Start(t)
+
+	_, ankiErr := post(t, url, "createDeck", map[string]string{"deck": "Go::Basics"})
+	assert.Nil(t, ankiErr)
+	assert.Equal(t, []string{"Default", "Go", "Go::Basics"}, server.Decks())
+
+	note := map[string]interface{}{
+		"deckName": "Go::Basics", "modelName": "Basic",
+		"fields": map[string]string{"Front": "What is a goroutine?", "Back": "A lightweight thread"},
+		"tags":   []string{"poggers"},
+	}
+	empty := map[string]interface{}{"deckName": "Go::Basics", "modelName": "Basic", "fields": map[string]string{"Front": ""}}
+	result, ankiErr := post(t, url, "addNotes", map[string]interface{}{"notes": []interface{}{note, note, empty}})
+	assert.Nil(t, ankiErr)
+	var ids []*int64
+	assert.NoError(t, json.Unmarshal(result, &ids))
+	assert.NotNil(t, ids[0])
+	assert.Nil(t, ids[1], "duplicate should not be added")
+	assert.Nil(t, ids[2], "empty note should not be added")
+
+	result, _ = post(t, url, "canAddNotesWithErrorDetail", map[string]interface{}{"notes": []interface{}{note}})
+	assert.JSONEq(t, `[{"canAdd": false, "error": "cannot create note because it is a duplicate"}]`, string(result))
+
+	result, _ = post(t, url, "findNotes", map[string]string{"query": `deck:Go tag:poggers goroutine`})
+	var found []int64
+	assert.NoError(t, json.Unmarshal(result, &found))
+	assert.Equal(t, []int64{*ids[0]}, found)
+
+	_, ankiErr = post(t, url, "updateNoteFields", map[string]interface{}{"note": map[string]interface{}{"id": found[0], "fields": map[string]string{"Back": "Managed by the runtime"}}})
+	assert.Nil(t, ankiErr)
+	result, _ = post(t, url, "notesInfo", map[string]interface{}{"notes": []int64{found[0], 1}})
+	var infos []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(result, &infos))
+	assert.Equal(t, "Managed by the runtime", infos[0]["fields"].(map[string]interface{})["Back"].(map[string]interface{})["value"])
+	assert.Empty(t, infos[1], "unknown notes are returned as empty objects")
+
+	_, ankiErr = post(t, url, "deleteDecks", map[string]interface{}{"decks": []string{"Go"}, "cardsToo": true})
+	assert.Nil(t, ankiErr)
+	assert.Empty(t, server.Notes("Go::Basics"))
+}
+
+func TestAnkiErrors(t *testing.T) {
+	server, url := Start(t)
+
+	_, ankiErr := post(t, url, "addNote", map[string]interface{}{"note": map[string]interface{}{
+		"deckName": "Missing", "modelName": "Basic", "fields": map[string]string{"Front": "Q"}}})
+	assert.Equal(t, "deck was not found: Missing", *ankiErr)
+
+	_, ankiErr = post(t, url, "deleteDecks", map[string]interface{}{"decks": []string{"Default"}})
+	assert.NotNil(t, ankiErr)
+
+	_, ankiErr = post(t, url, "guiBrowse", nil)
+	assert.Equal(t, "unsupported action", *ankiErr)
+
+	result, ankiErr := post(t, url, "storeMediaFile", map[string]string{"filename": "a.txt", "data": "aGVsbG8="})
+	assert.Nil(t, ankiErr)
+	assert.JSONEq(t, `"a.txt"`, string(result))
+	content, ok := server.Media("a.txt")
+	assert.True(t, ok)
+	assert.Equal(t, "hello", string(content))
+
+	server.SetAPIKey("secret")
+	_, ankiErr = post(t, url, "version", nil)
+	assert.Equal(t, "valid api key must be provided", *ankiErr)
+}
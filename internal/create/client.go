@@ -0,0 +1,102 @@
+package create
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jaxxk/anki-cards-generator/internal/encryption"
+	"go.uber.org/zap"
+)
+
+// ANKI_URL_ENV and ANKI_TIMEOUT_ENV name the environment variables that override the
+// AnkiConnect endpoint and request timeout, e.g. ANKI_URL=http://host.docker.internal:8765
+var ANKI_URL_ENV = "ANKI_URL"
+var ANKI_TIMEOUT_ENV = "ANKI_TIMEOUT"
+
+// DefaultTimeout bounds every AnkiConnect request unless configured otherwise.
+var DefaultTimeout = 30 * time.Second
+
+// AnkiClientConfig configures an AnkiClient. Zero values fall back to the defaults.
+type AnkiClientConfig struct {
+	// BaseURL of AnkiConnect, defaults to ANKI_ENDPOINT
+	BaseURL string
+	// HTTPClient used for requests, defaults to http.DefaultClient
+	HTTPClient *http.Client
+	// Timeout applied to each request, defaults to DefaultTimeout
+	Timeout time.Duration
+	// APIKey sent as AnkiConnect's "key" when its apiKey setting is enabled
+	APIKey string
+	// Logger defaults to a no-op logger
+	Logger *zap.SugaredLogger
+}
+
+// AnkiClient talks to a single AnkiConnect instance.
+type AnkiClient struct {
+	baseURL    string
+	httpClient *http.Client
+	timeout    time.Duration
+	apiKey     string
+	logger     *zap.SugaredLogger
+}
+
+// NewAnkiClient creates an AnkiClient from config.
+func NewAnkiClient(config AnkiClientConfig) *AnkiClient {
+	client := &AnkiClient{
+		baseURL:    strings.TrimRight(config.BaseURL, "/"),
+		httpClient: config.HTTPClient,
+		timeout:    config.Timeout,
+		apiKey:     config.APIKey,
+		logger:     config.Logger,
+	}
+	if client.baseURL == "" {
+		client.baseURL = ANKI_ENDPOINT
+	}
+	if client.httpClient == nil {
+		client.httpClient = http.DefaultClient
+	}
+	if client.timeout <= 0 {
+		client.timeout = DefaultTimeout
+	}
+	if client.logger == nil {
+		client.logger = zap.NewNop().Sugar()
+	}
+	return client
+}
+
+// NewAnkiClientFromEnv creates an AnkiClient configured from ANKI_URL_ENV, ANKI_TIMEOUT_ENV and the
+// ankiconnect credential, if one is stored. Non-empty baseURL and timeout arguments take precedence.
+func NewAnkiClientFromEnv(baseURL string, timeout time.Duration, logger *zap.SugaredLogger) (*AnkiClient, error) {
+	if baseURL == "" {
+		baseURL = os.Getenv(ANKI_URL_ENV)
+	}
+	if timeout == 0 {
+		if value := os.Getenv(ANKI_TIMEOUT_ENV); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s: %w", ANKI_TIMEOUT_ENV, err)
+			}
+			timeout = parsed
+		}
+	}
+
+	apiKey, err := encryption.GetCredential(encryption.AnkiConnectCredential, logger)
+	if err != nil && !errors.Is(err, encryption.ErrCredentialNotFound) {
+		return nil, fmt.Errorf("failed to load AnkiConnect key: %w", err)
+	}
+
+	return NewAnkiClient(AnkiClientConfig{
+		BaseURL: baseURL,
+		Timeout: timeout,
+		APIKey:  apiKey,
+		Logger:  logger,
+	}), nil
+}
+
+// BaseURL returns the AnkiConnect endpoint the client talks to.
+func (c *AnkiClient) BaseURL() string {
+	return c.baseURL
+}
@@ -0,0 +1,44 @@
+package create
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnkiClientSendsKey(t *testing.T) {
+	var received AnkiRequestBody
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.Write([]byte(`{"result": ["Default", "remote"], "error": null}`))
+	}))
+	defer server.Close()
+
+	client := NewAnkiClient(AnkiClientConfig{BaseURL: server.URL + "/", APIKey: "secret"})
+	assert.Equal(t, server.URL, client.BaseURL())
+
+	exists, err := client.GetDeck(context.Background(), "remote")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "deckNames", received.Action)
+	assert.Equal(t, "secret", received.Key)
+}
+
+func TestAnkiClientTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(200 * time.Millisecond):
+		}
+	}))
+	defer server.Close()
+
+	client := NewAnkiClient(AnkiClientConfig{BaseURL: server.URL, Timeout: 20 * time.Millisecond})
+	_, err := client.GetDeck(context.Background(), "any")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
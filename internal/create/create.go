@@ -1,75 +1,125 @@
 package create
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/jaxxk/anki-cards-generator/internal/transform"
-	"go.uber.org/zap"
 )
 
-// deck: deck of flashcards
-func SendToAnki(deck transform.Deck, logger *zap.SugaredLogger) error {
+// SendToAnki adds every card of deck to Anki, creating the deck if needed.
+// Individual cards that are duplicates or rejected by Anki do not stop the push; they are
+// reported in the returned PushReport. An error is only returned if the deck could not be ensured.
+func (c *AnkiClient) SendToAnki(ctx context.Context, deck transform.Deck) (PushReport, error) {
 
 	// Ensure the deck exists
-	if err := existsDeck(deck.Title, logger); err != nil {
-		return fmt.Errorf("failed to ensure deck exists: %w", err)
+	if err := c.existsDeck(ctx, deck.Title); err != nil {
+		return PushReport{}, fmt.Errorf("failed to ensure deck exists: %w", err)
 	}
 
-	err := sendToAnki(deck, logger)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return c.sendToAnki(ctx, deck), nil
 }
 
-func sendToAnki(deck transform.Deck, logger *zap.SugaredLogger) error {
+func (c *AnkiClient) sendToAnki(ctx context.Context, deck transform.Deck) PushReport {
+	report := PushReport{Deck: deck.Title}
 	// Prepare to batch cards into `FlashcardBatchSize`
-	batch := []Note{}
-	for i, card := range deck.Cards {
-		// Create a new Note from the card
-		note := NewNote(card.Front, card.Back, deck.Title)
-		batch = append(batch, note)
+	for start := 0; start < len(deck.Cards); start += FlashcardBatchSize {
+		end := min(start+FlashcardBatchSize, len(deck.Cards))
+		results := c.sendBatchToAnki(ctx, deck.Title, deck.Cards[start:end])
+		report.Results = append(report.Results, results...)
+	}
+	return report
+}
+
+// sendBatchToAnki adds a batch of cards and returns one result per card, in order.
+func (c *AnkiClient) sendBatchToAnki(ctx context.Context, deckName string, cards []transform.Flashcards) []CardResult {
+	results := make([]CardResult, len(cards))
+	notes := make([]Note, len(cards))
+	for i, card := range cards {
+		results[i] = CardResult{Card: card}
+		notes[i] = NewNote(card.Front, card.Back, deckName)
+		notes[i].Tags = card.AnkiTags()
+	}
+
+	// Pre-flight check so duplicates and invalid notes don't sink the whole batch
+	pending := c.preflight(ctx, notes, results)
+	if len(pending) == 0 {
+		return results
+	}
 
-		// Send the batch if it reaches the `FlashcardBatchSize`
-		if len(batch) == FlashcardBatchSize || i == len(deck.Cards)-1 {
-			if err := sendBatchToAnki(batch, logger); err != nil {
-				return fmt.Errorf("failed to send batch to Anki: %w", err)
+	batch := make([]Note, len(pending))
+	for i, index := range pending {
+		batch[i] = notes[index]
+	}
+	ids, err := c.AddNotes(ctx, batch)
+	if err != nil || len(ids) != len(batch) {
+		// Some AnkiConnect versions reject the whole batch when a single note fails,
+		// so fall back to adding the notes one at a time
+		c.logger.Warnf("Batch of %d notes was rejected, adding them individually: %v", len(batch), err)
+		for _, index := range pending {
+			id, err := c.AddNote(ctx, notes[index])
+			switch {
+			case err != nil && isDuplicateError(err.Error()):
+				// the pre-flight check was skipped or another client added it since
+				results[index].skip(err.Error())
+				continue
+			case err != nil:
+				results[index].fail(err.Error())
+				continue
 			}
-			batch = []Note{} // Reset the batch after sending
+			results[index].add(id)
 		}
+		return results
 	}
 
-	return nil
+	for i, index := range pending {
+		if ids[i] == nil {
+			results[index].fail("anki could not add the note")
+			continue
+		}
+		results[index].add(*ids[i])
+	}
+	c.logger.Infof("Successfully sent %d cards to Anki", len(pending))
+	return results
 }
 
-func sendBatchToAnki(batch []Note, logger *zap.SugaredLogger) error {
-	// Prepare the request body
-	params := Notes{ListOfNotes: batch}
-	reqBody := NewAnkiRequestBody("addNotes", params)
-
-	// Send the request
-	_, err := processRequest(reqBody)
-	if err != nil {
-		logger.Errorf("Failed to send batch to Anki: %v", err)
-		return err
+// preflight marks notes that cannot be added as skipped or failed and returns the indexes of
+// the notes still to be added.
+func (c *AnkiClient) preflight(ctx context.Context, notes []Note, results []CardResult) []int {
+	pending := make([]int, 0, len(notes))
+	checks, err := c.CanAddNotesWithErrorDetail(ctx, notes)
+	if err != nil || len(checks) != len(notes) {
+		c.logger.Warnf("Pre-flight check failed, adding notes without it: %v", err)
+		for i := range notes {
+			pending = append(pending, i)
+		}
+		return pending
 	}
 
-	logger.Infof("Successfully sent %d cards to Anki", len(batch))
-	return nil
+	for i, check := range checks {
+		switch {
+		case check.CanAdd:
+			pending = append(pending, i)
+		case isDuplicateError(check.Error):
+			results[i].skip(check.Error)
+		default:
+			results[i].fail(check.Error)
+		}
+	}
+	return pending
 }
 
 // checks if deck exists, creates one if it doesn't exist
-func existsDeck(title string, logger *zap.SugaredLogger) error {
+func (c *AnkiClient) existsDeck(ctx context.Context, title string) error {
 	// Check if the deck exists
-	deckExists, err := GetDeck(title, logger)
+	deckExists, err := c.GetDeck(ctx, title)
 	if err != nil {
 		return fmt.Errorf("failed to check if deck exists: %w", err)
 	}
 
 	// If the deck does not exist, create it
 	if !deckExists {
-		if _, err := CreateDeck(title, logger); err != nil {
+		if _, err := c.CreateDeck(ctx, title); err != nil {
 			return fmt.Errorf("failed to create deck '%s': %w", title, err)
 		}
 	}
@@ -0,0 +1,68 @@
+package create
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jaxxk/anki-cards-generator/internal/transform"
+	"github.com/stretchr/testify/assert"
+)
+
+var reportDeck = transform.Deck{
+	Title: "Report",
+	Cards: []transform.Flashcards{
+		{Front: "new", Back: "card"},
+		{Front: "dup", Back: "card"},
+		{Front: "bad", Back: "card"},
+	},
+}
+
+func TestSendToAnkiReportsPerNote(t *testing.T) {
+	client := newCannedServer(t, map[string]string{
+		"deckNames": `{"result": ["Report"], "error": null}`,
+		"canAddNotesWithErrorDetail": `{"result": [{"canAdd": true},
+			{"canAdd": false, "error": "cannot create note because it is a duplicate"},
+			{"canAdd": false, "error": "model was not found: Basic"}], "error": null}`,
+		"addNotes": `{"result": [42], "error": null}`,
+	})
+
+	report, err := client.SendToAnki(context.Background(), reportDeck)
+	assert.NoError(t, err)
+	assert.Len(t, report.Results, 3)
+
+	added := report.Filter(CardAdded)
+	assert.Len(t, added, 1)
+	assert.Equal(t, "new", added[0].Card.Front)
+	assert.Equal(t, int64(42), added[0].NoteID)
+	assert.Equal(t, "dup", report.Filter(CardSkipped)[0].Card.Front)
+	assert.Equal(t, "bad", report.Filter(CardFailed)[0].Card.Front)
+	assert.Equal(t, "Report: 1 added, 1 skipped (duplicate), 1 failed", report.Summary())
+}
+
+func TestSendToAnkiFallsBackToSingleNotes(t *testing.T) {
+	// no pre-flight support and a batch that is rejected as a whole
+	client := newCannedServer(t, map[string]string{
+		"deckNames": `{"result": ["Report"], "error": null}`,
+		"addNotes":  `{"result": null, "error": "['cannot create note because it is a duplicate']"}`,
+		"addNote":   `{"result": 7, "error": null}`,
+	})
+
+	report, err := client.SendToAnki(context.Background(), reportDeck)
+	assert.NoError(t, err)
+	assert.Len(t, report.Filter(CardAdded), 3)
+	assert.Equal(t, int64(7), report.Results[2].NoteID)
+}
+
+func TestSendToAnkiFallbackSkipsDuplicates(t *testing.T) {
+	// without the pre-flight check, duplicates are only found when adding each note
+	client := newCannedServer(t, map[string]string{
+		"deckNames": `{"result": ["Report"], "error": null}`,
+		"addNotes":  `{"result": null, "error": "['cannot create note because it is a duplicate']"}`,
+		"addNote":   `{"result": null, "error": "cannot create note because it is a duplicate"}`,
+	})
+
+	report, err := client.SendToAnki(context.Background(), reportDeck)
+	assert.NoError(t, err)
+	assert.Len(t, report.Filter(CardSkipped), 3)
+	assert.Empty(t, report.Filter(CardFailed))
+}
@@ -0,0 +1,57 @@
+package create
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+
+	"github.com/jaxxk/anki-cards-generator/internal/transform"
+)
+
+// htmlTag matches the markup Anki keeps in note fields.
+var htmlTag = regexp.MustCompile(`<[^>]*>`)
+
+// deckQuery returns the search query for the notes of deckName, escaping Anki's wildcards.
+func deckQuery(deckName string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `*`, `\*`, `_`, `\_`).Replace(deckName)
+	return `"deck:` + escaped + `"`
+}
+
+// DeckCards returns the front and back of every note in deckName, as plain text, so new cards
+// can be compared with what the deck already holds. Notes without Front and Back fields are
+// left out, and a deck that does not exist has no cards.
+func (c *AnkiClient) DeckCards(ctx context.Context, deckName string) ([]transform.Flashcards, error) {
+	ids, err := c.FindNotes(ctx, deckQuery(deckName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find notes of deck %s: %w", deckName, err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	infos, err := c.NotesInfo(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notes of deck %s: %w", deckName, err)
+	}
+
+	cards := make([]transform.Flashcards, 0, len(infos))
+	for _, info := range infos {
+		front, hasFront := info.Fields["Front"]
+		back, hasBack := info.Fields["Back"]
+		if !hasFront || !hasBack {
+			continue
+		}
+		cards = append(cards, transform.Flashcards{
+			Front: plainText(front.Value),
+			Back:  plainText(back.Value),
+			Tags:  info.Tags,
+		})
+	}
+	return cards, nil
+}
+
+// plainText strips the HTML of a note field, and collapses its whitespace.
+func plainText(field string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(htmlTag.ReplaceAllString(field, " "))), " ")
+}
@@ -0,0 +1,71 @@
+package create
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jaxxk/anki-cards-generator/internal/transform"
+)
+
+// CardStatus is the outcome of pushing a single card to Anki.
+type CardStatus string
+
+const (
+	CardAdded   CardStatus = "added"
+	CardSkipped CardStatus = "skipped"
+	CardFailed  CardStatus = "failed"
+)
+
+// CardResult maps a card to the note Anki created for it, or to the reason it was not added.
+type CardResult struct {
+	Card   transform.Flashcards
+	Status CardStatus
+	NoteID int64
+	Reason string
+}
+
+func (r *CardResult) add(noteID int64) {
+	r.Status = CardAdded
+	r.NoteID = noteID
+}
+
+func (r *CardResult) skip(reason string) {
+	r.Status = CardSkipped
+	r.Reason = reason
+}
+
+func (r *CardResult) fail(reason string) {
+	r.Status = CardFailed
+	if reason == "" {
+		reason = "unknown error"
+	}
+	r.Reason = reason
+}
+
+// PushReport is the per-card outcome of SendToAnki.
+type PushReport struct {
+	Deck    string
+	Results []CardResult
+}
+
+// Filter returns the results with the given status.
+func (r PushReport) Filter(status CardStatus) []CardResult {
+	filtered := []CardResult{}
+	for _, result := range r.Results {
+		if result.Status == status {
+			filtered = append(filtered, result)
+		}
+	}
+	return filtered
+}
+
+// Summary returns a one line count of added, skipped and failed cards.
+func (r PushReport) Summary() string {
+	return fmt.Sprintf("%s: %d added, %d skipped (duplicate), %d failed",
+		r.Deck, len(r.Filter(CardAdded)), len(r.Filter(CardSkipped)), len(r.Filter(CardFailed)))
+}
+
+// isDuplicateError reports whether an AnkiConnect error means the note already exists.
+func isDuplicateError(message string) bool {
+	return strings.Contains(strings.ToLower(message), "duplicate")
+}
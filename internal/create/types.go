@@ -6,7 +6,8 @@ var FlashcardBatchSize int = 30
 type AnkiRequestBody struct {
 	Action  string     `json:"action"`
 	Version int        `json:"version"`
-	Params  AnkiParams `json:"params"`
+	Params  AnkiParams `json:"params,omitempty"`
+	Key     string     `json:"key,omitempty"`
 }
 
 type AnkiParams interface{}
@@ -19,6 +20,7 @@ type Note struct {
 	DeckName  string            `json:"deckName"`
 	ModelName string            `json:"modelName"`
 	Fields    map[string]string `json:"fields"`
+	Tags      []string          `json:"tags,omitempty"`
 }
 
 func NewNote(front, back, deckName string) Note {
@@ -0,0 +1,210 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ENCRYPT_AT_REST names the environment variable that opts into encrypting the files
+// poggers keeps in the processing directory (saved decks, job manifests, caches).
+var ENCRYPT_AT_REST = "POGGERS_ENCRYPT_AT_REST"
+
+// AtRestEnabled reports whether processing directory files should be encrypted.
+func AtRestEnabled() bool {
+	enabled, err := strconv.ParseBool(strings.TrimSpace(os.Getenv(ENCRYPT_AT_REST)))
+	return err == nil && enabled
+}
+
+// WriteFile writes data to folder/filename. When at-rest encryption is enabled the data is
+// sealed with the encryption key and ENCRYPTED_EXT is appended to the file name. A copy in the
+// other format, left from before at-rest encryption was toggled, is removed so it can neither
+// be read instead nor keep the data in plain text. Returns the path of the written file.
+func WriteFile(data []byte, folder, filename string) (string, error) {
+	filePath := filepath.Join(folder, filename)
+	otherPath := filePath + ENCRYPTED_EXT
+	if AtRestEnabled() {
+		encryptionKey, err := GetEncKey()
+		if err != nil {
+			return "", err
+		}
+		data, err = encryptBytes(data, encryptionKey)
+		if err != nil {
+			return "", err
+		}
+		otherPath, filePath = filePath, otherPath
+	}
+
+	tmpPath := filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return "", fmt.Errorf("failed to write file: %s, error: %w", filePath, err)
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		os.Remove(tmpPath)
+		return "", fmt.Errorf("failed to write file: %s, error: %w", filePath, err)
+	}
+	if err := os.Remove(otherPath); err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to remove file: %s, error: %w", otherPath, err)
+	}
+	return filePath, nil
+}
+
+// storedPath returns the file ReadFile reads for path: path itself when it names an encrypted
+// file, else the copy in the format at-rest encryption is set to, or the other copy when that
+// one is missing.
+func storedPath(path string) string {
+	if strings.HasSuffix(path, ENCRYPTED_EXT) {
+		return path
+	}
+	preferred, other := path, path+ENCRYPTED_EXT
+	if AtRestEnabled() {
+		preferred, other = other, preferred
+	}
+	if _, err := os.Stat(preferred); os.IsNotExist(err) {
+		if _, err := os.Stat(other); err == nil {
+			return other
+		}
+	}
+	return preferred
+}
+
+// Exists reports whether ReadFile finds a file for path, in either format.
+func Exists(path string) bool {
+	_, err := os.Stat(storedPath(path))
+	return err == nil
+}
+
+// ReadFile reads a file written by WriteFile, decrypting it transparently. Of path and its
+// encrypted counterpart, the one in the format at-rest encryption is set to is read when both
+// exist.
+func ReadFile(path string) ([]byte, error) {
+	path = storedPath(path)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasSuffix(path, ENCRYPTED_EXT) {
+		return data, nil
+	}
+
+	encryptionKey, err := GetEncKey()
+	if err != nil {
+		return nil, err
+	}
+	plainText, err := decryptBytes(data, encryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %w", path, err)
+	}
+	return plainText, nil
+}
+
+// WriteJSONFile writes data as indented JSON using WriteFile.
+func WriteJSONFile(data interface{}, folder, filename string) (string, error) {
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize JSON: %w", err)
+	}
+	return WriteFile(append(content, '\n'), folder, filename)
+}
+
+// ReadJSONFile reads a file written by WriteJSONFile into v.
+func ReadJSONFile(path string, v interface{}) error {
+	content, err := ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// AppendLine appends one line to folder/filename, for append-only logs such as the usage
+// ledger. When at-rest encryption is enabled each line is sealed on its own, base64 encoded,
+// and appended to the file with ENCRYPTED_EXT instead. Returns the path appended to.
+func AppendLine(line []byte, folder, filename string) (string, error) {
+	filePath := filepath.Join(folder, filename)
+	if AtRestEnabled() {
+		encryptionKey, err := GetEncKey()
+		if err != nil {
+			return "", err
+		}
+		sealed, err := encryptBytes(line, encryptionKey)
+		if err != nil {
+			return "", err
+		}
+		line = []byte(base64.StdEncoding.EncodeToString(sealed))
+		filePath += ENCRYPTED_EXT
+	}
+
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %s, error: %w", filePath, err)
+	}
+	defer file.Close()
+	if _, err := file.Write(append(bytes.TrimRight(line, "\n"), '\n')); err != nil {
+		return "", fmt.Errorf("failed to append to file: %s, error: %w", filePath, err)
+	}
+	return filePath, nil
+}
+
+// ReadLines returns the lines appended to path by AppendLine: those of the plain file,
+// then those of its encrypted counterpart. Missing files have no lines.
+func ReadLines(path string) ([][]byte, error) {
+	path = strings.TrimSuffix(path, ENCRYPTED_EXT)
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+	sealedLines, err := readLines(path + ENCRYPTED_EXT)
+	if err != nil || len(sealedLines) == 0 {
+		return lines, err
+	}
+
+	encryptionKey, err := GetEncKey()
+	if err != nil {
+		return nil, err
+	}
+	for i, sealedLine := range sealedLines {
+		sealed, err := base64.StdEncoding.DecodeString(string(sealedLine))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode line %d of %s: %w", i+1, path+ENCRYPTED_EXT, err)
+		}
+		line, err := decryptBytes(sealed, encryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt line %d of %s: %w", i+1, path+ENCRYPTED_EXT, err)
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+func readLines(path string) ([][]byte, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines [][]byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			lines = append(lines, append([]byte{}, line...))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return lines, nil
+}
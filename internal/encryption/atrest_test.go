@@ -0,0 +1,99 @@
+package encryption
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteJSONFileAtRest(t *testing.T) {
+	setupStoreEnv(t)
+	dir := t.TempDir()
+	data := map[string]string{"front": "private note"}
+
+	t.Run("plain text by default", func(t *testing.T) {
+		path, err := WriteJSONFile(data, dir, "plain.json")
+		assert.NoError(t, err)
+		assert.Equal(t, filepath.Join(dir, "plain.json"), path)
+
+		var got map[string]string
+		assert.NoError(t, ReadJSONFile(path, &got))
+		assert.Equal(t, data, got)
+	})
+
+	t.Run("encrypted when enabled", func(t *testing.T) {
+		t.Setenv(ENCRYPT_AT_REST, "true")
+		path, err := WriteJSONFile(data, dir, "sealed.json")
+		assert.NoError(t, err)
+		assert.Equal(t, filepath.Join(dir, "sealed.json"+ENCRYPTED_EXT), path)
+
+		raw, err := os.ReadFile(path)
+		assert.NoError(t, err)
+		assert.NotContains(t, string(raw), "private note")
+
+		// readers find the encrypted file from its plain name too
+		var got map[string]string
+		assert.NoError(t, ReadJSONFile(filepath.Join(dir, "sealed.json"), &got))
+		assert.Equal(t, data, got)
+	})
+}
+
+func TestWriteFileReplacesOtherFormat(t *testing.T) {
+	setupStoreEnv(t)
+	dir := t.TempDir()
+
+	_, err := WriteFile([]byte("old private note"), dir, "manifest.json")
+	assert.NoError(t, err)
+
+	t.Setenv(ENCRYPT_AT_REST, "true")
+	got, err := ReadFile(filepath.Join(dir, "manifest.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, "old private note", string(got), "plain text is read until it is written again")
+
+	path, err := WriteFile([]byte("new private note"), dir, "manifest.json")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "manifest.json"+ENCRYPTED_EXT), path)
+	assert.NoFileExists(t, filepath.Join(dir, "manifest.json"), "no plain text copy is left")
+	got, err = ReadFile(filepath.Join(dir, "manifest.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, "new private note", string(got))
+	assert.True(t, Exists(filepath.Join(dir, "manifest.json")))
+
+	// a stale copy in the other format never wins over the current one
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "manifest.json"), []byte("stale"), 0600))
+	got, err = ReadFile(filepath.Join(dir, "manifest.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, "new private note", string(got))
+
+	t.Setenv(ENCRYPT_AT_REST, "false")
+	_, err = WriteFile([]byte("plain again"), dir, "manifest.json")
+	assert.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(dir, "manifest.json"+ENCRYPTED_EXT))
+	assert.False(t, Exists(filepath.Join(dir, "missing.json")))
+}
+
+func TestAppendLineAtRest(t *testing.T) {
+	setupStoreEnv(t)
+	dir := t.TempDir()
+
+	_, err := AppendLine([]byte(`{"n":1}`), dir, "log.jsonl")
+	assert.NoError(t, err)
+
+	t.Setenv(ENCRYPT_AT_REST, "true")
+	path, err := AppendLine([]byte(`{"n":2, "source":"private.md"}`), dir, "log.jsonl")
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "log.jsonl"+ENCRYPTED_EXT), path)
+	raw, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(raw), "private.md")
+
+	lines, err := ReadLines(filepath.Join(dir, "log.jsonl"))
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte(`{"n":1}`), []byte(`{"n":2, "source":"private.md"}`)}, lines)
+
+	lines, err = ReadLines(filepath.Join(dir, "missing.jsonl"))
+	assert.NoError(t, err)
+	assert.Empty(t, lines)
+}
@@ -10,7 +10,7 @@ import (
 	"io"
 	"os"
 	"os/exec"
-	"os/user"
+	"path/filepath"
 	"runtime"
 )
 
@@ -35,27 +35,11 @@ func EncryptFile(inputPath, outputPath string, key []byte) error {
 		return fmt.Errorf("failed to read input file: %w", err)
 	}
 
-	// Create a new AES cipher using the provided key
-	block, err := aes.NewCipher(key)
+	data, err := encryptBytes(plainText, key)
 	if err != nil {
-		return fmt.Errorf("failed to create AES cipher: %w", err)
-	}
-
-	// Generate a random nonce
-	nonce := make([]byte, 12) // GCM nonce size is 12 bytes
-	if _, err := rand.Read(nonce); err != nil {
-		return fmt.Errorf("failed to generate nonce: %w", err)
+		return err
 	}
 
-	// Create a GCM cipher
-	aesGCM, err := cipher.NewGCM(block)
-	if err != nil {
-		return fmt.Errorf("failed to create GCM cipher: %w", err)
-	}
-
-	// Encrypt the plaintext
-	cipherText := aesGCM.Seal(nil, nonce, plainText, nil)
-
 	// Open the output file
 	outputFile, err := os.Create(outputPath)
 	if err != nil {
@@ -64,10 +48,7 @@ func EncryptFile(inputPath, outputPath string, key []byte) error {
 	defer outputFile.Close()
 
 	// Write the nonce followed by the ciphertext to the output file
-	if _, err := outputFile.Write(nonce); err != nil {
-		return fmt.Errorf("failed to write nonce to output file: %w", err)
-	}
-	if _, err := outputFile.Write(cipherText); err != nil {
+	if _, err := outputFile.Write(data); err != nil {
 		return fmt.Errorf("failed to write ciphertext to output file: %w", err)
 	}
 
@@ -88,34 +69,70 @@ func DecryptFile(inputPath string, key []byte) (Key, error) {
 		return Key{}, fmt.Errorf("failed to read input file: %w", err)
 	}
 
-	// Separate nonce and ciphertext
-	nonce, cipherText := data[:12], data[12:]
+	plainText, err := decryptBytes(data, key)
+	if err != nil {
+		return Key{}, err
+	}
+
+	// Parse the decrypted plaintext into the Key struct
+	var keyStruct Key
+	if err := json.Unmarshal(plainText, &keyStruct); err != nil {
+		return Key{}, fmt.Errorf("failed to unmarshal decrypted data into struct: %w", err)
+	}
+
+	return keyStruct, nil
+}
+
+// encryptBytes seals plainText with AES-GCM and returns the nonce followed by the ciphertext.
+func encryptBytes(plainText, key []byte) ([]byte, error) {
+	// Create a new AES cipher using the provided key
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+	}
 
+	// Create a GCM cipher
+	aesGCM, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM cipher: %w", err)
+	}
+
+	// Generate a random nonce
+	nonce := make([]byte, aesGCM.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	// Encrypt the plaintext, prefixing the nonce
+	return aesGCM.Seal(nonce, nonce, plainText, nil), nil
+}
+
+// decryptBytes opens data produced by encryptBytes.
+func decryptBytes(data, key []byte) ([]byte, error) {
 	// Create AES cipher
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		return Key{}, fmt.Errorf("failed to create AES cipher: %w", err)
+		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
 	}
 
 	// Create GCM cipher
 	aesGCM, err := cipher.NewGCM(block)
 	if err != nil {
-		return Key{}, fmt.Errorf("failed to create GCM: %w", err)
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
 	}
 
+	// Separate nonce and ciphertext
+	if len(data) < aesGCM.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, cipherText := data[:aesGCM.NonceSize()], data[aesGCM.NonceSize():]
+
 	// Decrypt the ciphertext
 	plainText, err := aesGCM.Open(nil, nonce, cipherText, nil)
 	if err != nil {
-		return Key{}, fmt.Errorf("failed to decrypt: %w", err)
-	}
-
-	// Parse the decrypted plaintext into the Key struct
-	var keyStruct Key
-	if err := json.Unmarshal(plainText, &keyStruct); err != nil {
-		return Key{}, fmt.Errorf("failed to unmarshal decrypted data into struct: %w", err)
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
 	}
-
-	return keyStruct, nil
+	return plainText, nil
 }
 
 // GenerateRandomKey generates a random 32-byte key for AES-256.
@@ -147,16 +164,9 @@ func SaveEncryptionKeyToEnv(key string, data []byte) error {
 			return fmt.Errorf("failed to persist environment variable on Windows: %w", err)
 		}
 	case "linux", "darwin":
-		// Determine the shell configuration file
-		usr, err := user.Current()
+		shellConfigPath, err := shellConfigPath()
 		if err != nil {
-			return fmt.Errorf("failed to get current user: %w", err)
-		}
-
-		// Check for common shell configuration files
-		shellConfigPath := usr.HomeDir + "/.bashrc"
-		if shell := os.Getenv("SHELL"); shell != "" && shell == "/bin/zsh" {
-			shellConfigPath = usr.HomeDir + "/.zshrc"
+			return err
 		}
 
 		// Append the export statement to the shell configuration file
@@ -177,6 +187,20 @@ func SaveEncryptionKeyToEnv(key string, data []byte) error {
 	return nil
 }
 
+// shellConfigPath returns the shell configuration file environment variables are persisted to.
+func shellConfigPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user home directory: %w", err)
+	}
+
+	// Check for common shell configuration files
+	if shell := os.Getenv("SHELL"); shell != "" && shell == "/bin/zsh" {
+		return filepath.Join(home, ".zshrc"), nil
+	}
+	return filepath.Join(home, ".bashrc"), nil
+}
+
 // GetBytesFromEnv retrieves a []byte from an environment variable
 func GetBytesFromEnv(key string) ([]byte, error) {
 	// Retrieve the base64 string from the environment variable
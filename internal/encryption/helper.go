@@ -0,0 +1,111 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CREDENTIAL_HELPER names the environment variable holding the credential helper command.
+// When set, secrets are requested from the helper instead of the local credential store.
+var CREDENTIAL_HELPER = "POGGERS_CREDENTIAL_HELPER"
+
+// HelperTimeout bounds how long a credential helper may take to answer.
+var HelperTimeout = 30 * time.Second
+
+// helperRequest is written as a single JSON line to the helper's stdin.
+// The helper is invoked as `<command> get`, like git's credential helpers.
+type helperRequest struct {
+	Action string `json:"action"`
+	Name   string `json:"name"`
+}
+
+// helperResponse is read from the helper's stdout. NotFound tells a credential the helper
+// does not have from a helper that failed, e.g. on a locked keychain.
+type helperResponse struct {
+	Name     string `json:"name,omitempty"`
+	Secret   string `json:"secret"`
+	NotFound bool   `json:"not_found,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// helperCache keeps secrets returned by the helper in memory for the life of the process only.
+var helperCache = struct {
+	sync.Mutex
+	secrets map[string]string
+}{secrets: map[string]string{}}
+
+// CredentialHelper returns the configured credential helper command, if any.
+func CredentialHelper() string {
+	return strings.TrimSpace(os.Getenv(CREDENTIAL_HELPER))
+}
+
+// getFromHelper returns the secret called name from the helper command, caching it in memory.
+func getFromHelper(helper, name string) (string, error) {
+	helperCache.Lock()
+	defer helperCache.Unlock()
+	if secret, ok := helperCache.secrets[name]; ok {
+		return secret, nil
+	}
+
+	secret, err := runHelper(helper, name)
+	if err != nil {
+		return "", err
+	}
+	helperCache.secrets[name] = secret
+	return secret, nil
+}
+
+func runHelper(helper, name string) (string, error) {
+	request, err := json.Marshal(helperRequest{Action: "get", Name: name})
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize helper request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), HelperTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", helper+" get")
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", helper+" get")
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(append(request, '\n'))
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("credential helper failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var resp helperResponse
+	if err := json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return "", fmt.Errorf("failed to parse credential helper response: %w", err)
+	}
+	if resp.NotFound {
+		return "", fmt.Errorf("%w: %s", ErrCredentialNotFound, name)
+	}
+	if resp.Error != "" {
+		return "", fmt.Errorf("credential helper failed to get %s: %s", name, resp.Error)
+	}
+	if resp.Secret == "" {
+		return "", errors.New("credential helper returned an empty secret")
+	}
+	return resp.Secret, nil
+}
+
+// clearHelperCache forgets every secret returned by the helper.
+func clearHelperCache() {
+	helperCache.Lock()
+	defer helperCache.Unlock()
+	helperCache.secrets = map[string]string{}
+}
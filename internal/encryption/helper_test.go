@@ -0,0 +1,61 @@
+package encryption
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// setupFakeHelper configures testdata/fake-helper.sh as the credential helper and
+// returns the path of the file it logs each request to.
+func setupFakeHelper(t *testing.T) string {
+	t.Helper()
+	setupStoreEnv(t)
+	clearHelperCache()
+	t.Cleanup(clearHelperCache)
+
+	wd, err := os.Getwd()
+	assert.NoError(t, err)
+	logPath := filepath.Join(t.TempDir(), "requests.log")
+	t.Setenv("FAKE_HELPER_LOG", logPath)
+	t.Setenv(CREDENTIAL_HELPER, filepath.Join(wd, "testdata", "fake-helper.sh"))
+	return logPath
+}
+
+func TestGetAPIKeyFromHelper(t *testing.T) {
+	logPath := setupFakeHelper(t)
+	logger := zap.NewExample().Sugar()
+
+	key, err := GetAPIKey(logger)
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-from-helper", key)
+
+	// second lookup is served from the in-memory cache
+	key, err = GetAPIKey(logger)
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-from-helper", key)
+
+	requests, err := os.ReadFile(logPath)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(requests), "\n"), "helper should only be invoked once")
+	assert.Contains(t, string(requests), `"action":"get"`)
+}
+
+func TestGetCredentialHelperErrors(t *testing.T) {
+	setupFakeHelper(t)
+	logger := zap.NewExample().Sugar()
+
+	_, err := GetCredential("missing", logger)
+	assert.ErrorIs(t, err, ErrCredentialNotFound)
+
+	_, err = GetCredential("broken", logger)
+	assert.ErrorContains(t, err, "helper exploded")
+
+	_, err = GetCredential("locked", logger)
+	assert.ErrorContains(t, err, "keychain is locked")
+	assert.NotErrorIs(t, err, ErrCredentialNotFound, "only an explicit not_found falls back to the local store")
+}
@@ -1,6 +1,7 @@
 package encryption
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -63,7 +64,36 @@ func SaveAPIKey(key string, logger *zap.SugaredLogger) error {
 	return nil
 }
 
+// GetCredential returns the secret called name. When a credential helper is configured
+// it is asked instead of the local credential store.
+func GetCredential(name string, logger *zap.SugaredLogger) (string, error) {
+	if helper := CredentialHelper(); helper != "" {
+		secret, err := getFromHelper(helper, name)
+		if err != nil {
+			logger.Errorf("Credential helper could not provide %s: %v", name, err)
+			return "", err
+		}
+		return secret, nil
+	}
+	store, err := LoadStore()
+	if err != nil {
+		logger.Errorf("Failed to load credential store: %v", err)
+		return "", err
+	}
+	return store.Get(name)
+}
+
+// GetAPIKey returns the OpenAI API key. The credential helper or store is checked first,
+// falling back to the legacy ENC_KEY_FILE written by SaveAPIKey.
 func GetAPIKey(logger *zap.SugaredLogger) (string, error) {
+	secret, err := GetCredential(OpenAICredential, logger)
+	if err == nil {
+		return secret, nil
+	}
+	if !errors.Is(err, ErrCredentialNotFound) {
+		return "", err
+	}
+
 	processingDirPath, err := utils.CreateProcessingDir()
 	if err != nil {
 		return "", err
@@ -0,0 +1,356 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/jaxxk/anki-cards-generator/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// ENCRYPTED_EXT is the extension of every file encrypted with the encryption key.
+var ENCRYPTED_EXT = ".enc"
+
+// ROTATE_KEY_FILE keeps the new encryption key in the processing directory while a rotation
+// swaps the files, so an interrupted rotation can be resumed instead of losing every secret.
+var ROTATE_KEY_FILE = "rotate.key"
+
+const (
+	rotateStagingExt = ".rotate"
+	rotateBackupExt  = ".bak"
+)
+
+// EncryptedFiles returns every file under the processing directory sealed with the encryption key.
+func EncryptedFiles() ([]string, error) {
+	processingDirPath, err := utils.CreateProcessingDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create processing directory: %w", err)
+	}
+	var files []string
+	err = filepath.WalkDir(processingDirPath, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.HasSuffix(path, ENCRYPTED_EXT) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list encrypted files: %w", err)
+	}
+	return files, nil
+}
+
+// RotateEncryptionKey generates a new encryption key and re-encrypts every stored secret with it.
+// New ciphertexts are staged next to the originals and verified before any file is replaced, the
+// new key is written to ROTATE_KEY_FILE before the first swap, the old files are kept as backups
+// until the new key is persisted, and stale key material is only removed once everything
+// succeeded. Files sealed with the key of an interrupted rotation are re-encrypted as well.
+func RotateEncryptionKey(logger *zap.SugaredLogger) error {
+	oldKey, err := GetEncKey()
+	if err != nil {
+		return err
+	}
+	recoveryPath, err := rotateKeyPath()
+	if err != nil {
+		return err
+	}
+	keys := [][]byte{oldKey}
+	if pendingKey, err := readRotateKey(recoveryPath); err != nil {
+		return err
+	} else if pendingKey != nil {
+		logger.Warnf("Resuming an interrupted key rotation with the key in %s", recoveryPath)
+		keys = append(keys, pendingKey)
+	}
+	newKey, err := GenerateRandomKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate encryption key: %w", err)
+	}
+	files, err := EncryptedFiles()
+	if err != nil {
+		return err
+	}
+
+	// Stage and verify every re-encrypted file before touching the originals
+	staged := make([]string, 0, len(files))
+	cleanupStaged := func() {
+		for _, path := range staged {
+			os.Remove(path)
+		}
+	}
+	for _, path := range files {
+		stagedPath, err := stageRotation(path, keys, newKey)
+		if err != nil {
+			cleanupStaged()
+			return err
+		}
+		staged = append(staged, stagedPath)
+	}
+
+	// Keep the new key on disk until it is persisted, the swapped files cannot be read without it
+	if err := writeRotateKey(recoveryPath, newKey); err != nil {
+		cleanupStaged()
+		return err
+	}
+
+	// Swap staged files in, keeping the originals as backups
+	swapped := make([]string, 0, len(files))
+	restore := func() {
+		for _, path := range swapped {
+			if err := os.Rename(path+rotateBackupExt, path); err != nil {
+				logger.Errorf("Failed to restore %s from backup: %v", path, err)
+			}
+		}
+		cleanupStaged()
+		if len(keys) == 1 {
+			os.Remove(recoveryPath)
+		} else if err := writeRotateKey(recoveryPath, keys[1]); err != nil {
+			logger.Errorf("Failed to restore %s: %v", recoveryPath, err)
+		}
+	}
+	for _, path := range files {
+		// a backup left by an earlier run is either the file itself or sealed with a key it
+		// no longer needs
+		if err := os.Remove(path + rotateBackupExt); err != nil && !os.IsNotExist(err) {
+			restore()
+			return fmt.Errorf("failed to remove stale backup of %s: %w", path, err)
+		}
+		if err := os.Link(path, path+rotateBackupExt); err != nil {
+			restore()
+			return fmt.Errorf("failed to back up %s: %w", path, err)
+		}
+		if err := os.Rename(path+rotateStagingExt, path); err != nil {
+			os.Remove(path + rotateBackupExt)
+			restore()
+			return fmt.Errorf("failed to replace %s: %w", path, err)
+		}
+		swapped = append(swapped, path)
+	}
+
+	if err := ReplaceEncryptionKeyInEnv(ENC_KEY, newKey); err != nil {
+		restore()
+		return fmt.Errorf("failed to persist new encryption key: %w", err)
+	}
+
+	// Only now is the old key material safe to drop
+	for _, path := range swapped {
+		if err := os.Remove(path + rotateBackupExt); err != nil {
+			logger.Warnf("Failed to remove backup of %s: %v", path, err)
+		}
+	}
+	if err := os.Remove(recoveryPath); err != nil {
+		logger.Warnf("Failed to remove %s: %v", recoveryPath, err)
+	}
+	logger.Infof("Rotated encryption key and re-encrypted %d file(s)", len(files))
+	return nil
+}
+
+// rotateKeyPath returns the path of ROTATE_KEY_FILE.
+func rotateKeyPath() (string, error) {
+	processingDirPath, err := utils.CreateProcessingDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to create processing directory: %w", err)
+	}
+	return filepath.Join(processingDirPath, ROTATE_KEY_FILE), nil
+}
+
+// readRotateKey returns the key left at path by an interrupted rotation, or nil when there is none.
+func readRotateKey(path string) ([]byte, error) {
+	encoded, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode the key in %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// writeRotateKey writes key to path and syncs it, so it survives a crash right after.
+func writeRotateKey(path string, key []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	_, err = file.WriteString(base64.StdEncoding.EncodeToString(key) + "\n")
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// stageRotation re-encrypts path, sealed with any of keys, with newKey into a staging file and
+// checks it round-trips.
+func stageRotation(path string, keys [][]byte, newKey []byte) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	var plainText []byte
+	var lineSealed bool
+	for _, key := range keys {
+		if plainText, lineSealed, err = openSealed(data, key); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt %s with the current key: %w", path, err)
+	}
+	sealed, err := seal(plainText, newKey, lineSealed)
+	if err != nil {
+		return "", err
+	}
+
+	stagedPath := path + rotateStagingExt
+	if err := os.WriteFile(stagedPath, sealed, 0600); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", stagedPath, err)
+	}
+	written, err := os.ReadFile(stagedPath)
+	if err == nil {
+		var roundTrip []byte
+		roundTrip, _, err = openSealed(written, newKey)
+		if err == nil && !bytes.Equal(roundTrip, plainText) {
+			err = fmt.Errorf("decrypted content does not match")
+		}
+	}
+	if err != nil {
+		os.Remove(stagedPath)
+		return "", fmt.Errorf("failed to verify re-encrypted %s: %w", path, err)
+	}
+	return stagedPath, nil
+}
+
+// openSealed decrypts a file sealed as a whole, or line by line by AppendLine, in which case
+// lineSealed is set and the lines are returned joined by newlines.
+func openSealed(data, key []byte) (plainText []byte, lineSealed bool, err error) {
+	plainText, err = decryptBytes(data, key)
+	if err == nil {
+		return plainText, false, nil
+	}
+	var lines [][]byte
+	for _, line := range bytes.Split(bytes.TrimSpace(data), []byte("\n")) {
+		sealed, decodeErr := base64.StdEncoding.DecodeString(string(line))
+		if decodeErr != nil {
+			return nil, false, err
+		}
+		plainLine, lineErr := decryptBytes(sealed, key)
+		if lineErr != nil {
+			return nil, false, lineErr
+		}
+		lines = append(lines, plainLine)
+	}
+	return bytes.Join(lines, []byte("\n")), true, nil
+}
+
+// seal encrypts plainText as a whole, or line by line like AppendLine.
+func seal(plainText, key []byte, lineSealed bool) ([]byte, error) {
+	if !lineSealed {
+		return encryptBytes(plainText, key)
+	}
+	var buf bytes.Buffer
+	for _, line := range bytes.Split(plainText, []byte("\n")) {
+		sealed, err := encryptBytes(line, key)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString(base64.StdEncoding.EncodeToString(sealed))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// ReplaceEncryptionKeyInEnv sets key for the current session and persists it for future sessions,
+// removing any previous value of key from the shell configuration file instead of appending to it.
+func ReplaceEncryptionKeyInEnv(key string, data []byte) error {
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	switch runtime.GOOS {
+	case "windows":
+		// setx overwrites the previous value
+		cmd := exec.Command("setx", key, encoded)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("failed to persist environment variable on Windows: %w", err)
+		}
+	case "linux", "darwin":
+		configPath, err := shellConfigPath()
+		if err != nil {
+			return err
+		}
+		if err := replaceExportLine(configPath, key, encoded); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
+	}
+
+	if err := os.Setenv(key, encoded); err != nil {
+		return fmt.Errorf("failed to set environment variable: %w", err)
+	}
+	fmt.Println("Environment variable replaced. Run 'source ~/.zshrc' (or ~/.bashrc) to apply changes.")
+	return nil
+}
+
+// replaceExportLine atomically rewrites configPath with every `export key=` line replaced by one
+// new line. A symlinked configPath is rewritten where it points, keeping the link.
+func replaceExportLine(configPath, key, value string) error {
+	if resolved, err := filepath.EvalSymlinks(configPath); err == nil {
+		configPath = resolved
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to resolve shell configuration file: %w", err)
+	}
+	mode := fs.FileMode(0644)
+	var kept []string
+	content, err := os.ReadFile(configPath)
+	switch {
+	case err == nil:
+		if info, statErr := os.Stat(configPath); statErr == nil {
+			mode = info.Mode().Perm()
+		}
+		prefix := fmt.Sprintf("export %s=", key)
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for scanner.Scan() {
+			line := scanner.Text()
+			if strings.HasPrefix(strings.TrimSpace(line), prefix) {
+				continue
+			}
+			kept = append(kept, line)
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("failed to read shell configuration file: %w", err)
+		}
+	case os.IsNotExist(err):
+	default:
+		return fmt.Errorf("failed to read shell configuration file: %w", err)
+	}
+	kept = append(kept, fmt.Sprintf("export %s=%s", key, value))
+
+	tmpPath := configPath + ".poggers.tmp"
+	if err := os.WriteFile(tmpPath, []byte(strings.Join(kept, "\n")+"\n"), mode); err != nil {
+		return fmt.Errorf("failed to write shell configuration file: %w", err)
+	}
+	if err := os.Rename(tmpPath, configPath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace shell configuration file: %w", err)
+	}
+	return nil
+}
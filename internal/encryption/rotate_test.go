@@ -0,0 +1,150 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestRotateEncryptionKey(t *testing.T) {
+	home := setupStoreEnv(t)
+	t.Setenv("SHELL", "/bin/bash")
+	logger := zap.NewExample().Sugar()
+	oldEncoded := os.Getenv(ENC_KEY)
+
+	bashrc := filepath.Join(home, ".bashrc")
+	assert.NoError(t, os.WriteFile(bashrc, []byte("alias ll='ls -l'\nexport "+ENC_KEY+"="+oldEncoded+"\n"), 0644))
+
+	store, err := LoadStore()
+	assert.NoError(t, err)
+	assert.NoError(t, store.Add(AnkiConnectCredential, "anki-secret"))
+	assert.NoError(t, store.Save())
+	assert.NoError(t, SaveAPIKey("sk-legacy", logger))
+	t.Setenv(ENCRYPT_AT_REST, "true")
+	processingDir := filepath.Join(home, ".anki-cards-generator")
+	for _, line := range []string{`{"n":1}`, `{"n":2}`} {
+		_, err = AppendLine([]byte(line), processingDir, "usage.jsonl")
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, RotateEncryptionKey(logger))
+
+	newEncoded := os.Getenv(ENC_KEY)
+	assert.NotEqual(t, oldEncoded, newEncoded)
+
+	// every secret is readable with the new key
+	store, err = LoadStore()
+	assert.NoError(t, err)
+	secret, err := store.Get(AnkiConnectCredential)
+	assert.NoError(t, err)
+	assert.Equal(t, "anki-secret", secret)
+	apiKey, err := GetAPIKey(logger)
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-legacy", apiKey)
+	lines, err := ReadLines(filepath.Join(processingDir, "usage.jsonl"))
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte(`{"n":1}`), []byte(`{"n":2}`)}, lines, "line-sealed files are re-sealed line by line")
+
+	// stale key material is gone and no staging files are left behind
+	config, err := os.ReadFile(bashrc)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(config), "export "+ENC_KEY+"="))
+	assert.Contains(t, string(config), newEncoded)
+	assert.Contains(t, string(config), "alias ll='ls -l'")
+
+	files, err := EncryptedFiles()
+	assert.NoError(t, err)
+	assert.Len(t, files, 3)
+	for _, path := range files {
+		assert.NoFileExists(t, path+rotateBackupExt)
+		assert.NoFileExists(t, path+rotateStagingExt)
+	}
+	assert.NoFileExists(t, filepath.Join(processingDir, ROTATE_KEY_FILE))
+}
+
+func TestRotateEncryptionKeyResumes(t *testing.T) {
+	home := setupStoreEnv(t)
+	t.Setenv("SHELL", "/bin/bash")
+	logger := zap.NewExample().Sugar()
+
+	// ~/.bashrc is a link into a dotfiles repo
+	dotfiles := filepath.Join(home, "dotfiles")
+	assert.NoError(t, os.Mkdir(dotfiles, 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dotfiles, "bashrc"), []byte("export "+ENC_KEY+"="+os.Getenv(ENC_KEY)+"\n"), 0644))
+	bashrc := filepath.Join(home, ".bashrc")
+	assert.NoError(t, os.Symlink(filepath.Join(dotfiles, "bashrc"), bashrc))
+
+	store, err := LoadStore()
+	assert.NoError(t, err)
+	assert.NoError(t, store.Add(AnkiConnectCredential, "anki-secret"))
+	assert.NoError(t, store.Save())
+	assert.NoError(t, SaveAPIKey("sk-legacy", logger))
+	files, err := EncryptedFiles()
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	// an earlier rotation stopped after swapping the first file and left its backup behind
+	oldKey, err := GetEncKey()
+	assert.NoError(t, err)
+	pendingKey, err := GenerateRandomKey()
+	assert.NoError(t, err)
+	recoveryPath, err := rotateKeyPath()
+	assert.NoError(t, err)
+	assert.NoError(t, writeRotateKey(recoveryPath, pendingKey))
+	assert.NoError(t, os.Link(files[0], files[0]+rotateBackupExt))
+	staged, err := stageRotation(files[0], [][]byte{oldKey}, pendingKey)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Rename(staged, files[0]))
+
+	assert.NoError(t, RotateEncryptionKey(logger))
+
+	store, err = LoadStore()
+	assert.NoError(t, err)
+	secret, err := store.Get(AnkiConnectCredential)
+	assert.NoError(t, err)
+	assert.Equal(t, "anki-secret", secret)
+	apiKey, err := GetAPIKey(logger)
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-legacy", apiKey)
+	assert.NoFileExists(t, recoveryPath)
+	assert.NoFileExists(t, files[0]+rotateBackupExt)
+
+	info, err := os.Lstat(bashrc)
+	assert.NoError(t, err)
+	assert.Equal(t, os.ModeSymlink, info.Mode()&os.ModeSymlink, "the link is kept")
+	config, err := os.ReadFile(bashrc)
+	assert.NoError(t, err)
+	assert.Equal(t, "export "+ENC_KEY+"="+os.Getenv(ENC_KEY)+"\n", string(config))
+}
+
+func TestRotateEncryptionKeyWrongKey(t *testing.T) {
+	setupStoreEnv(t)
+	t.Setenv("SHELL", "/bin/bash")
+	logger := zap.NewExample().Sugar()
+
+	store, err := LoadStore()
+	assert.NoError(t, err)
+	assert.NoError(t, store.Add(OpenAICredential, "sk-openai"))
+	assert.NoError(t, store.Save())
+
+	// a key that cannot decrypt the store must leave everything untouched
+	other, err := GenerateRandomKey()
+	assert.NoError(t, err)
+	assert.NoError(t, os.Setenv(ENC_KEY, base64.StdEncoding.EncodeToString(other)))
+	files, err := EncryptedFiles()
+	assert.NoError(t, err)
+	before, err := os.ReadFile(files[0])
+	assert.NoError(t, err)
+
+	assert.Error(t, RotateEncryptionKey(logger))
+
+	after, err := os.ReadFile(files[0])
+	assert.NoError(t, err)
+	assert.Equal(t, before, after)
+	assert.NoFileExists(t, files[0]+rotateStagingExt)
+}
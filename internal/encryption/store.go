@@ -0,0 +1,198 @@
+package encryption
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jaxxk/anki-cards-generator/pkg/utils"
+)
+
+var CREDS_FILE = "creds.enc"
+
+// Well known credential names used by poggers.
+const (
+	OpenAICredential      = "openai"
+	GatewayCredential     = "openai-gateway"
+	AnthropicCredential   = "anthropic"
+	AnkiConnectCredential = "ankiconnect"
+)
+
+// ErrCredentialNotFound is returned when a named credential is not in the store.
+var ErrCredentialNotFound = errors.New("credential not found")
+
+// Credential is a single named secret.
+type Credential struct {
+	Secret    string    `json:"secret"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// CredentialInfo describes a stored credential without exposing its secret.
+type CredentialInfo struct {
+	Name        string
+	Fingerprint string
+	UpdatedAt   time.Time
+}
+
+// Store holds every named credential. It is persisted encrypted in CREDS_FILE.
+type Store struct {
+	Credentials map[string]Credential `json:"credentials"`
+}
+
+// Fingerprint returns a short, non-reversible identifier for a secret.
+func Fingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return "SHA256:" + hex.EncodeToString(sum[:8])
+}
+
+func credsFilePath() (string, error) {
+	processingDirPath, err := utils.CreateProcessingDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to create processing directory: %w", err)
+	}
+	return filepath.Join(processingDirPath, CREDS_FILE), nil
+}
+
+// LoadStore decrypts the credential store. A missing store is returned empty.
+func LoadStore() (*Store, error) {
+	store := &Store{Credentials: map[string]Credential{}}
+	path, err := credsFilePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credential store: %w", err)
+	}
+	encryptionKey, err := GetEncKey()
+	if err != nil {
+		return nil, err
+	}
+	plainText, err := decryptBytes(data, encryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt credential store at %s: %w", path, err)
+	}
+	if err := json.Unmarshal(plainText, store); err != nil {
+		return nil, fmt.Errorf("failed to parse credential store: %w", err)
+	}
+	if store.Credentials == nil {
+		store.Credentials = map[string]Credential{}
+	}
+	return store, nil
+}
+
+// Save encrypts the store and replaces CREDS_FILE.
+func (s *Store) Save() error {
+	path, err := credsFilePath()
+	if err != nil {
+		return err
+	}
+	encryptionKey, err := GetEncKey()
+	if err != nil {
+		return err
+	}
+	plainText, err := json.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("failed to serialize credential store: %w", err)
+	}
+	data, err := encryptBytes(plainText, encryptionKey)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write credential store: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace credential store: %w", err)
+	}
+	return nil
+}
+
+// lookup returns the credential stored under name, with surrounding spaces ignored like Add
+// does, and the name it is stored under.
+func (s *Store) lookup(name string) (string, Credential, error) {
+	name = strings.TrimSpace(name)
+	cred, ok := s.Credentials[name]
+	if !ok {
+		return name, Credential{}, fmt.Errorf("%w: %s", ErrCredentialNotFound, name)
+	}
+	return name, cred, nil
+}
+
+// Get returns the secret stored under name.
+func (s *Store) Get(name string) (string, error) {
+	_, cred, err := s.lookup(name)
+	if err != nil {
+		return "", err
+	}
+	return cred.Secret, nil
+}
+
+// Add stores a new credential. It fails if name is already taken.
+func (s *Store) Add(name, secret string) error {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return errors.New("credential name cannot be empty")
+	}
+	if secret == "" {
+		return errors.New("credential secret cannot be empty")
+	}
+	if _, ok := s.Credentials[name]; ok {
+		return fmt.Errorf("credential %s already exists, use rotate to replace it", name)
+	}
+	now := time.Now().UTC()
+	s.Credentials[name] = Credential{Secret: secret, CreatedAt: now, UpdatedAt: now}
+	return nil
+}
+
+// Rotate replaces the secret of an existing credential.
+func (s *Store) Rotate(name, secret string) error {
+	name, cred, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		return errors.New("credential secret cannot be empty")
+	}
+	cred.Secret = secret
+	cred.UpdatedAt = time.Now().UTC()
+	s.Credentials[name] = cred
+	return nil
+}
+
+// Remove deletes a credential.
+func (s *Store) Remove(name string) error {
+	name, _, err := s.lookup(name)
+	if err != nil {
+		return err
+	}
+	delete(s.Credentials, name)
+	return nil
+}
+
+// List returns the stored credentials sorted by name, with fingerprints instead of secrets.
+func (s *Store) List() []CredentialInfo {
+	infos := make([]CredentialInfo, 0, len(s.Credentials))
+	for name, cred := range s.Credentials {
+		infos = append(infos, CredentialInfo{
+			Name:        name,
+			Fingerprint: Fingerprint(cred.Secret),
+			UpdatedAt:   cred.UpdatedAt,
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
@@ -0,0 +1,102 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jaxxk/anki-cards-generator/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// setupStoreEnv points the processing dir at a temporary home and sets a fresh encryption key.
+func setupStoreEnv(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	key, err := GenerateRandomKey()
+	assert.NoError(t, err)
+	t.Setenv(ENC_KEY, base64.StdEncoding.EncodeToString(key))
+	return home
+}
+
+func TestStoreRoundTrip(t *testing.T) {
+	setupStoreEnv(t)
+
+	store, err := LoadStore()
+	assert.NoError(t, err)
+	assert.Empty(t, store.List())
+
+	assert.NoError(t, store.Add(OpenAICredential, "sk-openai"))
+	assert.NoError(t, store.Add(AnkiConnectCredential, "anki-secret"))
+	assert.Error(t, store.Add(OpenAICredential, "sk-other"), "duplicate names should be rejected")
+	assert.NoError(t, store.Save())
+
+	loaded, err := LoadStore()
+	assert.NoError(t, err)
+	secret, err := loaded.Get(AnkiConnectCredential)
+	assert.NoError(t, err)
+	assert.Equal(t, "anki-secret", secret)
+
+	infos := loaded.List()
+	assert.Len(t, infos, 2)
+	assert.Equal(t, AnkiConnectCredential, infos[0].Name)
+	assert.Equal(t, Fingerprint("anki-secret"), infos[0].Fingerprint)
+
+	// The store must never be written in plain text
+	processingDir, err := utils.CreateProcessingDir()
+	assert.NoError(t, err)
+	raw, err := os.ReadFile(filepath.Join(processingDir, CREDS_FILE))
+	assert.NoError(t, err)
+	assert.False(t, strings.Contains(string(raw), "anki-secret"))
+}
+
+func TestStoreRotateAndRemove(t *testing.T) {
+	setupStoreEnv(t)
+
+	store, err := LoadStore()
+	assert.NoError(t, err)
+	assert.ErrorIs(t, store.Rotate(AnthropicCredential, "x"), ErrCredentialNotFound)
+	assert.NoError(t, store.Add(AnthropicCredential, "old"))
+	assert.NoError(t, store.Rotate(AnthropicCredential, "new"))
+	secret, err := store.Get(AnthropicCredential)
+	assert.NoError(t, err)
+	assert.Equal(t, "new", secret)
+
+	assert.NoError(t, store.Remove(AnthropicCredential))
+	_, err = store.Get(AnthropicCredential)
+	assert.ErrorIs(t, err, ErrCredentialNotFound)
+}
+
+func TestStoreTrimsNames(t *testing.T) {
+	setupStoreEnv(t)
+
+	store, err := LoadStore()
+	assert.NoError(t, err)
+	assert.NoError(t, store.Add(" foo ", "old"))
+	secret, err := store.Get(" foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "old", secret)
+	assert.NoError(t, store.Rotate("foo ", "new"))
+	secret, err = store.Get("foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "new", secret)
+	assert.NoError(t, store.Remove(" foo"))
+	assert.Empty(t, store.List())
+}
+
+func TestGetAPIKeyPrefersStore(t *testing.T) {
+	setupStoreEnv(t)
+
+	store, err := LoadStore()
+	assert.NoError(t, err)
+	assert.NoError(t, store.Add(OpenAICredential, "sk-from-store"))
+	assert.NoError(t, store.Save())
+
+	key, err := GetAPIKey(zap.NewExample().Sugar())
+	assert.NoError(t, err)
+	assert.Equal(t, "sk-from-store", key)
+}
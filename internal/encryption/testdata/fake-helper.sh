@@ -0,0 +1,12 @@
+#!/bin/sh
+# Fake credential helper used by helper_test.go.
+# Every request is appended to $FAKE_HELPER_LOG so tests can count invocations.
+[ "$1" = "get" ] || { echo "unsupported action: $1" >&2; exit 2; }
+read -r request
+echo "$request" >> "$FAKE_HELPER_LOG"
+case "$request" in
+	*'"name":"openai"'*) echo '{"name":"openai","secret":"sk-from-helper"}' ;;
+	*'"name":"broken"'*) echo "helper exploded" >&2; exit 1 ;;
+	*'"name":"locked"'*) echo '{"error":"keychain is locked"}' ;;
+	*) echo '{"not_found":true}' ;;
+esac
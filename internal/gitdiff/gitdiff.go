@@ -0,0 +1,156 @@
+// Package gitdiff finds the lines of files that changed between two git revisions, using the
+// git CLI.
+package gitdiff
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// MarkdownPathspecs select the Markdown and text notes poggers generates cards from.
+var MarkdownPathspecs = []string{"*.md", "*.markdown", "*.txt"}
+
+// Hunk is a range of lines in the new version of a file. Count is zero when lines were only
+// deleted, in which case Start is the line before the deletion.
+type Hunk struct {
+	Start int
+	Count int
+}
+
+// End returns the last line of the hunk.
+func (h Hunk) End() int {
+	return h.Start + h.Count - 1
+}
+
+// FileChange lists the changed lines of one file.
+type FileChange struct {
+	// Path relative to the repository root, in the new revision
+	Path  string
+	Hunks []Hunk
+	// Deleted is set for files that no longer exist in the new revision
+	Deleted bool
+}
+
+// Added returns the hunks that add or modify lines, leaving out pure deletions.
+func (f FileChange) Added() []Hunk {
+	var hunks []Hunk
+	for _, hunk := range f.Hunks {
+		if hunk.Count > 0 {
+			hunks = append(hunks, hunk)
+		}
+	}
+	return hunks
+}
+
+// git runs git in dir and returns its standard output.
+func git(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// RepoRoot returns the root of the git work tree containing dir.
+func RepoRoot(ctx context.Context, dir string) (string, error) {
+	out, err := git(ctx, dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Changes returns the files matching pathspecs that changed between rev and HEAD in the
+// repository at repo, with their changed lines.
+func Changes(ctx context.Context, repo, rev string, pathspecs ...string) ([]FileChange, error) {
+	args := []string{"diff", "--unified=0", "--no-color", "--no-ext-diff", "--no-renames", rev, "HEAD", "--"}
+	out, err := git(ctx, repo, append(args, pathspecs...)...)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(bytes.NewReader(out))
+}
+
+// ShowFile returns the content of path, relative to the repository root, at rev.
+func ShowFile(ctx context.Context, repo, rev, path string) ([]byte, error) {
+	return git(ctx, repo, "show", rev+":"+path)
+}
+
+// Parse reads the output of git diff --unified=0.
+func Parse(diff io.Reader) ([]FileChange, error) {
+	var changes []FileChange
+	var current *FileChange
+	scanner := bufio.NewScanner(diff)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			changes = append(changes, FileChange{})
+			current = &changes[len(changes)-1]
+		case current == nil:
+			continue
+		case strings.HasPrefix(line, "--- "):
+			if current.Path == "" {
+				current.Path = diffPath(line[4:], "a/")
+			}
+		case strings.HasPrefix(line, "+++ "):
+			if path := line[4:]; path == "/dev/null" {
+				current.Deleted = true
+			} else {
+				current.Path = diffPath(path, "b/")
+			}
+		case strings.HasPrefix(line, "@@ "):
+			hunk, err := parseHunkHeader(line)
+			if err != nil {
+				return nil, err
+			}
+			current.Hunks = append(current.Hunks, hunk)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read diff: %w", err)
+	}
+	return changes, nil
+}
+
+// diffPath strips the a/ or b/ prefix from a path in a diff header, unquoting it first.
+func diffPath(path, prefix string) string {
+	path = strings.TrimSuffix(path, "\t")
+	if unquoted, err := strconv.Unquote(path); err == nil {
+		path = unquoted
+	}
+	if path == "/dev/null" {
+		return ""
+	}
+	return strings.TrimPrefix(path, prefix)
+}
+
+// parseHunkHeader parses "@@ -a,b +c,d @@" into the new range c,d.
+func parseHunkHeader(line string) (Hunk, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !strings.HasPrefix(fields[2], "+") {
+		return Hunk{}, fmt.Errorf("invalid hunk header %q", line)
+	}
+	start, count, found := strings.Cut(fields[2][1:], ",")
+	hunk := Hunk{Count: 1}
+	var err error
+	if hunk.Start, err = strconv.Atoi(start); err != nil {
+		return Hunk{}, fmt.Errorf("invalid hunk header %q: %w", line, err)
+	}
+	if found {
+		if hunk.Count, err = strconv.Atoi(count); err != nil {
+			return Hunk{}, fmt.Errorf("invalid hunk header %q: %w", line, err)
+		}
+	}
+	return hunk, nil
+}
@@ -0,0 +1,111 @@
+package gitdiff
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const sampleDiff = `diff --git a/notes/go.md b/notes/go.md
+index 3b18e51..a1f2c3d 100644
+--- a/notes/go.md
++++ b/notes/go.md
+@@ -3,0 +4,2 @@ Goroutines
++Channels are typed.
++Closing a channel signals no more values.
+@@ -10 +12 @@ Maps
+-Maps are unordered.
++Maps are unordered hash tables.
+@@ -20,2 +21,0 @@ Slices
+-old line
+-old line
+diff --git a/old.md b/old.md
+deleted file mode 100644
+index 3b18e51..0000000
+--- a/old.md
++++ /dev/null
+@@ -1,2 +0,0 @@
+-# Old
+-gone
+diff --git a/new.md b/new.md
+new file mode 100644
+index 0000000..3b18e51
+--- /dev/null
++++ b/new.md
+@@ -0,0 +1 @@
++# New
+`
+
+func TestParse(t *testing.T) {
+	changes, err := Parse(strings.NewReader(sampleDiff))
+	assert.NoError(t, err)
+	assert.Equal(t, []FileChange{
+		{Path: "notes/go.md", Hunks: []Hunk{{Start: 4, Count: 2}, {Start: 12, Count: 1}, {Start: 21, Count: 0}}},
+		{Path: "old.md", Hunks: []Hunk{{Start: 0, Count: 0}}, Deleted: true},
+		{Path: "new.md", Hunks: []Hunk{{Start: 1, Count: 1}}},
+	}, changes)
+
+	assert.Equal(t, []Hunk{{Start: 4, Count: 2}, {Start: 12, Count: 1}}, changes[0].Added())
+	assert.Equal(t, 5, changes[0].Hunks[0].End())
+}
+
+func TestParseInvalidHunk(t *testing.T) {
+	_, err := Parse(strings.NewReader("diff --git a/x.md b/x.md\n@@ -1 1 @@\n"))
+	assert.ErrorContains(t, err, "invalid hunk header")
+}
+
+// gitRepo creates a repository in a temporary directory, skipping the test without git.
+func gitRepo(t *testing.T) (string, func(args ...string)) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		cmd.Env = append(os.Environ(), "GIT_CONFIG_GLOBAL=/dev/null", "GIT_CONFIG_SYSTEM=/dev/null",
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		out, err := cmd.CombinedOutput()
+		assert.NoError(t, err, string(out))
+	}
+	run("init", "-q")
+	return dir, run
+}
+
+func TestChanges(t *testing.T) {
+	dir, run := gitRepo(t)
+	write := func(name, content string) {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	write("notes/go.md", "# Go\nGoroutines are cheap.\n")
+	write("main.go", "package main\n")
+	run("add", "-A")
+	run("commit", "-q", "-m", "first")
+
+	write("notes/go.md", "# Go\nGoroutines are cheap.\n\n## Channels\nChannels are typed.\n")
+	write("main.go", "package main\n\nfunc main() {}\n")
+	run("add", "-A")
+	run("commit", "-q", "-m", "second")
+
+	ctx := context.Background()
+	root, err := RepoRoot(ctx, filepath.Join(dir, "notes"))
+	assert.NoError(t, err)
+	changes, err := Changes(ctx, root, "HEAD~1", MarkdownPathspecs...)
+	assert.NoError(t, err)
+	assert.Equal(t, []FileChange{{Path: "notes/go.md", Hunks: []Hunk{{Start: 3, Count: 3}}}}, changes)
+
+	content, err := ShowFile(ctx, root, "HEAD", "notes/go.md")
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "Channels are typed.")
+
+	_, err = Changes(ctx, root, "no-such-rev", MarkdownPathspecs...)
+	assert.Error(t, err)
+}
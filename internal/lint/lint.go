@@ -0,0 +1,201 @@
+// Package lint checks flashcards against rules derived from the minimum information
+// principle: one short fact per card, asked as a clear question that does not give the
+// answer away.
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jaxxk/anki-cards-generator/internal/transform"
+)
+
+// Severity ranks findings. SeverityOff turns a rule off.
+type Severity int
+
+const (
+	SeverityOff Severity = iota
+	SeverityInfo
+	SeverityWarning
+	SeverityError
+)
+
+var severityNames = map[Severity]string{
+	SeverityOff:     "off",
+	SeverityInfo:    "info",
+	SeverityWarning: "warning",
+	SeverityError:   "error",
+}
+
+func (s Severity) String() string {
+	return severityNames[s]
+}
+
+// ParseSeverity parses off, info, warning or error. "none" is accepted for off, so
+// --fail-on none never fails.
+func ParseSeverity(name string) (Severity, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "none" {
+		return SeverityOff, nil
+	}
+	for severity, severityName := range severityNames {
+		if name == severityName {
+			return severity, nil
+		}
+	}
+	return SeverityOff, fmt.Errorf("unknown severity %q, use off, info, warning or error", name)
+}
+
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *Severity) UnmarshalText(text []byte) error {
+	severity, err := ParseSeverity(string(text))
+	if err != nil {
+		return err
+	}
+	*s = severity
+	return nil
+}
+
+// Rule checks one card and returns a message for every problem found.
+type Rule struct {
+	Name        string
+	Description string
+	// Severity is used unless the config overrides it
+	Severity Severity
+	Check    func(card transform.Flashcards, config Config) []string
+}
+
+// Config sets the severity of rules and their limits.
+type Config struct {
+	// Rules overrides the severity of rules by name
+	Rules map[string]Severity `json:"rules,omitempty"`
+	// MaxBackWords is the longest back, not counting code blocks
+	MaxBackWords int `json:"maxBackWords,omitempty"`
+	// MaxListItems is the most list items a back may enumerate
+	MaxListItems int `json:"maxListItems,omitempty"`
+}
+
+// DefaultConfig uses every rule with its own severity.
+var DefaultConfig = Config{MaxBackWords: 80, MaxListItems: 4}
+
+// LoadConfig returns DefaultConfig overlaid with the JSON file at path, e.g.
+// {"rules": {"back-length": "error", "vague-pronoun": "off"}, "maxBackWords": 60}.
+// An empty path returns the defaults.
+func LoadConfig(path string) (Config, error) {
+	config := DefaultConfig
+	config.Rules = map[string]Severity{}
+	if path == "" {
+		return config, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to read lint config: %w", err)
+	}
+	overrides := Config{}
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return Config{}, fmt.Errorf("failed to parse lint config %s: %w", path, err)
+	}
+	for name, severity := range overrides.Rules {
+		if FindRule(name) == nil {
+			return Config{}, fmt.Errorf("failed to parse lint config %s: unknown rule %q", path, name)
+		}
+		config.Rules[name] = severity
+	}
+	if overrides.MaxBackWords > 0 {
+		config.MaxBackWords = overrides.MaxBackWords
+	}
+	if overrides.MaxListItems > 0 {
+		config.MaxListItems = overrides.MaxListItems
+	}
+	return config, nil
+}
+
+// severityOf returns the severity of rule under the config.
+func (c Config) severityOf(rule Rule) Severity {
+	if severity, ok := c.Rules[rule.Name]; ok {
+		return severity
+	}
+	return rule.Severity
+}
+
+// FindRule returns the rule called name, or nil.
+func FindRule(name string) *Rule {
+	for i := range Rules {
+		if Rules[i].Name == name {
+			return &Rules[i]
+		}
+	}
+	return nil
+}
+
+// Finding is a problem with one card.
+type Finding struct {
+	// Card is the index of the card in the deck
+	Card     int
+	Front    string
+	Rule     string
+	Severity Severity
+	Message  string
+}
+
+// Report holds the findings of linting a deck, ordered by card.
+type Report struct {
+	Cards    int
+	Findings []Finding
+}
+
+// Lint checks every card of deck against every rule that is not off.
+func Lint(deck transform.Deck, config Config) Report {
+	report := Report{Cards: len(deck.Cards)}
+	for i, card := range deck.Cards {
+		for _, rule := range Rules {
+			severity := config.severityOf(rule)
+			if severity == SeverityOff {
+				continue
+			}
+			for _, message := range rule.Check(card, config) {
+				report.Findings = append(report.Findings, Finding{Card: i, Front: card.Front, Rule: rule.Name, Severity: severity, Message: message})
+			}
+		}
+	}
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		return report.Findings[i].Card < report.Findings[j].Card
+	})
+	return report
+}
+
+// Count returns the number of findings of severity.
+func (r Report) Count(severity Severity) int {
+	count := 0
+	for _, finding := range r.Findings {
+		if finding.Severity == severity {
+			count++
+		}
+	}
+	return count
+}
+
+// Fails reports whether any finding is at least as severe as threshold. SeverityOff never fails.
+func (r Report) Fails(threshold Severity) bool {
+	if threshold == SeverityOff {
+		return false
+	}
+	for _, finding := range r.Findings {
+		if finding.Severity >= threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// Summary describes the report in one line.
+func (r Report) Summary() string {
+	return fmt.Sprintf("%d card(s): %d error(s), %d warning(s), %d info", r.Cards,
+		r.Count(SeverityError), r.Count(SeverityWarning), r.Count(SeverityInfo))
+}
@@ -0,0 +1,115 @@
+package lint
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jaxxk/anki-cards-generator/internal/transform"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRules(t *testing.T) {
+	tests := []struct {
+		rule  string
+		card  transform.Flashcards
+		found bool
+	}{
+		{"empty-field", transform.Flashcards{Front: "What is a goroutine?", Back: " "}, true},
+		{"empty-field", transform.Flashcards{Front: "What is a goroutine?", Back: "A function."}, false},
+		{"answer-leakage", transform.Flashcards{Front: "What keyword starts a goroutine, the go keyword?", Back: "The go keyword"}, true},
+		{"answer-leakage", transform.Flashcards{Front: "Which package provides Mutex, the sync package?", Back: "sync"}, true},
+		{"answer-leakage", transform.Flashcards{Front: "Which package provides Mutex?", Back: "sync"}, false},
+		{"not-a-question", transform.Flashcards{Front: "Goroutines", Back: "Cheap threads."}, true},
+		{"not-a-question", transform.Flashcards{Front: "What is a goroutine", Back: "A function."}, true},
+		{"not-a-question", transform.Flashcards{Front: "Explain goroutines.", Back: "Cheap threads."}, false},
+		{"not-a-question", transform.Flashcards{Front: "A {{c1::goroutine}} is cheap.", Back: ""}, false},
+		{"multiple-facts", transform.Flashcards{Front: "What is a goroutine? How is it started?", Back: "A function, with go."}, true},
+		{"multiple-facts", transform.Flashcards{Front: "What is a goroutine and how is it started?", Back: "A function, with go."}, true},
+		{"multiple-facts", transform.Flashcards{Front: "What do sync primitives include?", Back: "- Mutex\n- RWMutex\n- WaitGroup\n- Once\n- Cond"}, true},
+		{"multiple-facts", transform.Flashcards{Front: "What is the difference between a goroutine and a thread?", Back: "Goroutines are cheaper."}, false},
+		{"vague-pronoun", transform.Flashcards{Front: "What does it return?", Back: "An error."}, true},
+		{"vague-pronoun", transform.Flashcards{Front: "Why is this important?", Back: "Because."}, true},
+		{"vague-pronoun", transform.Flashcards{Front: "What does Close return?", Back: "An error."}, false},
+		{"code-fence", transform.Flashcards{Front: "How do you start a goroutine?", Back: "Like this:\nfunc main() {\n\tgo work()\n}"}, true},
+		{"code-fence", transform.Flashcards{Front: "How do you start a goroutine?", Back: "Like this:\n```go\nfunc main() {\n\tgo work()\n}\n```"}, false},
+	}
+	for _, tt := range tests {
+		rule := FindRule(tt.rule)
+		if !assert.NotNil(t, rule, tt.rule) {
+			continue
+		}
+		messages := rule.Check(tt.card, DefaultConfig)
+		if tt.found {
+			assert.NotEmpty(t, messages, "%s should flag %q", tt.rule, tt.card.Front)
+		} else {
+			assert.Empty(t, messages, "%s should not flag %q", tt.rule, tt.card.Front)
+		}
+	}
+}
+
+func TestBackLength(t *testing.T) {
+	card := transform.Flashcards{Front: "What is a goroutine?", Back: "word "}
+	for i := 0; i < 6; i++ {
+		card.Back += card.Back
+	}
+	// 64 words
+	assert.Empty(t, checkBackLength(card, DefaultConfig))
+	assert.Equal(t, []string{"the back has 64 words, more than 50"}, checkBackLength(card, Config{MaxBackWords: 50}))
+
+	// code blocks do not count
+	card.Back = "Short.\n```go\n" + card.Back + "\n```"
+	assert.Empty(t, checkBackLength(card, Config{MaxBackWords: 50}))
+}
+
+func TestLint(t *testing.T) {
+	deck := transform.Deck{Title: "Go", Cards: []transform.Flashcards{
+		{Front: "What is a goroutine?", Back: "A function running concurrently."},
+		{Front: "What does it return?", Back: ""},
+	}}
+
+	report := Lint(deck, DefaultConfig)
+	assert.Len(t, report.Findings, 2)
+	assert.Equal(t, Finding{Card: 1, Front: "What does it return?", Rule: "empty-field", Severity: SeverityError, Message: "the back is empty"}, report.Findings[0])
+	assert.Equal(t, "vague-pronoun", report.Findings[1].Rule)
+	assert.Equal(t, "2 card(s): 1 error(s), 1 warning(s), 0 info", report.Summary())
+	assert.True(t, report.Fails(SeverityError))
+	assert.True(t, report.Fails(SeverityWarning))
+	assert.False(t, report.Fails(SeverityOff))
+
+	// rules can be turned off or made more severe
+	report = Lint(deck, Config{Rules: map[string]Severity{"empty-field": SeverityOff, "vague-pronoun": SeverityError}})
+	assert.Len(t, report.Findings, 1)
+	assert.Equal(t, SeverityError, report.Findings[0].Severity)
+}
+
+func TestLoadConfig(t *testing.T) {
+	config, err := LoadConfig("")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultConfig.MaxBackWords, config.MaxBackWords)
+
+	path := filepath.Join(t.TempDir(), "lint.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"rules": {"back-length": "error", "code-fence": "off"}, "maxBackWords": 40}`), 0644))
+	config, err = LoadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]Severity{"back-length": SeverityError, "code-fence": SeverityOff}, config.Rules)
+	assert.Equal(t, 40, config.MaxBackWords)
+	assert.Equal(t, DefaultConfig.MaxListItems, config.MaxListItems)
+
+	assert.NoError(t, os.WriteFile(path, []byte(`{"rules": {"no-such-rule": "error"}}`), 0644))
+	_, err = LoadConfig(path)
+	assert.ErrorContains(t, err, "unknown rule")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"rules": {"back-length": "fatal"}}`), 0644))
+	_, err = LoadConfig(path)
+	assert.ErrorContains(t, err, "unknown severity")
+}
+
+func TestParseSeverity(t *testing.T) {
+	for name, want := range map[string]Severity{"error": SeverityError, "Warning": SeverityWarning, "info": SeverityInfo, "off": SeverityOff, "none": SeverityOff} {
+		got, err := ParseSeverity(name)
+		assert.NoError(t, err)
+		assert.Equal(t, want, got)
+	}
+	_, err := ParseSeverity("fatal")
+	assert.Error(t, err)
+}
@@ -0,0 +1,219 @@
+package lint
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/jaxxk/anki-cards-generator/internal/stopwords"
+	"github.com/jaxxk/anki-cards-generator/internal/transform"
+)
+
+// Rules are every rule the linter knows, in the order they are checked.
+var Rules = []Rule{
+	{
+		Name:        "empty-field",
+		Description: "the front and the back must not be empty",
+		Severity:    SeverityError,
+		Check:       checkEmptyField,
+	},
+	{
+		Name:        "answer-leakage",
+		Description: "the front must not give the answer away",
+		Severity:    SeverityError,
+		Check:       checkAnswerLeakage,
+	},
+	{
+		Name:        "not-a-question",
+		Description: "the front should ask a question or give a prompt",
+		Severity:    SeverityWarning,
+		Check:       checkNotAQuestion,
+	},
+	{
+		Name:        "back-length",
+		Description: "the back should be short enough to recall at once",
+		Severity:    SeverityWarning,
+		Check:       checkBackLength,
+	},
+	{
+		Name:        "multiple-facts",
+		Description: "a card should ask for one fact",
+		Severity:    SeverityWarning,
+		Check:       checkMultipleFacts,
+	},
+	{
+		Name:        "vague-pronoun",
+		Description: "the front should name what it asks about instead of \"it\" or \"this\"",
+		Severity:    SeverityWarning,
+		Check:       checkVaguePronoun,
+	},
+	{
+		Name:        "code-fence",
+		Description: "code in the back should be in a ``` fenced block",
+		Severity:    SeverityInfo,
+		Check:       checkCodeFence,
+	},
+}
+
+// questionLeads are the words a question starts with before its subject.
+var questionLeads = map[string]bool{
+	"what": true, "which": true, "who": true, "how": true, "why": true, "when": true, "where": true,
+	"does": true, "do": true, "did": true, "is": true, "are": true, "was": true, "were": true,
+	"can": true, "could": true, "should": true, "would": true, "will": true, "s": true,
+}
+
+// pronouns refer to something the card does not name.
+var pronouns = map[string]bool{
+	"it": true, "this": true, "that": true, "they": true, "these": true, "those": true,
+	"he": true, "she": true, "them": true,
+}
+
+// prompts are the verbs a front may start with instead of asking a question.
+var prompts = []string{"explain", "describe", "define", "name", "list", "compare", "contrast", "give",
+	"write", "state", "identify", "complete", "fill", "translate", "show", "summarize"}
+
+var (
+	codeFence      = regexp.MustCompile("(?s)```.*?(```|$)")
+	listItem       = regexp.MustCompile(`^\s*([-*+•]|\d+[.)])\s+`)
+	joinedQuestion = regexp.MustCompile(`(?i)\b(and|also)\s+(what|which|who|how|why|when|where)\b`)
+	codeLine       = regexp.MustCompile(`(^\s*(func|def|class|import|package|return|for|if|var|let|const|fn)\b)|(:=|=>|->|[{;]\s*$|^\s*}\s*$)`)
+)
+
+// words lowercases text and returns its words without punctuation.
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// contentWords returns the words of text that are not stopwords.
+func contentWords(text string) []string {
+	var content []string
+	for _, word := range words(text) {
+		if !stopwords.Is(word) {
+			content = append(content, word)
+		}
+	}
+	return content
+}
+
+// withoutCode removes the fenced code blocks of text.
+func withoutCode(text string) string {
+	return codeFence.ReplaceAllString(text, " ")
+}
+
+func checkEmptyField(card transform.Flashcards, config Config) []string {
+	var messages []string
+	if strings.TrimSpace(card.Front) == "" {
+		messages = append(messages, "the front is empty")
+	}
+	if strings.TrimSpace(card.Back) == "" {
+		messages = append(messages, "the back is empty")
+	}
+	return messages
+}
+
+func checkAnswerLeakage(card transform.Flashcards, config Config) []string {
+	back := contentWords(withoutCode(card.Back))
+	front := contentWords(card.Front)
+	if len(back) == 0 || len(front) == 0 {
+		return nil
+	}
+
+	// the whole back, said in the front
+	if len(back) >= 2 && strings.Contains(" "+strings.Join(front, " ")+" ", " "+strings.Join(back, " ")+" ") {
+		return []string{"the front contains the whole answer"}
+	}
+
+	// a short back whose every word is already in the front
+	if len(back) > 6 {
+		return nil
+	}
+	inFront := map[string]bool{}
+	for _, word := range front {
+		inFront[word] = true
+	}
+	for _, word := range back {
+		if !inFront[word] {
+			return nil
+		}
+	}
+	return []string{fmt.Sprintf("the answer %q is already in the front", strings.TrimSpace(card.Back))}
+}
+
+func checkNotAQuestion(card transform.Flashcards, config Config) []string {
+	front := strings.TrimSpace(card.Front)
+	if front == "" || strings.Contains(front, "?") || strings.Contains(front, "{{c") {
+		return nil
+	}
+	frontWords := words(front)
+	if len(frontWords) > 0 {
+		for _, prompt := range prompts {
+			if frontWords[0] == prompt {
+				return nil
+			}
+		}
+		if questionLeads[frontWords[0]] {
+			return []string{"the front is a question without a question mark"}
+		}
+	}
+	return []string{"the front is not a question"}
+}
+
+func checkBackLength(card transform.Flashcards, config Config) []string {
+	count := len(strings.Fields(withoutCode(card.Back)))
+	if config.MaxBackWords > 0 && count > config.MaxBackWords {
+		return []string{fmt.Sprintf("the back has %d words, more than %d", count, config.MaxBackWords)}
+	}
+	return nil
+}
+
+func checkMultipleFacts(card transform.Flashcards, config Config) []string {
+	var messages []string
+	if questions := strings.Count(card.Front, "?"); questions > 1 {
+		messages = append(messages, fmt.Sprintf("the front asks %d questions", questions))
+	} else if joinedQuestion.MatchString(card.Front) {
+		messages = append(messages, "the front joins two questions")
+	}
+
+	items := 0
+	for _, line := range strings.Split(withoutCode(card.Back), "\n") {
+		if listItem.MatchString(line) {
+			items++
+		}
+	}
+	if config.MaxListItems > 0 && items > config.MaxListItems {
+		messages = append(messages, fmt.Sprintf("the back lists %d items, more than %d", items, config.MaxListItems))
+	}
+	return messages
+}
+
+func checkVaguePronoun(card transform.Flashcards, config Config) []string {
+	for _, word := range words(card.Front) {
+		if questionLeads[word] {
+			continue
+		}
+		if pronouns[word] {
+			return []string{fmt.Sprintf("the front asks about %q without saying what it is", word)}
+		}
+		return nil
+	}
+	return nil
+}
+
+func checkCodeFence(card transform.Flashcards, config Config) []string {
+	if strings.Contains(card.Back, "```") {
+		return nil
+	}
+	lines := 0
+	for _, line := range strings.Split(card.Back, "\n") {
+		if codeLine.MatchString(line) {
+			lines++
+		}
+	}
+	if lines >= 2 {
+		return []string{fmt.Sprintf("the back has %d lines of code outside a ``` block", lines)}
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+// Package review walks through the cards of a deck in the terminal, one at a time, so they
+// can be accepted, rejected, edited, retagged or regenerated before they are sent to Anki.
+package review
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jaxxk/anki-cards-generator/internal/transform"
+	"github.com/jaxxk/anki-cards-generator/pkg/utils"
+)
+
+// ErrAborted is returned when the review is quit before every card was decided on.
+var ErrAborted = errors.New("review aborted")
+
+// EditSeparator separates the front from the back of a card opened in the editor.
+var EditSeparator = "---"
+
+// Editor lets the user edit text and returns the result.
+type Editor func(ctx context.Context, text string) (string, error)
+
+// Regenerator returns a new version of a card.
+type Regenerator func(ctx context.Context, card transform.Flashcards) (transform.Flashcards, error)
+
+// Summary counts the decisions of a review.
+type Summary struct {
+	Accepted    int
+	Rejected    int
+	Edited      int
+	Retagged    int
+	Regenerated int
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("%d accepted, %d rejected (%d edited, %d retagged, %d regenerated)",
+		s.Accepted, s.Rejected, s.Edited, s.Retagged, s.Regenerated)
+}
+
+// Reviewer asks for a decision on every card, reading answers from In and writing to Out.
+type Reviewer struct {
+	In         io.Reader
+	Out        io.Writer
+	Edit       Editor
+	Regenerate Regenerator
+
+	lines *bufio.Reader
+	// pending receives the line being read, nil when no read is under way
+	pending chan readResult
+}
+
+// readResult is one line read from In.
+type readResult struct {
+	line string
+	err  error
+}
+
+const help = `  a  accept the card
+  r  reject the card
+  e  edit the card in $EDITOR
+  t  replace the card's tags
+  g  regenerate the card
+  A  accept this and every remaining card
+  q  quit without changing the deck
+`
+
+// Review returns the deck with the rejected cards removed and the edited, retagged and
+// regenerated cards replaced. It returns ErrAborted when the user quits.
+func (r *Reviewer) Review(ctx context.Context, deck transform.Deck) (transform.Deck, Summary, error) {
+	if r.lines == nil {
+		r.lines = bufio.NewReader(r.In)
+	}
+	reviewed := transform.Deck{Title: deck.Title, Cards: []transform.Flashcards{}}
+	summary := Summary{}
+	acceptAll := false
+
+	for i := 0; i < len(deck.Cards); i++ {
+		card := deck.Cards[i]
+		if acceptAll {
+			reviewed.Cards = append(reviewed.Cards, card)
+			summary.Accepted++
+			continue
+		}
+		fmt.Fprintf(r.Out, "\n[%d/%d] %s\n", i+1, len(deck.Cards), deck.Title)
+		printCard(r.Out, card)
+
+	decide:
+		for {
+			answer, err := r.ask(ctx, "[a]ccept, [r]eject, [e]dit, [t]ags, re[g]enerate, [A]ccept all, [q]uit? ")
+			if err != nil {
+				return transform.Deck{}, summary, err
+			}
+			switch answer {
+			case "a", "":
+				reviewed.Cards = append(reviewed.Cards, card)
+				summary.Accepted++
+				break decide
+			case "A":
+				reviewed.Cards = append(reviewed.Cards, card)
+				summary.Accepted++
+				acceptAll = true
+				break decide
+			case "r":
+				summary.Rejected++
+				break decide
+			case "e":
+				edited, err := r.editCard(ctx, card)
+				if err != nil {
+					fmt.Fprintf(r.Out, "Edit failed: %v\n", err)
+					continue
+				}
+				if edited.Front != card.Front || edited.Back != card.Back {
+					card = edited
+					summary.Edited++
+				}
+				printCard(r.Out, card)
+			case "t":
+				answer, err := r.ask(ctx, "Tags, separated by spaces (empty to clear): ")
+				if err != nil {
+					return transform.Deck{}, summary, err
+				}
+				card.Tags = strings.Fields(answer)
+				summary.Retagged++
+				printCard(r.Out, card)
+			case "g":
+				if r.Regenerate == nil {
+					fmt.Fprintln(r.Out, "Regenerating is not available")
+					continue
+				}
+				fmt.Fprintln(r.Out, "Regenerating...")
+				regenerated, err := r.Regenerate(ctx, card)
+				if err != nil {
+					fmt.Fprintf(r.Out, "Regenerate failed: %v\n", err)
+					continue
+				}
+				card = regenerated
+				summary.Regenerated++
+				printCard(r.Out, card)
+			case "q":
+				return transform.Deck{}, summary, ErrAborted
+			default:
+				fmt.Fprint(r.Out, help)
+			}
+		}
+	}
+	return reviewed, summary, nil
+}
+
+// ask prints prompt and reads one line, giving up when ctx is done. The read is not
+// interruptible, so it runs on its own while waiting for ctx. A read given up on is not
+// started again: the next ask waits for the same line, so at most one read is under way and
+// no answer is lost. When In can take a deadline, giving up also ends the read.
+func (r *Reviewer) ask(ctx context.Context, prompt string) (string, error) {
+	fmt.Fprint(r.Out, prompt)
+	if r.pending == nil {
+		r.pending = make(chan readResult, 1)
+		go func(results chan<- readResult) {
+			line, err := r.lines.ReadString('\n')
+			results <- readResult{line, err}
+		}(r.pending)
+	}
+	select {
+	case <-ctx.Done():
+		fmt.Fprintln(r.Out)
+		if in, ok := r.In.(interface{ SetReadDeadline(time.Time) error }); ok {
+			if err := in.SetReadDeadline(time.Now()); err == nil {
+				// the read ends with a timeout, which is not an answer
+				<-r.pending
+				r.pending = nil
+				_ = in.SetReadDeadline(time.Time{})
+			}
+		}
+		return "", ctx.Err()
+	case res := <-r.pending:
+		r.pending = nil
+		if res.err != nil {
+			if !errors.Is(res.err, io.EOF) {
+				return "", fmt.Errorf("failed to read answer: %w", res.err)
+			}
+			if res.line == "" {
+				return "", fmt.Errorf("%w: no more input", ErrAborted)
+			}
+		}
+		return strings.TrimSpace(res.line), nil
+	}
+}
+
+// editCard opens card in the editor as its front, a separator line and its back.
+func (r *Reviewer) editCard(ctx context.Context, card transform.Flashcards) (transform.Flashcards, error) {
+	if r.Edit == nil {
+		return card, errors.New("no editor")
+	}
+	text, err := r.Edit(ctx, card.Front+"\n"+EditSeparator+"\n"+card.Back+"\n")
+	if err != nil {
+		return card, err
+	}
+	front, back, found := strings.Cut(text, "\n"+EditSeparator+"\n")
+	if !found {
+		return card, fmt.Errorf("the %q line between front and back is missing", EditSeparator)
+	}
+	card.Front, card.Back = strings.TrimSpace(front), strings.TrimSpace(back)
+	if card.Front == "" || card.Back == "" {
+		return card, errors.New("front and back must not be empty")
+	}
+	return card, nil
+}
+
+// printCard shows a card.
+func printCard(out io.Writer, card transform.Flashcards) {
+	fmt.Fprintf(out, "Front: %s\nBack:  %s\n", card.Front, card.Back)
+	if len(card.Tags) > 0 {
+		fmt.Fprintf(out, "Tags:  %s\n", strings.Join(card.Tags, " "))
+	}
+}
+
+// EDIT_DIR holds the files opened in the editor, inside the processing directory.
+var EDIT_DIR = "edit"
+
+// ExternalEditor edits text in $VISUAL or $EDITOR, falling back to vi, attached to the terminal.
+// The editor can only open plain text, so the file is made readable by the user alone, in the
+// processing directory rather than the shared temporary directory, and removed afterwards.
+func ExternalEditor(ctx context.Context, text string) (string, error) {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	processingPath, err := utils.CreateProcessingDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to create processing directory: %w", err)
+	}
+	dir := filepath.Join(processingPath, EDIT_DIR)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create edit directory: %w", err)
+	}
+	file, err := os.CreateTemp(dir, "card-*.md")
+	if err != nil {
+		return "", fmt.Errorf("failed to create file to edit: %w", err)
+	}
+	defer os.Remove(file.Name())
+	if err := file.Chmod(0600); err != nil {
+		file.Close()
+		return "", fmt.Errorf("failed to protect file to edit: %w", err)
+	}
+	if _, err := file.WriteString(text); err != nil {
+		file.Close()
+		return "", fmt.Errorf("failed to write file to edit: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("failed to write file to edit: %w", err)
+	}
+
+	// $EDITOR may carry arguments, such as "code --wait"
+	args := append(strings.Fields(editor), file.Name())
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to run editor %s: %w", editor, err)
+	}
+	edited, err := os.ReadFile(file.Name())
+	if err != nil {
+		return "", fmt.Errorf("failed to read edited file: %w", err)
+	}
+	return string(edited), nil
+}
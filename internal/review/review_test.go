@@ -0,0 +1,143 @@
+package review
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jaxxk/anki-cards-generator/internal/transform"
+	"github.com/jaxxk/anki-cards-generator/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func testDeck() transform.Deck {
+	return transform.Deck{Title: "Go", Cards: []transform.Flashcards{
+		{Front: "What is a goroutine?", Back: "A function running concurrently."},
+		{Front: "What is a channel?", Back: "A typed conduit."},
+		{Front: "What is a map?", Back: "A hash table."},
+		{Front: "What is a slice?", Back: "A view of an array."},
+	}}
+}
+
+func TestReview(t *testing.T) {
+	out := new(bytes.Buffer)
+	reviewer := &Reviewer{
+		// reject, edit then retag then accept, regenerate then accept, unknown then accept
+		In:  strings.NewReader("r\ne\nt\ngo basics\na\ng\na\n?\na\n"),
+		Out: out,
+		Edit: func(ctx context.Context, text string) (string, error) {
+			assert.Equal(t, "What is a channel?\n---\nA typed conduit.\n", text)
+			return "What is a Go channel?\n---\nA typed conduit between goroutines.\n", nil
+		},
+		Regenerate: func(ctx context.Context, card transform.Flashcards) (transform.Flashcards, error) {
+			return transform.Flashcards{Front: "How are maps implemented?", Back: "As hash tables."}, nil
+		},
+	}
+
+	deck, summary, err := reviewer.Review(context.Background(), testDeck())
+	assert.NoError(t, err)
+	assert.Equal(t, transform.Deck{Title: "Go", Cards: []transform.Flashcards{
+		{Front: "What is a Go channel?", Back: "A typed conduit between goroutines.", Tags: []string{"go", "basics"}},
+		{Front: "How are maps implemented?", Back: "As hash tables."},
+		{Front: "What is a slice?", Back: "A view of an array."},
+	}}, deck)
+	assert.Equal(t, Summary{Accepted: 3, Rejected: 1, Edited: 1, Retagged: 1, Regenerated: 1}, summary)
+	assert.Contains(t, out.String(), "[1/4] Go")
+	assert.Contains(t, out.String(), "Tags:  go basics")
+	assert.Contains(t, out.String(), "regenerate the card", "unknown answers show the help")
+}
+
+func TestReviewAcceptAll(t *testing.T) {
+	reviewer := &Reviewer{In: strings.NewReader("r\nA\n"), Out: new(bytes.Buffer)}
+	deck, summary, err := reviewer.Review(context.Background(), testDeck())
+	assert.NoError(t, err)
+	assert.Len(t, deck.Cards, 3)
+	assert.Equal(t, Summary{Accepted: 3, Rejected: 1}, summary)
+}
+
+func TestReviewFailedEditKeepsCard(t *testing.T) {
+	reviewer := &Reviewer{
+		In:  strings.NewReader("e\ng\nA\n"),
+		Out: new(bytes.Buffer),
+		Edit: func(ctx context.Context, text string) (string, error) {
+			return "the separator was deleted", nil
+		},
+		Regenerate: func(ctx context.Context, card transform.Flashcards) (transform.Flashcards, error) {
+			return transform.Flashcards{}, errors.New("model is down")
+		},
+	}
+	deck, summary, err := reviewer.Review(context.Background(), testDeck())
+	assert.NoError(t, err)
+	assert.Equal(t, testDeck(), deck)
+	assert.Equal(t, Summary{Accepted: 4}, summary)
+}
+
+func TestReviewQuit(t *testing.T) {
+	for name, input := range map[string]string{"quit": "a\nq\n", "end of input": "a\n"} {
+		t.Run(name, func(t *testing.T) {
+			reviewer := &Reviewer{In: strings.NewReader(input), Out: new(bytes.Buffer)}
+			_, _, err := reviewer.Review(context.Background(), testDeck())
+			assert.ErrorIs(t, err, ErrAborted)
+		})
+	}
+}
+
+func TestReviewCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// a reader that never returns, like a terminal nobody types in
+	blocked, _ := io.Pipe()
+	reviewer := &Reviewer{In: blocked, Out: new(bytes.Buffer)}
+	_, _, err := reviewer.Review(ctx, testDeck())
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestReviewCanceledKeepsPendingRead(t *testing.T) {
+	in, typed := io.Pipe()
+	reviewer := &Reviewer{In: in, Out: new(bytes.Buffer)}
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, _, err := reviewer.Review(ctx, testDeck())
+		assert.ErrorIs(t, err, context.Canceled)
+	}
+
+	// the answer typed after the cancellations goes to the next review, read once
+	go typed.Write([]byte("A\n"))
+	reviewed, summary, err := reviewer.Review(context.Background(), testDeck())
+	assert.NoError(t, err)
+	assert.Len(t, reviewed.Cards, 4)
+	assert.Equal(t, 4, summary.Accepted)
+}
+
+func TestExternalEditor(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	// the editor notes where the file is and who may read it, then edits it
+	seen := filepath.Join(t.TempDir(), "seen")
+	script := filepath.Join(t.TempDir(), "editor.sh")
+	assert.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\nstat -c '%a %n' \"$1\" > "+seen+"\necho edited >> \"$1\"\n"), 0700))
+	t.Setenv("VISUAL", script)
+
+	edited, err := ExternalEditor(context.Background(), "front\n")
+	assert.NoError(t, err)
+	assert.Equal(t, "front\nedited\n", edited)
+
+	mode, path, _ := strings.Cut(strings.TrimSpace(readFile(t, seen)), " ")
+	assert.Equal(t, "600", mode)
+	assert.True(t, strings.HasPrefix(path, filepath.Join(home, utils.PROCESSING_DIR, EDIT_DIR)))
+	_, err = os.Stat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist, "the file is removed after editing")
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	return string(content)
+}
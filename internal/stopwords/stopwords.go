@@ -0,0 +1,17 @@
+// Package stopwords lists the English words that carry no information about what a flashcard
+// asks or answers, so the cards can be compared by the words that do.
+package stopwords
+
+// words are lowercase and without punctuation, so "what's" is listed as "whats" and "s".
+var words = map[string]bool{
+	"a": true, "an": true, "the": true, "is": true, "are": true, "was": true, "were": true, "be": true,
+	"what": true, "whats": true, "which": true, "who": true, "how": true, "why": true, "when": true, "where": true,
+	"do": true, "does": true, "did": true, "can": true, "of": true, "in": true, "on": true, "to": true,
+	"for": true, "with": true, "and": true, "or": true, "it": true, "its": true, "this": true, "that": true,
+	"by": true, "as": true, "at": true, "from": true, "s": true, "you": true, "your": true, "there": true,
+}
+
+// Is reports whether word, lowercased and without punctuation, is a stopword.
+func Is(word string) bool {
+	return words[word]
+}
@@ -0,0 +1,16 @@
+package stopwords
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIs(t *testing.T) {
+	for _, word := range []string{"the", "what", "whats", "s", "there"} {
+		assert.True(t, Is(word), word)
+	}
+	for _, word := range []string{"goroutine", "channel", "The", "what's"} {
+		assert.False(t, Is(word), word)
+	}
+}
@@ -0,0 +1,197 @@
+package transform
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BloomLevel is the cognitive level a card tests, after Bloom's taxonomy.
+type BloomLevel string
+
+const (
+	LevelRecall     BloomLevel = "recall"
+	LevelUnderstand BloomLevel = "understand"
+	LevelApply      BloomLevel = "apply"
+	LevelAnalyze    BloomLevel = "analyze"
+)
+
+// BloomLevels lists the levels from the lowest to the highest.
+var BloomLevels = []BloomLevel{LevelRecall, LevelUnderstand, LevelApply, LevelAnalyze}
+
+// ParseBloomLevel parses recall, understand, apply or analyze.
+func ParseBloomLevel(s string) (BloomLevel, error) {
+	for _, level := range BloomLevels {
+		if strings.EqualFold(s, string(level)) {
+			return level, nil
+		}
+	}
+	return "", fmt.Errorf("unknown level %q, expected recall, understand, apply or analyze", s)
+}
+
+// MixTolerance is how many percentage points the share of a level may be off its target.
+var MixTolerance = 15.0
+
+// Mix is the share, in percent, of the cards wanted at each level.
+type Mix map[BloomLevel]float64
+
+// ParseMix parses a mix like "recall=40,apply=40,analyze=20". The shares must add up to 100.
+func ParseMix(s string) (Mix, error) {
+	mix := Mix{}
+	total := 0.0
+	for _, part := range strings.Split(s, ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			return nil, fmt.Errorf("invalid mix %q, expected level=percent pairs like recall=40,apply=60", s)
+		}
+		level, err := ParseBloomLevel(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := mix[level]; ok {
+			return nil, fmt.Errorf("level %s appears twice in the mix", level)
+		}
+		share, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(value), "%"), 64)
+		if err != nil || share < 0 {
+			return nil, fmt.Errorf("invalid share %q for %s", value, level)
+		}
+		mix[level] = share
+		total += share
+	}
+	if math.Abs(total-100) > 0.5 {
+		return nil, fmt.Errorf("mix shares add up to %g, not 100", total)
+	}
+	return mix, nil
+}
+
+// quotas shares n places out between the levels of the mix by their share, giving the places
+// left by rounding down to the largest remainders. An empty mix has no quotas.
+func (m Mix) quotas(n int) map[BloomLevel]int {
+	quotas := map[BloomLevel]int{}
+	remainders := map[BloomLevel]float64{}
+	given := 0
+	for _, level := range BloomLevels {
+		exact := float64(n) * m[level] / 100
+		quotas[level] = int(exact)
+		remainders[level] = exact - float64(quotas[level])
+		given += quotas[level]
+	}
+	levels := append([]BloomLevel{}, BloomLevels...)
+	sort.SliceStable(levels, func(i, j int) bool { return remainders[levels[i]] > remainders[levels[j]] })
+	for _, level := range levels {
+		if given >= n || m[level] == 0 {
+			break
+		}
+		quotas[level]++
+		given++
+	}
+	return quotas
+}
+
+// String formats the mix like ParseMix reads it, in level order.
+func (m Mix) String() string {
+	var parts []string
+	for _, level := range BloomLevels {
+		if share, ok := m[level]; ok {
+			parts = append(parts, fmt.Sprintf("%s=%g", level, share))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// instruction tells the model the mix of levels to write, empty when any mix will do.
+func (m Mix) instruction() string {
+	var parts []string
+	for _, level := range BloomLevels {
+		if share, ok := m[level]; ok && share > 0 {
+			parts = append(parts, fmt.Sprintf("%g%% %s", share, level))
+		}
+	}
+	switch len(parts) {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf("Make all of the flashcards %s questions.", strings.TrimPrefix(parts[0], "100% "))
+	}
+	return fmt.Sprintf("Make about %s and %s questions.", strings.Join(parts[:len(parts)-1], ", "), parts[len(parts)-1])
+}
+
+// LevelShare compares the cards at a level with the mix.
+type LevelShare struct {
+	Level  BloomLevel
+	Cards  int
+	Actual float64
+	Target float64
+}
+
+// Off reports whether the share is further from its target than MixTolerance.
+func (s LevelShare) Off() bool {
+	return math.Abs(s.Actual-s.Target) > MixTolerance
+}
+
+// MixReport is the mix of levels of a deck.
+type MixReport struct {
+	Cards int
+	// Levels holds the levels of the mix and those of the cards, in level order
+	Levels []LevelShare
+	// Unlabeled counts the cards without a level
+	Unlabeled int
+	// Unrecognized lists the levels the model answered with that are not Bloom levels, and
+	// were dropped from their cards
+	Unrecognized []string
+}
+
+// CheckMix compares the levels of cards with mix.
+func CheckMix(cards []Flashcards, mix Mix) MixReport {
+	report := MixReport{}
+	counts := map[BloomLevel]int{}
+	for _, card := range cards {
+		if card.Level == "" {
+			report.Unlabeled++
+			continue
+		}
+		counts[card.Level]++
+		report.Cards++
+	}
+	for _, level := range BloomLevels {
+		target, inMix := mix[level]
+		if !inMix && counts[level] == 0 {
+			continue
+		}
+		share := LevelShare{Level: level, Cards: counts[level], Target: target}
+		if report.Cards > 0 {
+			share.Actual = float64(counts[level]) * 100 / float64(report.Cards)
+		}
+		report.Levels = append(report.Levels, share)
+	}
+	return report
+}
+
+// Off returns the levels further from their target than MixTolerance.
+func (r MixReport) Off() []LevelShare {
+	var off []LevelShare
+	for _, share := range r.Levels {
+		if share.Off() {
+			off = append(off, share)
+		}
+	}
+	return off
+}
+
+// Summary describes the report in one line.
+func (r MixReport) Summary() string {
+	parts := make([]string, 0, len(r.Levels))
+	for _, share := range r.Levels {
+		parts = append(parts, fmt.Sprintf("%s %.0f%% (target %g%%)", share.Level, share.Actual, share.Target))
+	}
+	summary := strings.Join(parts, ", ")
+	if r.Unlabeled > 0 {
+		summary += fmt.Sprintf(", %d card(s) without a level", r.Unlabeled)
+	}
+	if len(r.Unrecognized) > 0 {
+		summary += fmt.Sprintf(", unrecognized level(s) %s", strings.Join(r.Unrecognized, ", "))
+	}
+	return strings.TrimPrefix(summary, ", ")
+}
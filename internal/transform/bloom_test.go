@@ -0,0 +1,61 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMix(t *testing.T) {
+	mix, err := ParseMix("recall=40, Apply=40%,analyze=20")
+	assert.NoError(t, err)
+	assert.Equal(t, Mix{LevelRecall: 40, LevelApply: 40, LevelAnalyze: 20}, mix)
+	assert.Equal(t, "recall=40,apply=40,analyze=20", mix.String())
+
+	for raw, message := range map[string]string{
+		"recall":                "expected level=percent pairs",
+		"remember=100":          `unknown level "remember"`,
+		"recall=50,recall=50":   "appears twice",
+		"recall=-10,apply=110":  `invalid share "-10"`,
+		"recall=40,apply=40":    "add up to 80, not 100",
+		"recall=forty,apply=60": `invalid share "forty"`,
+	} {
+		_, err := ParseMix(raw)
+		assert.ErrorContains(t, err, message, raw)
+	}
+}
+
+func TestMixInstruction(t *testing.T) {
+	assert.Equal(t, "", Mix(nil).instruction())
+	assert.Equal(t, "Make all of the flashcards apply questions.", Mix{LevelApply: 100}.instruction())
+	assert.Equal(t, "Make about 40% recall, 40% apply and 20% analyze questions.",
+		Mix{LevelAnalyze: 20, LevelRecall: 40, LevelApply: 40, LevelUnderstand: 0}.instruction())
+}
+
+func TestCheckMix(t *testing.T) {
+	cards := []Flashcards{
+		{Level: LevelRecall}, {Level: LevelRecall}, {Level: LevelRecall},
+		{Level: LevelApply}, {Level: LevelUnderstand}, {},
+	}
+	report := CheckMix(cards, Mix{LevelRecall: 40, LevelApply: 40, LevelAnalyze: 20})
+	assert.Equal(t, 5, report.Cards)
+	assert.Equal(t, 1, report.Unlabeled)
+	assert.Equal(t, []LevelShare{
+		{Level: LevelRecall, Cards: 3, Actual: 60, Target: 40},
+		{Level: LevelUnderstand, Cards: 1, Actual: 20, Target: 0},
+		{Level: LevelApply, Cards: 1, Actual: 20, Target: 40},
+		{Level: LevelAnalyze, Cards: 0, Actual: 0, Target: 20},
+	}, report.Levels)
+	assert.Equal(t, report.Levels, report.Off(), "every level is 20 points off")
+	assert.Equal(t, "recall 60% (target 40%), understand 20% (target 0%), apply 20% (target 40%), analyze 0% (target 20%), 1 card(s) without a level", report.Summary())
+}
+
+func TestTargetingCheckMixListsUnrecognized(t *testing.T) {
+	targeting := &Targeting{Mix: Mix{LevelRecall: 100}}
+	targeting.unrecognized([]string{"evaluate", "create"})
+	targeting.unrecognized([]string{"evaluate"})
+	report := targeting.CheckMix([]Flashcards{{Level: LevelRecall}, {}})
+	assert.Equal(t, []string{"create", "evaluate"}, report.Unrecognized)
+	assert.Equal(t, "recall 100% (target 100%), 1 card(s) without a level, unrecognized level(s) create, evaluate", report.Summary())
+	assert.Equal(t, "unrecognized level(s) create", MixReport{Unrecognized: []string{"create"}}.Summary())
+}
@@ -0,0 +1,274 @@
+// Package cassette records HTTP exchanges with the LLM provider to files and replays them,
+// so code that calls the model can be tested deterministically and offline.
+package cassette
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// PATH_ENV names the environment variable holding a cassette file to record LLM calls to or
+// replay them from, and MODE_ENV selects "record" or "replay" (the default).
+var PATH_ENV = "POGGERS_CASSETTE"
+var MODE_ENV = "POGGERS_CASSETTE_MODE"
+
+// Mode selects whether a Transport records or replays.
+type Mode string
+
+const (
+	// ModeReplay serves responses from the cassette and fails on unknown requests.
+	ModeReplay Mode = "replay"
+	// ModeRecord forwards requests to the provider and appends every exchange to the cassette.
+	ModeRecord Mode = "record"
+)
+
+// ParseMode parses a mode name, defaulting to ModeReplay.
+func ParseMode(name string) (Mode, error) {
+	switch Mode(name) {
+	case "", ModeReplay:
+		return ModeReplay, nil
+	case ModeRecord:
+		return ModeRecord, nil
+	default:
+		return "", fmt.Errorf("unknown cassette mode %q, expected %q or %q", name, ModeReplay, ModeRecord)
+	}
+}
+
+// Interaction is one recorded request/response exchange.
+type Interaction struct {
+	Key      string          `json:"key"`
+	Method   string          `json:"method"`
+	Path     string          `json:"path"`
+	Request  json.RawMessage `json:"request"`
+	Response Response        `json:"response"`
+}
+
+// Response is a recorded HTTP response.
+type Response struct {
+	Status      int    `json:"status"`
+	ContentType string `json:"contentType"`
+	// Body holds JSON responses, Text anything else
+	Body json.RawMessage `json:"body,omitempty"`
+	Text string          `json:"text,omitempty"`
+}
+
+// Cassette is the on-disk list of interactions.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Transport is an http.RoundTripper that records to or replays from a cassette file.
+type Transport struct {
+	path string
+	mode Mode
+	next http.RoundTripper
+
+	mu       sync.Mutex
+	cassette Cassette
+	// played counts how often each key was replayed, so identical requests get successive responses
+	played map[string]int
+}
+
+// NewTransport loads the cassette at path. In ModeRecord a missing cassette is created and
+// requests are sent through next, which defaults to http.DefaultTransport.
+func NewTransport(path string, mode Mode, next http.RoundTripper) (*Transport, error) {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	t := &Transport{path: path, mode: mode, next: next, played: map[string]int{}}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, &t.cassette); err != nil {
+			return nil, fmt.Errorf("failed to parse cassette %s: %w", path, err)
+		}
+	case os.IsNotExist(err) && mode == ModeRecord:
+	default:
+		return nil, fmt.Errorf("failed to read cassette %s: %w", path, err)
+	}
+	return t, nil
+}
+
+// shared holds the transport of every cassette path opened with Open.
+var (
+	sharedMu sync.Mutex
+	shared   = map[string]*Transport{}
+)
+
+// Open returns the transport of the cassette at path, loading it on first use. Every client
+// of the same cassette shares its transport, so identical requests replay the successive
+// responses recorded for them. Close forgets it.
+func Open(path string, mode Mode) (*Transport, error) {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if t, ok := shared[path]; ok && t.mode == mode {
+		return t, nil
+	}
+	t, err := NewTransport(path, mode, nil)
+	if err != nil {
+		return nil, err
+	}
+	shared[path] = t
+	return t, nil
+}
+
+// Close forgets the transport of the cassette at path, so the next Open loads it again and
+// replays it from the start.
+func Close(path string) {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	delete(shared, path)
+}
+
+// NormalizeBody returns a canonical form of a JSON request body, so that key order and
+// whitespace do not affect matching. Non-JSON bodies are returned unchanged.
+func NormalizeBody(body []byte) []byte {
+	var decoded interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return bytes.TrimSpace(body)
+	}
+	normalized, err := json.Marshal(decoded)
+	if err != nil {
+		return bytes.TrimSpace(body)
+	}
+	return normalized
+}
+
+// Key identifies a request by method, path and normalized body.
+func Key(method, path string, body []byte) string {
+	sum := sha256.New()
+	fmt.Fprintf(sum, "%s %s\n", method, path)
+	sum.Write(NormalizeBody(body))
+	return hex.EncodeToString(sum.Sum(nil))
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	key := Key(req.Method, req.URL.Path, body)
+
+	if t.mode == ModeRecord {
+		return t.record(req, key, body)
+	}
+	return t.replay(req, key)
+}
+
+func (t *Transport) replay(req *http.Request, key string) (*http.Response, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var matches []Interaction
+	for _, interaction := range t.cassette.Interactions {
+		if interaction.Key == key {
+			matches = append(matches, interaction)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("cassette %s has no recorded interaction for %s %s (key %s)", t.path, req.Method, req.URL.Path, key)
+	}
+	// Replay identical requests in recorded order, repeating the last one
+	index := min(t.played[key], len(matches)-1)
+	t.played[key]++
+	return matches[index].Response.toHTTP(req), nil
+}
+
+func (t *Transport) record(req *http.Request, key string, body []byte) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+
+	interaction := Interaction{
+		Key:     key,
+		Method:  req.Method,
+		Path:    req.URL.Path,
+		Request: requestJSON(NormalizeBody(body)),
+		Response: Response{
+			Status:      resp.StatusCode,
+			ContentType: resp.Header.Get("Content-Type"),
+		},
+	}
+	if json.Valid(respBody) {
+		interaction.Response.Body = json.RawMessage(respBody)
+	} else {
+		interaction.Response.Text = string(respBody)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cassette.Interactions = append(t.cassette.Interactions, interaction)
+	if err := t.save(); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// save writes the cassette atomically.
+func (t *Transport) save() error {
+	data, err := json.MarshalIndent(t.cassette, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize cassette: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(t.path), 0755); err != nil {
+		return fmt.Errorf("failed to create cassette directory: %w", err)
+	}
+	tmpPath := t.path + ".tmp"
+	if err := os.WriteFile(tmpPath, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return os.Rename(tmpPath, t.path)
+}
+
+func (r Response) toHTTP(req *http.Request) *http.Response {
+	body := []byte(r.Body)
+	if len(body) == 0 {
+		body = []byte(r.Text)
+	}
+	header := http.Header{}
+	if r.ContentType != "" {
+		header.Set("Content-Type", r.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", r.Status, http.StatusText(r.Status)),
+		StatusCode:    r.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+}
+
+// requestJSON keeps a JSON request body as is and stores anything else as a JSON string.
+func requestJSON(data []byte) json.RawMessage {
+	if json.Valid(data) {
+		return json.RawMessage(data)
+	}
+	quoted, _ := json.Marshal(string(data))
+	return quoted
+}
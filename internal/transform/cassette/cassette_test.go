@@ -0,0 +1,110 @@
+package cassette
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func post(t *testing.T, transport http.RoundTripper, url, body string) (int, string, error) {
+	t.Helper()
+	client := &http.Client{Transport: transport}
+	resp, err := client.Post(url+"/v1/chat/completions", "application/json", strings.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return resp.StatusCode, string(content), nil
+}
+
+func TestRecordThenReplay(t *testing.T) {
+	calls := 0
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"answer": ` + string(rune('0'+calls)) + `}`))
+	}))
+	defer upstream.Close()
+	path := filepath.Join(t.TempDir(), "cassette.json")
+
+	recorder, err := NewTransport(path, ModeRecord, nil)
+	assert.NoError(t, err)
+	_, body, err := post(t, recorder, upstream.URL, `{"model": "m", "messages": [1]}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"answer": 1}`, body)
+	_, body, err = post(t, recorder, upstream.URL, `{"model": "m", "messages": [1]}`)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"answer": 2}`, body)
+	upstream.Close()
+
+	player, err := NewTransport(path, ModeReplay, nil)
+	assert.NoError(t, err)
+
+	// key order and whitespace are normalized away, repeated requests replay in order
+	status, body, err := post(t, player, "http://unused.invalid", `{"messages":[1],"model":"m"}`)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, status)
+	assert.JSONEq(t, `{"answer": 1}`, body)
+	_, body, err = post(t, player, "http://unused.invalid", `{"messages":[1],"model":"m"}`)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"answer": 2}`, body)
+
+	_, _, err = post(t, player, "http://unused.invalid", `{"model": "other"}`)
+	assert.ErrorContains(t, err, "no recorded interaction")
+	assert.Equal(t, 2, calls)
+}
+
+func TestReplayMissingCassette(t *testing.T) {
+	_, err := NewTransport(filepath.Join(t.TempDir(), "missing.json"), ModeReplay, nil)
+	assert.Error(t, err)
+}
+
+func TestOpenSharesReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cassette.json")
+	recorder, err := NewTransport(path, ModeRecord, roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return Response{Status: http.StatusOK, ContentType: "application/json", Body: []byte(`{"answer": 1}`)}.toHTTP(r), nil
+	}))
+	assert.NoError(t, err)
+	_, _, err = post(t, recorder, "http://unused.invalid", `{"model": "m"}`)
+	assert.NoError(t, err)
+	recorder.next = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return Response{Status: http.StatusOK, ContentType: "application/json", Body: []byte(`{"answer": 2}`)}.toHTTP(r), nil
+	})
+	_, _, err = post(t, recorder, "http://unused.invalid", `{"model": "m"}`)
+	assert.NoError(t, err)
+	t.Cleanup(func() { Close(path) })
+
+	// every client opening the cassette advances through the same recorded answers
+	first, err := Open(path, ModeReplay)
+	assert.NoError(t, err)
+	_, body, err := post(t, first, "http://unused.invalid", `{"model": "m"}`)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"answer": 1}`, body)
+	second, err := Open(path, ModeReplay)
+	assert.NoError(t, err)
+	assert.Same(t, first, second)
+	_, body, err = post(t, second, "http://unused.invalid", `{"model": "m"}`)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"answer": 2}`, body)
+
+	// a closed cassette replays from the start
+	Close(path)
+	third, err := Open(path, ModeReplay)
+	assert.NoError(t, err)
+	_, body, err = post(t, third, "http://unused.invalid", `{"model": "m"}`)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"answer": 1}`, body)
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
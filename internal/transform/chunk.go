@@ -0,0 +1,292 @@
+package transform
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jaxxk/anki-cards-generator/pkg/tokens"
+)
+
+// Section is a part of a document delimited by Markdown headings or "---" markers. Sections
+// are the unit of change detection: editing one only changes its own hash.
+type Section struct {
+	// Heading is the section's last heading line, empty for text before the first heading
+	Heading string
+	// Text is the section's words joined by single spaces, as sent to the model
+	Text string
+	// Hash identifies Text, so reflowing a paragraph does not count as a change
+	Hash string
+	// Tokens counts the tokens of Text
+	Tokens int
+	// StartLine and EndLine are the first and last lines of the section in the file, from 1
+	StartLine int
+	EndLine   int
+}
+
+// Part is a run of consecutive sections sent to the model together, so that short sections
+// do not each pay for the prompt and the response schema. Parts are the unit of incremental
+// regeneration: a part is generated again when any of its sections changed.
+type Part struct {
+	Sections []Section
+	// Hash identifies the part by the hashes of its sections, and is the section's own hash
+	// for a part of one section
+	Hash string
+	// Text is the text of the sections joined by single spaces
+	Text string
+	// Chunks is Text split to fit in ChunkBudget
+	Chunks []string
+}
+
+// ChunkDocument splits the document at docPath into the chunks of text that are sent to the
+// model one at a time: the chunks of each of its parts, in order.
+func ChunkDocument(ctx context.Context, docPath string) ([]string, error) {
+	sections, err := SplitSections(ctx, docPath)
+	if err != nil {
+		return nil, err
+	}
+	return sectionChunks(ctx, sections), nil
+}
+
+// sectionChunks returns the chunks of the parts sections are grouped in, in order.
+func sectionChunks(ctx context.Context, sections []Section) []string {
+	var chunks []string
+	for _, part := range GroupSections(ctx, sections) {
+		chunks = append(chunks, part.Chunks...)
+	}
+	return chunks
+}
+
+// GroupSections coalesces consecutive sections into parts of at most ChunkBudget tokens for
+// DefaultModel. A part ends at the first section boundary after it holds 5/8 of the budget,
+// or before a section that would take it over the budget. A section over the budget on its
+// own is a part of its own, split into several chunks. Editing a section can move the
+// boundaries of the parts after it.
+func GroupSections(ctx context.Context, sections []Section) []Part {
+	maxTokens := ChunkBudget(string(ModelFromContext(ctx)))
+	minTokens := maxTokens * 5 / 8
+	encoding := modelEncoding(ctx)
+
+	var parts []Part
+	var group []Section
+	groupTokens := 0
+	closePart := func() {
+		if len(group) == 0 {
+			return
+		}
+		texts := make([]string, len(group))
+		hashes := make([]string, len(group))
+		for i, section := range group {
+			texts[i], hashes[i] = section.Text, section.Hash
+		}
+		part := Part{Sections: group, Hash: hashes[0], Text: strings.Join(texts, " ")}
+		if len(group) > 1 {
+			sum := sha256.Sum256([]byte(strings.Join(hashes, "\n")))
+			part.Hash = hex.EncodeToString(sum[:])
+		}
+		part.Chunks = chunkWords(encoding, strings.Fields(part.Text), maxTokens)
+		parts = append(parts, part)
+		group, groupTokens = nil, 0
+	}
+	for _, section := range sections {
+		if len(group) > 0 && groupTokens+section.Tokens > maxTokens {
+			closePart()
+		}
+		group = append(group, section)
+		groupTokens += section.Tokens
+		if groupTokens >= minTokens {
+			closePart()
+		}
+	}
+	closePart()
+	return parts
+}
+
+// SplitSections reads the document at docPath and splits it into sections. YAML frontmatter
+// is skipped, "---" lines are dropped, and headings inside fenced code blocks are ignored. A
+// heading without text of its own is kept with the section that follows it.
+func SplitSections(ctx context.Context, docPath string) ([]Section, error) {
+	content, err := readDocument(docPath)
+	if err != nil {
+		return nil, err
+	}
+	return SplitSectionsText(ctx, string(content))
+}
+
+// readDocument reads the document at docPath, refusing files over FILE_SIZE_LIMIT.
+func readDocument(docPath string) ([]byte, error) {
+	// Check file size
+	fileInfo, err := os.Stat(docPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	if fileInfo.Size() > FILE_SIZE_LIMIT {
+		return nil, fmt.Errorf("file too large to process (%d bytes), limit %d",
+			fileInfo.Size(), FILE_SIZE_LIMIT)
+	}
+
+	content, err := os.ReadFile(docPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	return content, nil
+}
+
+// SplitSectionsText splits the content of a document into sections like SplitSections.
+func SplitSectionsText(ctx context.Context, content string) ([]Section, error) {
+	_, body := splitFrontmatter(content)
+	// line numbers count from the top of the file, frontmatter included
+	lineNumber := strings.Count(content[:len(content)-len(body)], "\n")
+
+	encoding := modelEncoding(ctx)
+	var sections []Section
+	var heading string
+	var words []string
+	// words of headings that have no text yet, carried into the next section
+	headingWords := 0
+	inFence := false
+	startLine, endLine := 0, 0
+
+	closeSection := func() {
+		if len(words) == headingWords {
+			// nothing but headings so far
+			return
+		}
+		text := strings.Join(words, " ")
+		sum := sha256.Sum256([]byte(text))
+		sections = append(sections, Section{
+			Heading:   heading,
+			Text:      text,
+			Hash:      hex.EncodeToString(sum[:]),
+			Tokens:    encoding.Count(text),
+			StartLine: startLine,
+			EndLine:   endLine,
+		})
+		words, headingWords, heading = nil, 0, ""
+		startLine = 0
+	}
+
+	trackLine := func(line int) {
+		if startLine == 0 {
+			startLine = line
+		}
+		endLine = line
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), int(FILE_SIZE_LIMIT))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		lineNumber++
+
+		if strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~") {
+			inFence = !inFence
+		}
+		if !inFence {
+			if trimmed == "---" {
+				closeSection()
+				continue
+			}
+			if isHeading(trimmed) {
+				closeSection()
+				heading = trimmed
+				words = append(words, strings.Fields(line)...)
+				headingWords = len(words)
+				trackLine(lineNumber)
+				continue
+			}
+		}
+		words = append(words, strings.Fields(line)...)
+		if trimmed != "" {
+			trackLine(lineNumber)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error while reading file: %w", err)
+	}
+	// a trailing heading without text is still content
+	headingWords = -1
+	closeSection()
+	return sections, nil
+}
+
+// isHeading reports whether a trimmed line is an ATX Markdown heading such as "## Channels".
+func isHeading(line string) bool {
+	level := len(line) - len(strings.TrimLeft(line, "#"))
+	return level >= 1 && level <= 6 && (len(line) == level || line[level] == ' ')
+}
+
+// splitFrontmatter separates YAML frontmatter delimited by "---" lines at the very start of a
+// document from its body.
+func splitFrontmatter(content string) (frontmatter, body string) {
+	if !strings.HasPrefix(content, "---\n") && !strings.HasPrefix(content, "---\r\n") {
+		return "", content
+	}
+	rest := content[strings.Index(content, "\n")+1:]
+	for offset := 0; offset < len(rest); {
+		end := strings.Index(rest[offset:], "\n")
+		line := rest[offset:]
+		if end >= 0 {
+			line = rest[offset : offset+end]
+		}
+		if strings.TrimSpace(line) == "---" {
+			if end < 0 {
+				return rest[:offset], ""
+			}
+			return rest[:offset], rest[offset+end+1:]
+		}
+		if end < 0 {
+			break
+		}
+		offset += end + 1
+	}
+	// no closing marker, so it was not frontmatter
+	return "", content
+}
+
+// chunkWords joins words into chunks of at most maxTokens tokens.
+func chunkWords(encoding *tokens.Encoding, words []string, maxTokens int) []string {
+	var chunks []string
+	var chunk []string
+	chunkTokens := 0
+	for _, word := range words {
+		// words are joined by a space, which the tokenizer folds into the word's first token
+		for _, part := range splitOversized(encoding, word, maxTokens) {
+			partTokens := encoding.Count(" " + part)
+			if chunkTokens+partTokens > maxTokens && len(chunk) > 0 {
+				chunks = append(chunks, strings.Join(chunk, " "))
+				chunk, chunkTokens = nil, 0
+			}
+			chunk = append(chunk, part)
+			chunkTokens += partTokens
+		}
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, strings.Join(chunk, " "))
+	}
+	return chunks
+}
+
+// splitOversized cuts a word that does not fit in a chunk on its own, such as a run of CJK
+// text without spaces or a minified blob, into pieces of at most maxTokens tokens.
+func splitOversized(encoding *tokens.Encoding, word string, maxTokens int) []string {
+	if encoding.Count(word) <= maxTokens {
+		return []string{word}
+	}
+	var parts []string
+	start, partTokens := 0, 0
+	for i, r := range word {
+		runeTokens := encoding.Count(string(r))
+		if partTokens+runeTokens > maxTokens && i > start {
+			parts = append(parts, word[start:i])
+			start, partTokens = i, 0
+		}
+		partTokens += runeTokens
+	}
+	return append(parts, word[start:])
+}
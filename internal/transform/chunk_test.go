@@ -0,0 +1,127 @@
+package transform
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeNotes(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notes.md")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestChunkDocumentStaysWithinBudget(t *testing.T) {
+	budget := ChunkBudget(string(DefaultModel))
+	path := writeNotes(t, strings.Repeat("Channels synchronize goroutines by passing values between them. ", 400))
+
+	chunks, err := ChunkDocument(context.Background(), path)
+	assert.NoError(t, err)
+	assert.Greater(t, len(chunks), 1)
+	for _, chunk := range chunks {
+		assert.LessOrEqual(t, EstimateTokens(context.Background(), chunk), budget+1)
+	}
+}
+
+func TestSplitSections(t *testing.T) {
+	path := writeNotes(t, strings.Join([]string{
+		"---",
+		"title: Go",
+		"---",
+		"# Go Notes",
+		"## Goroutines",
+		"Goroutines are cheap.",
+		"",
+		"```python",
+		"# not a heading",
+		"---",
+		"```",
+		"---",
+		"Channels   connect",
+		"goroutines.",
+		"## Empty",
+	}, "\n"))
+
+	sections, err := SplitSections(context.Background(), path)
+	assert.NoError(t, err)
+	assert.Len(t, sections, 3)
+
+	assert.Equal(t, "## Goroutines", sections[0].Heading, "a heading without text joins the next section")
+	assert.Equal(t, "# Go Notes ## Goroutines Goroutines are cheap. ```python # not a heading --- ```", sections[0].Text)
+	assert.Equal(t, "Channels connect goroutines.", sections[1].Text)
+	assert.Equal(t, "", sections[1].Heading)
+	assert.Equal(t, "## Empty", sections[2].Text)
+	assert.NotContains(t, sections[0].Text, "title: Go", "frontmatter is not content")
+	assert.Equal(t, [][2]int{{4, 11}, {13, 14}, {15, 15}}, [][2]int{
+		{sections[0].StartLine, sections[0].EndLine},
+		{sections[1].StartLine, sections[1].EndLine},
+		{sections[2].StartLine, sections[2].EndLine},
+	}, "line numbers count frontmatter and skip markers")
+
+	// hashes ignore reflowing but not edits
+	same := writeNotes(t, "Channels connect goroutines.")
+	edited := writeNotes(t, "Channels connect many goroutines.")
+	sameSections, err := SplitSections(context.Background(), same)
+	assert.NoError(t, err)
+	editedSections, err := SplitSections(context.Background(), edited)
+	assert.NoError(t, err)
+	assert.Equal(t, sections[1].Hash, sameSections[0].Hash)
+	assert.NotEqual(t, sections[1].Hash, editedSections[0].Hash)
+}
+
+func TestGroupSections(t *testing.T) {
+	var notes []string
+	for i := 0; i < 40; i++ {
+		notes = append(notes, fmt.Sprintf("## Heading %d", i), "Channels connect goroutines.")
+	}
+	sections, err := SplitSectionsText(context.Background(), strings.Join(notes, "\n"))
+	assert.NoError(t, err)
+	assert.Len(t, sections, 40)
+
+	parts := GroupSections(context.Background(), sections)
+	assert.Len(t, parts, 1, "short sections are sent together")
+	assert.Len(t, parts[0].Chunks, 1)
+	assert.Len(t, parts[0].Sections, 40)
+
+	long := strings.TrimSpace(strings.Repeat("Mutexes guard shared state. ", 150))
+	sections, err = SplitSectionsText(context.Background(), "Short intro.\n---\n"+long+"\n---\n"+long+"\n---\nShort outro.")
+	assert.NoError(t, err)
+	parts = GroupSections(context.Background(), sections)
+	if assert.Len(t, parts, 3, "a part ends once it is big enough") {
+		assert.Equal(t, "Short intro. "+long, parts[0].Text)
+		assert.Equal(t, long, parts[1].Text)
+		assert.Equal(t, "Short outro.", parts[2].Text)
+	}
+
+	// a part of one section is identified by its section
+	parts = GroupSections(context.Background(), sections[1:2])
+	assert.Equal(t, sections[1].Hash, parts[0].Hash)
+	assert.NotEqual(t, GroupSections(context.Background(), sections[:2])[0].Hash, GroupSections(context.Background(), sections[1:3])[0].Hash)
+}
+
+func TestChunkDocumentSplitsTextWithoutSpaces(t *testing.T) {
+	budget := ChunkBudget(string(DefaultModel))
+	path := writeNotes(t, strings.Repeat("並行処理はゴルーチンで行う。", 300))
+
+	chunks, err := ChunkDocument(context.Background(), path)
+	assert.NoError(t, err)
+	assert.Greater(t, len(chunks), 1, "one long run of CJK text must not become one chunk")
+	for _, chunk := range chunks {
+		assert.LessOrEqual(t, EstimateTokens(context.Background(), chunk), budget+1)
+	}
+}
+
+func TestChunkBudget(t *testing.T) {
+	assert.Equal(t, DefaultChunkTokens, ChunkBudget("gpt-4o-mini"))
+
+	DefaultModelLimits["tiny"] = ModelLimits{ContextWindow: 4000, MaxOutput: 300}
+	t.Cleanup(func() { delete(DefaultModelLimits, "tiny") })
+	assert.Equal(t, 400, ChunkBudget("tiny"), "expected output must fit the output limit")
+}
@@ -2,35 +2,84 @@ package transform
 
 import (
 	"context"
+	"net/http"
+	"os"
 
 	"github.com/jaxxk/anki-cards-generator/internal/encryption"
+	"github.com/jaxxk/anki-cards-generator/internal/transform/cassette"
 	"github.com/jaxxk/anki-cards-generator/pkg/logging"
 	"github.com/openai/openai-go"
 	"github.com/openai/openai-go/option"
 	"go.uber.org/zap"
 )
 
+// BASE_URL_ENV overrides the OpenAI API base URL, e.g. for an OpenAI-compatible gateway.
+var BASE_URL_ENV = "OPENAI_BASE_URL"
+
+// replayAPIKey is sent when replaying a cassette, where no real key is needed.
+const replayAPIKey = "replay"
+
 func newClient(logger *zap.SugaredLogger) (*openai.Client, error) {
-	key, err := encryption.GetAPIKey(logger)
+	opts := []option.RequestOption{}
+	if baseURL := os.Getenv(BASE_URL_ENV); baseURL != "" {
+		opts = append(opts, option.WithBaseURL(baseURL))
+	}
+
+	mode, transport, err := cassetteTransport()
 	if err != nil {
 		return nil, err
 	}
-	client := openai.NewClient(
-		option.WithAPIKey(key),
-	)
+	if transport != nil {
+		logger.Debugf("Using LLM cassette %s in %s mode", os.Getenv(cassette.PATH_ENV), mode)
+		opts = append(opts, option.WithHTTPClient(&http.Client{Transport: transport}), option.WithMaxRetries(0))
+	}
+
+	key, err := encryption.GetAPIKey(logger)
+	if err != nil {
+		if mode != cassette.ModeReplay {
+			return nil, err
+		}
+		key = replayAPIKey
+	}
+	opts = append(opts, option.WithAPIKey(key))
+
+	client := openai.NewClient(opts...)
 	return client, nil
 }
 
+// cassetteTransport returns the record/replay transport configured by cassette.PATH_ENV, if any.
+// The transport is shared by every client using the same cassette.
+func cassetteTransport() (cassette.Mode, http.RoundTripper, error) {
+	path := os.Getenv(cassette.PATH_ENV)
+	if path == "" {
+		return "", nil, nil
+	}
+	mode, err := cassette.ParseMode(os.Getenv(cassette.MODE_ENV))
+	if err != nil {
+		return "", nil, err
+	}
+	transport, err := cassette.Open(path, mode)
+	if err != nil {
+		return "", nil, err
+	}
+	return mode, transport, nil
+}
+
 // NewChatCompletion creates a new chat completion request to OpenAI using the provided context and input data.
 // ctx: the request context for handling timeouts and cancellations.
 // promptData: the input string appended to the default prompt to the OpenAI API.
 func NewChatCompletion(ctx context.Context, promptData string) (*openai.ChatCompletion, error) {
+	return newChatCompletion(ctx, DefaultChatCompletionConfigs(promptData))
+}
+
+// newChatCompletion sends a chat completion request with the given parameters.
+func newChatCompletion(ctx context.Context, configs openai.ChatCompletionNewParams) (*openai.ChatCompletion, error) {
 	logger := logging.FromContext(ctx)
 	client, err := newClient(logger)
 	if err != nil {
 		return nil, err
 	}
-	configs := DefaultChatCompletionConfigs(promptData)
+	configs.Model = openai.F(ModelFromContext(ctx))
 	logger.Infof("Prompt: \n %v \n", configs.Messages.Value[0])
 	chatCompletion, err := client.Chat.Completions.New(ctx, configs)
 	if err != nil {
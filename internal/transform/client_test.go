@@ -1,17 +1,26 @@
 package transform
 
 import (
+	"path/filepath"
 	"testing"
 
+	"github.com/jaxxk/anki-cards-generator/internal/transform/llmtest"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 )
 
 func TestNewClient(t *testing.T) {
-	t.Parallel()
+	// replaying needs no API key
+	llmtest.UseCassette(t, filepath.Join("testdata", "cassettes", "create_deck.json"), false)
 	client, err := newClient(zap.NewExample().Sugar())
 	assert.NoError(t, err)
 	if client == nil {
 		t.Fatal("expected client to never be nil")
 	}
 }
+
+func TestNewClientWithoutKey(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	_, err := newClient(zap.NewExample().Sugar())
+	assert.Error(t, err, "a key is required outside of replay mode")
+}
@@ -1,12 +1,26 @@
 package transform
 
-import "github.com/openai/openai-go"
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/openai/openai-go"
+)
 
 // DefaultModel defines the OpenAI model to use for flashcard generation.
 var DefaultModel openai.ChatModel = openai.ChatModelGPT4oMini
 var DefaultFrequencyPenalty float64 = 1.2
 var DefaultPresencePenalty float64 = 1.2
 
+// DefaultStructuredOutput sends the JSON schema of every answer as the json_schema response
+// format. Servers and models that do not support it reject such requests, so with it off the
+// schema is written in the prompt instead and answers are parsed leniently.
+var DefaultStructuredOutput = true
+
+// DefaultSchemaPrompt introduces the JSON schema of the answer when DefaultStructuredOutput is off.
+var DefaultSchemaPrompt string = "Answer with only a JSON value that follows this JSON schema, without code fences or any other text:\n%s"
+
 // DefaultPrompt is the base prompt for generating flashcards.
 var DefaultPrompt string = `
 You are a specialized flashcard generator. Your task is to process a .md or .txt file containing detailed information and produce a series of flashcards in strict JSON format. Each flashcard must include:
@@ -15,6 +29,8 @@ You are a specialized flashcard generator. Your task is to process a .md or .txt
    - Challenges deeper analysis (showing relationships between concepts), or
    - Tests quick recall of fundamental facts.
 2. "back": A comprehensive explanation that integrates relevant details from the content. Include validated Python or Go code examples if they add clarity.
+3. "difficulty": How hard the question is, from 1 (a fact stated plainly in the notes) to 5 (needs several concepts combined).
+4. "level": The cognitive level the question tests: "recall" (remember a fact), "understand" (explain a concept), "apply" (use a concept in a new situation) or "analyze" (compare, relate or break down concepts).
 
 Output Requirements:
 - Return only a JSON array of flashcards.
@@ -25,17 +41,29 @@ The final output must look like this:
 [
   {
     "front": "Some question here",
-    "back": "Some explanation here with optional code snippets"
+    "back": "Some explanation here with optional code snippets",
+    "difficulty": 2,
+    "level": "understand"
   },
   {
     "front": "...",
-    "back": "..."
+    "back": "...",
+    "difficulty": 4,
+    "level": "analyze"
   }
 ]
 
 Do not deviate from this format.
 `
 
+// DefaultRepairAttempts is how many times the model is asked to fix an answer that is not a
+// deck, zero to fail right away.
+var DefaultRepairAttempts = 1
+
+// DefaultRepairPrompt asks the model to fix its answer, given why it could not be parsed.
+var DefaultRepairPrompt string = `Your response could not be parsed: %v
+Return only the corrected deck as a JSON object of the form {"Title": "...", "cards": [{"front": "...", "back": "...", "difficulty": 1, "level": "recall"}]}, without code fences or any other text.`
+
 // DefaultChatCompletionConfigs constructs the OpenAI ChatCompletionNewParams for the given input text.
 // inputText: The content to be processed for generating flashcards.
 // Returns: OpenAI ChatCompletionNewParams with the configured parameters.
@@ -57,12 +85,193 @@ func DefaultChatCompletionConfigs(inputText string) openai.ChatCompletionNewPara
 		// only have 1 chat completion choice
 		N:               openai.Int(1),
 		PresencePenalty: openai.Float(DefaultPresencePenalty),
-		ResponseFormat: openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](
+	}
+	return withResponseSchema(params, responseSchema)
+}
+
+// repairChatCompletionConfigs constructs the request asking the model to fix rawOutput, its
+// answer to the DefaultChatCompletionConfigs request for inputText that failed with parseErr.
+func repairChatCompletionConfigs(inputText, rawOutput string, parseErr error) openai.ChatCompletionNewParams {
+	params := DefaultChatCompletionConfigs(inputText)
+	messages := append(params.Messages.Value,
+		openai.AssistantMessage(rawOutput),
+		openai.UserMessage(fmt.Sprintf(DefaultRepairPrompt, parseErr)),
+	)
+	params.Messages = openai.F(messages)
+	return params
+}
+
+// DefaultJudgePrompt asks the model to verify flashcards against the notes they were made from.
+var DefaultJudgePrompt string = `
+You are a strict fact checker for flashcards. You receive source notes and numbered flashcards generated from them.
+For every flashcard decide whether everything its back states is supported by the source notes. General knowledge that the notes do not state is NOT supported. Code examples that only illustrate what the notes say are supported.
+
+Return one verdict per flashcard with:
+1. "card": the number of the flashcard.
+2. "supported": true if every statement of the back is supported by the notes.
+3. "unsupported_claims": the statements of the back that the notes do not support, quoted briefly. Empty when supported.
+`
+
+// judgeChatCompletionConfigs constructs the request verifying flashcards with DefaultJudgePrompt.
+func judgeChatCompletionConfigs(inputText string) openai.ChatCompletionNewParams {
+	return withResponseSchema(openai.ChatCompletionNewParams{
+		Model: openai.F(DefaultModel),
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
+			openai.ChatCompletionDeveloperMessageParam{
+				Role: openai.F(openai.ChatCompletionDeveloperMessageParamRoleDeveloper),
+				Content: openai.F([]openai.ChatCompletionContentPartTextParam{
+					openai.TextPart(DefaultJudgePrompt),
+				}),
+			},
+			openai.UserMessage(inputText),
+		}),
+		N: openai.Int(1),
+	}, openai.ResponseFormatJSONSchemaJSONSchemaParam{
+		Name:        openai.F("verdicts"),
+		Description: openai.F("Whether each flashcard is supported by the source notes"),
+		Schema:      openai.F(generateSchema[Verdicts]()),
+		Strict:      openai.Bool(true),
+	})
+}
+
+// DefaultSummaryPrompt asks the model for the outline and glossary of a part of the notes.
+var DefaultSummaryPrompt string = `
+You summarize study notes so that flashcards written from any part of them can follow the whole.
+Return:
+1. "outline": the main points of the notes in order, at most 12, one short sentence each. Keep the argument the notes make, not their wording.
+2. "glossary": the terms the notes define or rely on, at most 20, each with a one sentence definition taken from the notes.
+Only use what the notes say.
+`
+
+// DefaultSummaryMergePrompt asks the model to merge the summaries of consecutive parts of the
+// notes into one.
+var DefaultSummaryMergePrompt string = `
+You receive the outlines and glossaries of consecutive parts of the same study notes, in order.
+Merge them into one summary of the whole notes:
+1. "outline": at most 12 short points in order, combining points that say the same thing and keeping the overall argument.
+2. "glossary": at most 20 terms, each defined once, keeping the clearest definition of terms that appear in several parts.
+Only use what the summaries say.
+`
+
+// summaryChatCompletionConfigs constructs the request summarizing inputText with prompt,
+// DefaultSummaryPrompt or DefaultSummaryMergePrompt.
+func summaryChatCompletionConfigs(prompt, inputText string) openai.ChatCompletionNewParams {
+	return withResponseSchema(openai.ChatCompletionNewParams{
+		Model: openai.F(DefaultModel),
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
+			openai.ChatCompletionDeveloperMessageParam{
+				Role: openai.F(openai.ChatCompletionDeveloperMessageParamRoleDeveloper),
+				Content: openai.F([]openai.ChatCompletionContentPartTextParam{
+					openai.TextPart(prompt),
+				}),
+			},
+			openai.UserMessage(inputText),
+		}),
+		N: openai.Int(1),
+	}, openai.ResponseFormatJSONSchemaJSONSchemaParam{
+		Name:        openai.F("summary"),
+		Description: openai.F("The outline and glossary of study notes"),
+		Schema:      openai.F(generateSchema[DocumentSummary]()),
+		Strict:      openai.Bool(true),
+	})
+}
+
+// DefaultRubric is what the refinement pass holds flashcards to.
+var DefaultRubric string = `- Atomicity: every card asks about exactly one fact or relationship; split cards that ask several things.
+- Clarity: the front is a precise question that can only be answered one way, without pronouns that need context.
+- Correctness: the back is supported by the notes; fix or remove anything the notes do not say.
+- Difficulty mix: keep a mix of quick recall and deeper analysis questions.`
+
+// DefaultRefinePrompt asks the model to critique flashcards against a rubric and revise them.
+var DefaultRefinePrompt string = `
+You are a flashcard editor. You receive a rubric, source notes and flashcards generated from them in JSON.
+First critique every flashcard against each point of the rubric. Then return the revised set of flashcards: keep good cards as they are, rewrite, split or merge cards that break the rubric, and remove cards that cannot be fixed.
+Return the revised deck in the same JSON format, keeping its title.
+`
+
+// DefaultRegeneratePrompt asks the model to rewrite one flashcard from the notes it was made from.
+var DefaultRegeneratePrompt string = `
+You are a flashcard editor. You receive the part of the notes a flashcard was made from, and the flashcard.
+Write exactly one new flashcard about the same fact, with a clearer question and a more accurate answer. Use only what the notes say; when no notes are given, keep to what the flashcard says.
+Return it as a deck of one card in the same JSON format, with the fields front, back, difficulty and level.
+`
+
+// regenerateChatCompletionConfigs constructs the request rewriting a flashcard with
+// DefaultRegeneratePrompt. It returns the same schema as DefaultChatCompletionConfigs.
+func regenerateChatCompletionConfigs(inputText string) openai.ChatCompletionNewParams {
+	return withResponseSchema(openai.ChatCompletionNewParams{
+		Model: openai.F(DefaultModel),
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
+			openai.ChatCompletionDeveloperMessageParam{
+				Role: openai.F(openai.ChatCompletionDeveloperMessageParamRoleDeveloper),
+				Content: openai.F([]openai.ChatCompletionContentPartTextParam{
+					openai.TextPart(DefaultRegeneratePrompt),
+				}),
+			},
+			openai.UserMessage(inputText),
+		}),
+		N: openai.Int(1),
+	}, CreateResponseSchema())
+}
+
+// refineChatCompletionConfigs constructs the request revising flashcards with
+// DefaultRefinePrompt. It returns the same schema as DefaultChatCompletionConfigs.
+func refineChatCompletionConfigs(inputText string) openai.ChatCompletionNewParams {
+	return withResponseSchema(openai.ChatCompletionNewParams{
+		Model: openai.F(DefaultModel),
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
+			openai.ChatCompletionDeveloperMessageParam{
+				Role: openai.F(openai.ChatCompletionDeveloperMessageParamRoleDeveloper),
+				Content: openai.F([]openai.ChatCompletionContentPartTextParam{
+					openai.TextPart(DefaultRefinePrompt),
+				}),
+			},
+			openai.UserMessage(inputText),
+		}),
+		N: openai.Int(1),
+	}, CreateResponseSchema())
+}
+
+// withResponseSchema asks for answers that follow schema: as the response format of params, or,
+// when DefaultStructuredOutput is off, in a developer message after the prompt.
+func withResponseSchema(params openai.ChatCompletionNewParams, schema openai.ResponseFormatJSONSchemaJSONSchemaParam) openai.ChatCompletionNewParams {
+	if DefaultStructuredOutput {
+		params.ResponseFormat = openai.F[openai.ChatCompletionNewParamsResponseFormatUnion](
 			openai.ResponseFormatJSONSchemaParam{
 				Type:       openai.F(openai.ResponseFormatJSONSchemaTypeJSONSchema),
-				JSONSchema: openai.F(responseSchema),
+				JSONSchema: openai.F(schema),
 			},
-		),
+		)
+		return params
 	}
+	serialized, err := json.Marshal(schema.Schema.Value)
+	if err != nil {
+		// the prompts describe the answer too, so it can go without the schema
+		return params
+	}
+	instruction := openai.ChatCompletionDeveloperMessageParam{
+		Role: openai.F(openai.ChatCompletionDeveloperMessageParamRoleDeveloper),
+		Content: openai.F([]openai.ChatCompletionContentPartTextParam{
+			openai.TextPart(fmt.Sprintf(DefaultSchemaPrompt, serialized)),
+		}),
+	}
+	messages := append([]openai.ChatCompletionMessageParamUnion{}, params.Messages.Value[:1]...)
+	messages = append(messages, instruction)
+	params.Messages = openai.F(append(messages, params.Messages.Value[1:]...))
 	return params
 }
+
+type modelKey struct{}
+
+// WithModel returns a context whose requests are sent to model instead of DefaultModel.
+func WithModel(ctx context.Context, model openai.ChatModel) context.Context {
+	return context.WithValue(ctx, modelKey{}, model)
+}
+
+// ModelFromContext returns the model in ctx, or DefaultModel.
+func ModelFromContext(ctx context.Context) openai.ChatModel {
+	if model, _ := ctx.Value(modelKey{}).(openai.ChatModel); model != "" {
+		return model
+	}
+	return DefaultModel
+}
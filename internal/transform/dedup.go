@@ -0,0 +1,226 @@
+package transform
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/jaxxk/anki-cards-generator/internal/stopwords"
+	"github.com/jaxxk/anki-cards-generator/pkg/logging"
+)
+
+// DefaultDuplicateThreshold is the similarity of two fronts from which the cards count as
+// duplicates. Similarity is the cosine of the TF-IDF vectors of the fronts' stemmed words,
+// weighted across the cards compared, so words every card shares count for little. Zero turns
+// deduplication off.
+var DefaultDuplicateThreshold = 0.8
+
+// Duplicate is a card dropped for asking the same as a kept one.
+type Duplicate struct {
+	Dropped Flashcards
+	Kept    Flashcards
+	// Existing is set when Kept is a note already in the target deck
+	Existing   bool
+	Similarity float64
+}
+
+// DedupOptions configures Dedup.
+type DedupOptions struct {
+	// Threshold is the similarity from which fronts are duplicates, DefaultDuplicateThreshold if zero
+	Threshold float64
+	// Existing are the cards already in the target deck, which always win over new ones
+	Existing []Flashcards
+}
+
+// Dedup drops near-duplicate cards, keeping the best card of every group of cards asking the
+// same thing, and every card that asks what a card in opts.Existing already does. Kept cards
+// stay in order and gain the tags of the cards dropped for them.
+func Dedup(cards []Flashcards, opts DedupOptions) ([]Flashcards, []Duplicate) {
+	threshold := opts.Threshold
+	if threshold <= 0 {
+		threshold = DefaultDuplicateThreshold
+	}
+	if threshold <= 0 || len(cards) == 0 {
+		return cards, nil
+	}
+
+	// Existing notes come first, then the new cards
+	all := append(append([]Flashcards{}, opts.Existing...), cards...)
+	vectors := tfidfVectors(all)
+	existing := len(opts.Existing)
+
+	// Better cards are considered first, so they are the ones kept
+	order := make([]int, len(cards))
+	for i := range order {
+		order[i] = existing + i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return cardScore(all[order[a]]) > cardScore(all[order[b]])
+	})
+
+	// kept cards are found again through the terms of their fronts
+	postings := map[string][]int{}
+	keep := func(i int) {
+		for term := range vectors[i] {
+			postings[term] = append(postings[term], i)
+		}
+	}
+	for i := 0; i < existing; i++ {
+		keep(i)
+	}
+
+	dropped := map[int]bool{}
+	var duplicates []Duplicate
+	for _, i := range order {
+		best, bestSimilarity := -1, 0.0
+		seen := map[int]bool{}
+		for term := range vectors[i] {
+			for _, candidate := range postings[term] {
+				if seen[candidate] {
+					continue
+				}
+				seen[candidate] = true
+				if similarity := cosine(vectors[i], vectors[candidate]); similarity >= threshold && similarity > bestSimilarity {
+					best, bestSimilarity = candidate, similarity
+				}
+			}
+		}
+		if best < 0 {
+			keep(i)
+			continue
+		}
+		dropped[i] = true
+		duplicates = append(duplicates, Duplicate{Dropped: all[i], Kept: all[best], Existing: best < existing, Similarity: bestSimilarity})
+		if best >= existing {
+			all[best].Tags = mergeTags(all[best].Tags, all[i].Tags)
+		}
+	}
+
+	result := make([]Flashcards, 0, len(cards)-len(duplicates))
+	for i := existing; i < len(all); i++ {
+		if !dropped[i] {
+			result = append(result, all[i])
+		}
+	}
+	return result, duplicates
+}
+
+// dedupCards drops the near-duplicates among cards, logging what was dropped.
+func dedupCards(ctx context.Context, cards []Flashcards) []Flashcards {
+	deduped, duplicates := Dedup(cards, DedupOptions{})
+	logger := logging.FromContext(ctx)
+	for _, duplicate := range duplicates {
+		logger.Debugf("Dropped %q as a duplicate of %q (%.2f)", duplicate.Dropped.Front, duplicate.Kept.Front, duplicate.Similarity)
+	}
+	if len(duplicates) > 0 {
+		logger.Infof("Dropped %d near-duplicate card(s)", len(duplicates))
+	}
+	return deduped
+}
+
+// tfidfVectors weighs the terms of every card's front by how rare they are among the cards,
+// with the smoothed inverse document frequency ln((1+n)/(1+df))+1.
+func tfidfVectors(cards []Flashcards) []map[string]float64 {
+	counts := make([]map[string]float64, len(cards))
+	documentFrequency := map[string]int{}
+	for i, card := range cards {
+		counts[i] = map[string]float64{}
+		for _, term := range questionTerms(card.Front) {
+			if counts[i][term] == 0 {
+				documentFrequency[term]++
+			}
+			counts[i][term]++
+		}
+	}
+	n := float64(len(cards))
+	for _, vector := range counts {
+		for term, count := range vector {
+			vector[term] = count * (math.Log((1+n)/(1+float64(documentFrequency[term]))) + 1)
+		}
+	}
+	return counts
+}
+
+// cosine returns the cosine similarity of two sparse vectors.
+func cosine(a, b map[string]float64) float64 {
+	var dot, normA, normB float64
+	for term, weight := range a {
+		dot += weight * b[term]
+		normA += weight * weight
+	}
+	for _, weight := range b {
+		normB += weight * weight
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+// questionTerms returns the lowercased, stemmed words of text without punctuation and stopwords.
+func questionTerms(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := words[:0]
+	for _, word := range words {
+		if !stopwords.Is(word) {
+			terms = append(terms, stem(word))
+		}
+	}
+	return terms
+}
+
+// stem strips common English suffixes, so "closes", "closed" and "closing" are one term.
+func stem(word string) string {
+	switch {
+	case len(word) > 5 && strings.HasSuffix(word, "ing"):
+		word = word[:len(word)-3]
+	case len(word) > 4 && strings.HasSuffix(word, "ed"):
+		word = word[:len(word)-2]
+	case len(word) > 4 && strings.HasSuffix(word, "es"):
+		word = word[:len(word)-2]
+	case len(word) > 3 && strings.HasSuffix(word, "s") && !strings.HasSuffix(word, "ss"):
+		word = word[:len(word)-1]
+	}
+	if len(word) > 4 && strings.HasSuffix(word, "e") {
+		word = word[:len(word)-1]
+	}
+	return word
+}
+
+// maxRankedTerms caps the answer terms that count, so a long answer does not win by length.
+const maxRankedTerms = 30
+
+// cardScore ranks cards asking the same thing: answers with more distinct words, up to
+// maxRankedTerms, and with code, explain more.
+func cardScore(card Flashcards) int {
+	words := map[string]bool{}
+	for _, term := range questionTerms(card.Back) {
+		words[term] = true
+	}
+	score := min(len(words), maxRankedTerms)
+	if strings.Contains(card.Back, "```") {
+		score += 5
+	}
+	return score
+}
+
+// mergeTags appends the tags of extra missing from tags.
+func mergeTags(tags, extra []string) []string {
+	for _, tag := range extra {
+		found := false
+		for _, existing := range tags {
+			if existing == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
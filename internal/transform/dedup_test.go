@@ -0,0 +1,68 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedup(t *testing.T) {
+	cards := []Flashcards{
+		{Front: "What is a goroutine?", Back: "A function.", Tags: []string{"a"}},
+		{Front: "How do you close a channel?", Back: "Call close."},
+		{Front: "What are goroutines?", Back: "Functions running concurrently with other goroutines in the same address space.", Tags: []string{"b"}},
+		{Front: "How is a channel closed?", Back: "With the builtin close, which signals no more values will be sent."},
+		{Front: "What is a buffered channel?", Back: "A channel with capacity."},
+		{Front: "What is an unbuffered channel?", Back: "A channel without capacity."},
+		{Front: "What is the zero value of a map?", Back: "nil"},
+		{Front: "What is the zero value of a slice?", Back: "nil"},
+	}
+
+	deduped, duplicates := Dedup(cards, DedupOptions{})
+	// the card with the more informative answer is kept, in its place, with both tags
+	assert.Equal(t, []Flashcards{
+		{Front: "What are goroutines?", Back: "Functions running concurrently with other goroutines in the same address space.", Tags: []string{"b", "a"}},
+		{Front: "How is a channel closed?", Back: "With the builtin close, which signals no more values will be sent."},
+		cards[4], cards[5], cards[6], cards[7],
+	}, deduped)
+	assert.Len(t, duplicates, 2)
+	for _, duplicate := range duplicates {
+		assert.False(t, duplicate.Existing)
+		assert.GreaterOrEqual(t, duplicate.Similarity, DefaultDuplicateThreshold)
+	}
+	assert.Equal(t, "What is a goroutine?", duplicates[1].Dropped.Front)
+}
+
+func TestDedupAgainstExisting(t *testing.T) {
+	existing := []Flashcards{{Front: "What do goroutines do?", Back: "Run concurrently."}}
+	cards := []Flashcards{
+		{Front: "What does a goroutine do?", Back: "It runs a function concurrently with all other goroutines."},
+		{Front: "What is a channel?", Back: "A typed conduit."},
+	}
+
+	deduped, duplicates := Dedup(cards, DedupOptions{Existing: existing})
+	assert.Equal(t, cards[1:], deduped)
+	assert.Len(t, duplicates, 1)
+	assert.True(t, duplicates[0].Existing)
+	assert.Equal(t, existing[0], duplicates[0].Kept)
+}
+
+func TestDedupThreshold(t *testing.T) {
+	cards := []Flashcards{{Front: "What is a goroutine?"}, {Front: "What's a goroutine in Go?"}}
+	deduped, _ := Dedup(cards, DedupOptions{})
+	assert.Len(t, deduped, 2)
+	deduped, _ = Dedup(cards, DedupOptions{Threshold: 0.5})
+	assert.Len(t, deduped, 1)
+
+	// zero turns it off
+	defer func(threshold float64) { DefaultDuplicateThreshold = threshold }(DefaultDuplicateThreshold)
+	DefaultDuplicateThreshold = 0
+	deduped, _ = Dedup([]Flashcards{{Front: "Q"}, {Front: "Q"}}, DedupOptions{})
+	assert.Len(t, deduped, 2)
+}
+
+func TestQuestionTerms(t *testing.T) {
+	assert.Equal(t, []string{"channel", "clos"}, questionTerms("How is a channel closed?"))
+	assert.Equal(t, []string{"clos", "channel"}, questionTerms("How do you close channels?"))
+	assert.Equal(t, []string{"goroutin", "go"}, questionTerms("What's a goroutine in Go?"))
+}
@@ -0,0 +1,342 @@
+package transform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jaxxk/anki-cards-generator/pkg/logging"
+)
+
+// Density sets how many cards every chunk of notes should give. Zero values leave it to the
+// model.
+type Density struct {
+	CardsPer100Words float64
+	MinCards         int
+	MaxCards         int
+}
+
+// Validate rejects negative values and a minimum above the maximum.
+func (d Density) Validate() error {
+	switch {
+	case d.CardsPer100Words < 0 || d.MinCards < 0 || d.MaxCards < 0:
+		return errors.New("card density settings cannot be negative")
+	case d.MaxCards > 0 && d.MinCards > d.MaxCards:
+		return fmt.Errorf("min cards %d is above max cards %d", d.MinCards, d.MaxCards)
+	}
+	return nil
+}
+
+// IsZero reports whether d leaves the number of cards to the model.
+func (d Density) IsZero() bool {
+	return d == Density{}
+}
+
+// WithFrontmatter returns d overridden by the cards_per_100_words, min_cards and max_cards
+// keys of the YAML frontmatter of content, if it has any.
+func (d Density) WithFrontmatter(content string) (Density, error) {
+	frontmatter, _ := splitFrontmatter(content)
+	for key, value := range frontmatterValues(frontmatter) {
+		var err error
+		switch key {
+		case "cards_per_100_words":
+			d.CardsPer100Words, err = strconv.ParseFloat(value, 64)
+		case "min_cards":
+			d.MinCards, err = strconv.Atoi(value)
+		case "max_cards":
+			d.MaxCards, err = strconv.Atoi(value)
+		default:
+			continue
+		}
+		if err != nil {
+			return Density{}, fmt.Errorf("invalid %s %q in frontmatter", key, value)
+		}
+	}
+	if err := d.Validate(); err != nil {
+		return Density{}, fmt.Errorf("invalid card density in frontmatter: %w", err)
+	}
+	return d, nil
+}
+
+// frontmatterValues returns the top-level "key: value" pairs of YAML frontmatter, with keys
+// lowercased, dashes read as underscores and values unquoted. Nested values are left out.
+func frontmatterValues(frontmatter string) map[string]string {
+	values := map[string]string{}
+	for _, line := range strings.Split(frontmatter, "\n") {
+		if line == "" || line[0] == ' ' || line[0] == '\t' || line[0] == '#' || line[0] == '-' {
+			continue
+		}
+		key, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		key = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(key)), "-", "_")
+		value = strings.TrimSpace(value)
+		if comment := strings.Index(value, " #"); comment >= 0 {
+			value = strings.TrimSpace(value[:comment])
+		}
+		values[key] = strings.Trim(value, `"'`)
+	}
+	return values
+}
+
+// CardTarget is the number of cards wanted from one chunk. Ideal is zero when only a range is
+// set, and Min and Max are zero when unbounded.
+type CardTarget struct {
+	Ideal int
+	Min   int
+	Max   int
+}
+
+// Target returns the number of cards wanted from a chunk of words words.
+func (d Density) Target(words int) CardTarget {
+	target := CardTarget{Min: d.MinCards, Max: d.MaxCards}
+	if d.CardsPer100Words > 0 {
+		target.Ideal = int(math.Max(1, math.Round(float64(words)*d.CardsPer100Words/100)))
+		if target.Ideal < target.Min {
+			target.Ideal = target.Min
+		}
+		if target.Max > 0 && target.Ideal > target.Max {
+			target.Ideal = target.Max
+		}
+	}
+	return target
+}
+
+// instruction tells the model how many cards to write, empty when any number will do.
+func (t CardTarget) instruction() string {
+	switch {
+	case t.Ideal > 0:
+		return fmt.Sprintf("Write %d flashcards.", t.Ideal)
+	case t.Min > 0 && t.Max > 0:
+		return fmt.Sprintf("Write between %d and %d flashcards.", t.Min, t.Max)
+	case t.Min > 0:
+		return fmt.Sprintf("Write at least %d flashcards.", t.Min)
+	case t.Max > 0:
+		return fmt.Sprintf("Write at most %d flashcards.", t.Max)
+	}
+	return ""
+}
+
+// limit returns how many cards are kept at most, zero for all of them.
+func (t CardTarget) limit() int {
+	if t.Ideal > 0 {
+		return t.Ideal
+	}
+	return t.Max
+}
+
+// RankCards keeps the best keep cards by cardScore, in their original order, and returns the
+// others as dropped. keep zero or above len(cards) keeps every card. With a mix, the places are
+// shared out between the levels by their share first, so trimming does not skew the mix, and
+// the places a level has no cards for go to the best remaining cards.
+func RankCards(cards []Flashcards, keep int, mix Mix) (kept, dropped []Flashcards) {
+	if keep <= 0 || keep >= len(cards) {
+		return cards, nil
+	}
+	order := make([]int, len(cards))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return rankScore(cards[order[i]]) > rankScore(cards[order[j]]) })
+
+	keepIndex := map[int]bool{}
+	quotas := mix.quotas(keep)
+	for _, i := range order {
+		if level := cards[i].Level; quotas[level] > 0 {
+			keepIndex[i] = true
+			quotas[level]--
+		}
+	}
+	for _, i := range order {
+		if len(keepIndex) == keep {
+			break
+		}
+		keepIndex[i] = true
+	}
+	for i, card := range cards {
+		if keepIndex[i] {
+			kept = append(kept, card)
+		} else {
+			dropped = append(dropped, card)
+		}
+	}
+	return kept, dropped
+}
+
+// rankScore orders the cards of a chunk competing for its places: cards by cardScore, and
+// cards that are empty or do not ask a question last.
+func rankScore(card Flashcards) int {
+	score := cardScore(card)
+	if !strings.HasSuffix(strings.TrimSpace(card.Front), "?") {
+		score -= 10
+	}
+	if strings.TrimSpace(card.Front) == "" || strings.TrimSpace(card.Back) == "" {
+		score -= 100
+	}
+	return score
+}
+
+// DensityReport counts the cards of a run against the words of its notes.
+type DensityReport struct {
+	Chunks int
+	Words  int
+	Cards  int
+	// Dropped counts the cards over a chunk's target, UnderMin the chunks that gave too few
+	Dropped  int
+	UnderMin int
+}
+
+// PerHundredWords returns the cards per 100 words of notes.
+func (r DensityReport) PerHundredWords() float64 {
+	if r.Words == 0 {
+		return 0
+	}
+	return float64(r.Cards) * 100 / float64(r.Words)
+}
+
+// Summary describes the report in one line.
+func (r DensityReport) Summary() string {
+	summary := fmt.Sprintf("%d card(s) from %d words, %.1f per 100 words", r.Cards, r.Words, r.PerHundredWords())
+	var notes []string
+	if r.Dropped > 0 {
+		notes = append(notes, fmt.Sprintf("%d card(s) over target dropped", r.Dropped))
+	}
+	if r.UnderMin > 0 {
+		notes = append(notes, fmt.Sprintf("%d chunk(s) under the minimum", r.UnderMin))
+	}
+	if len(notes) > 0 {
+		summary += " (" + strings.Join(notes, ", ") + ")"
+	}
+	return summary
+}
+
+// Targeting asks the model for the number of cards its Density sets for every chunk, and for
+// its Mix of levels, keeps the best cards within the number and reports the density reached.
+type Targeting struct {
+	Density Density
+	Mix     Mix
+
+	mu                 sync.Mutex
+	report             DensityReport
+	unrecognizedLevels map[string]bool
+}
+
+// Report returns the cards and words counted so far.
+func (t *Targeting) Report() DensityReport {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.report
+}
+
+// CheckMix compares the levels of cards with the Mix, listing the levels the model answered
+// with so far that are not Bloom levels.
+func (t *Targeting) CheckMix(cards []Flashcards) MixReport {
+	report := CheckMix(cards, t.Mix)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for level := range t.unrecognizedLevels {
+		report.Unrecognized = append(report.Unrecognized, level)
+	}
+	sort.Strings(report.Unrecognized)
+	return report
+}
+
+// unrecognized notes levels of the model's answers that are not Bloom levels.
+func (t *Targeting) unrecognized(levels []string) {
+	if t == nil || len(levels) == 0 {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.unrecognizedLevels == nil {
+		t.unrecognizedLevels = map[string]bool{}
+	}
+	for _, level := range levels {
+		t.unrecognizedLevels[level] = true
+	}
+}
+
+type targetingKey struct{}
+
+// WithTargeting returns a context whose generated chunks are targeted by t.
+func WithTargeting(ctx context.Context, t *Targeting) context.Context {
+	return context.WithValue(ctx, targetingKey{}, t)
+}
+
+// TargetingFromContext returns the Targeting in ctx, or nil.
+func TargetingFromContext(ctx context.Context) *Targeting {
+	t, _ := ctx.Value(targetingKey{}).(*Targeting)
+	return t
+}
+
+// target returns the number of cards wanted from a chunk of words words.
+func (t *Targeting) target(words int) CardTarget {
+	if t == nil {
+		return CardTarget{}
+	}
+	return t.Density.Target(words)
+}
+
+// prompt appends the number of cards wanted and the mix of levels to the text sent to the
+// model.
+func (t *Targeting) prompt(text string, target CardTarget) string {
+	var instructions []string
+	if instruction := target.instruction(); instruction != "" {
+		instructions = append(instructions, instruction)
+	}
+	if t != nil {
+		if instruction := t.Mix.instruction(); instruction != "" {
+			instructions = append(instructions, instruction)
+		}
+	}
+	if len(instructions) == 0 {
+		return text
+	}
+	return text + "\n\n" + strings.Join(instructions, " ")
+}
+
+// keep cuts the cards of a chunk of words words down to its target and counts them.
+func (t *Targeting) keep(ctx context.Context, target CardTarget, words int, cards []Flashcards) []Flashcards {
+	if t == nil {
+		return cards
+	}
+	kept, dropped := RankCards(cards, target.limit(), t.Mix)
+	if len(dropped) > 0 {
+		logging.FromContext(ctx).Debugf("Dropped %d card(s) over the target of %d", len(dropped), target.limit())
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.report.Chunks++
+	t.report.Words += words
+	t.report.Cards += len(kept)
+	t.report.Dropped += len(dropped)
+	if len(kept) < target.Min {
+		t.report.UnderMin++
+	}
+	return kept
+}
+
+// count adds the words of notes that were not sent to the model, such as reused sections.
+func (t *Targeting) count(words int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.report.Words += words
+}
+
+// densitySettings identifies the density and mix in ctx, empty when the model chooses.
+func densitySettings(ctx context.Context) string {
+	t := TargetingFromContext(ctx)
+	if t == nil || (t.Density.IsZero() && len(t.Mix) == 0) {
+		return ""
+	}
+	return fmt.Sprintf("density/%g/%d/%d/%s", t.Density.CardsPer100Words, t.Density.MinCards, t.Density.MaxCards, t.Mix)
+}
@@ -0,0 +1,130 @@
+package transform
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDensityTarget(t *testing.T) {
+	tests := []struct {
+		name        string
+		density     Density
+		words       int
+		target      CardTarget
+		instruction string
+	}{
+		{"model chooses", Density{}, 250, CardTarget{}, ""},
+		{"per 100 words", Density{CardsPer100Words: 2}, 250, CardTarget{Ideal: 5}, "Write 5 flashcards."},
+		{"at least one", Density{CardsPer100Words: 1}, 20, CardTarget{Ideal: 1}, "Write 1 flashcards."},
+		{"raised to the minimum", Density{CardsPer100Words: 1, MinCards: 3}, 100, CardTarget{Ideal: 3, Min: 3}, "Write 3 flashcards."},
+		{"capped at the maximum", Density{CardsPer100Words: 4, MaxCards: 6}, 400, CardTarget{Ideal: 6, Max: 6}, "Write 6 flashcards."},
+		{"range", Density{MinCards: 2, MaxCards: 8}, 400, CardTarget{Min: 2, Max: 8}, "Write between 2 and 8 flashcards."},
+		{"minimum only", Density{MinCards: 2}, 400, CardTarget{Min: 2}, "Write at least 2 flashcards."},
+		{"maximum only", Density{MaxCards: 8}, 400, CardTarget{Max: 8}, "Write at most 8 flashcards."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := tt.density.Target(tt.words)
+			assert.Equal(t, tt.target, target)
+			assert.Equal(t, tt.instruction, target.instruction())
+		})
+	}
+	var none *Targeting
+	assert.Equal(t, "notes", none.prompt("notes", CardTarget{}))
+	assert.Equal(t, "notes\n\nWrite at most 8 flashcards.", none.prompt("notes", CardTarget{Max: 8}))
+	mixed := &Targeting{Mix: Mix{LevelRecall: 40, LevelApply: 60}}
+	assert.Equal(t, "notes\n\nWrite at most 8 flashcards. Make about 40% recall and 60% apply questions.", mixed.prompt("notes", CardTarget{Max: 8}))
+}
+
+func TestDensityWithFrontmatter(t *testing.T) {
+	base := Density{CardsPer100Words: 2, MaxCards: 10}
+
+	density, err := base.WithFrontmatter("---\ntitle: Go\ncards_per_100_words: 1.5 # fewer\nmin-cards: \"2\"\ntags:\n  - max_cards: 1\n---\n# Go\n")
+	assert.NoError(t, err)
+	assert.Equal(t, Density{CardsPer100Words: 1.5, MinCards: 2, MaxCards: 10}, density)
+
+	density, err = base.WithFrontmatter("# Go\nmax_cards: 1\n")
+	assert.NoError(t, err)
+	assert.Equal(t, base, density, "only frontmatter counts")
+
+	_, err = base.WithFrontmatter("---\nmax_cards: many\n---\n")
+	assert.ErrorContains(t, err, `invalid max_cards "many"`)
+	_, err = base.WithFrontmatter("---\nmin_cards: 12\n---\n")
+	assert.ErrorContains(t, err, "min cards 12 is above max cards 10")
+	assert.ErrorContains(t, Density{MinCards: -1}.Validate(), "cannot be negative")
+}
+
+func TestRankCards(t *testing.T) {
+	cards := []Flashcards{
+		{Front: "What is a channel?", Back: "A conduit."},
+		{Front: "Channels", Back: "A typed conduit goroutines send and receive values through, synchronizing them."},
+		{Front: "What does closing a channel signal?", Back: "That no more values will be sent, so receivers can stop."},
+		{Front: "How is a channel made?", Back: "With make:\n```go\nch := make(chan int)\n```"},
+	}
+
+	kept, dropped := RankCards(cards, 2, nil)
+	assert.Equal(t, []Flashcards{cards[2], cards[3]}, kept, "kept cards stay in order")
+	assert.Equal(t, []Flashcards{cards[0], cards[1]}, dropped)
+
+	kept, dropped = RankCards(cards, 0, nil)
+	assert.Equal(t, cards, kept)
+	assert.Empty(t, dropped)
+}
+
+func TestRankCardsKeepsMix(t *testing.T) {
+	long := "A typed conduit goroutines send and receive values through, synchronizing them."
+	cards := []Flashcards{
+		{Front: "What is a channel?", Back: long, Level: LevelRecall},
+		{Front: "What is a buffered channel?", Back: long + " It holds values.", Level: LevelRecall},
+		{Front: "What does a nil channel do?", Back: long + " It blocks forever.", Level: LevelRecall},
+		{Front: "When would you close a channel?", Back: "When no more values come.", Level: LevelApply},
+		{Front: "How do channels compare to mutexes?", Back: "They pass ownership.", Level: LevelAnalyze},
+	}
+	mix := Mix{LevelRecall: 50, LevelApply: 25, LevelAnalyze: 25}
+
+	kept, dropped := RankCards(cards, 4, mix)
+	assert.Equal(t, []Flashcards{cards[1], cards[2], cards[3], cards[4]}, kept, "the best recall cards and one of each other level")
+	assert.Equal(t, []Flashcards{cards[0]}, dropped)
+
+	kept, _ = RankCards(cards, 3, nil)
+	assert.Equal(t, []Flashcards{cards[0], cards[1], cards[2]}, kept, "without a mix the best answers win")
+
+	// places of levels without cards go to the best remaining ones
+	kept, _ = RankCards(cards, 3, Mix{LevelUnderstand: 100})
+	assert.Equal(t, []Flashcards{cards[0], cards[1], cards[2]}, kept)
+}
+
+func TestMixQuotas(t *testing.T) {
+	mix := Mix{LevelRecall: 40, LevelApply: 40, LevelAnalyze: 20}
+	assert.Equal(t, map[BloomLevel]int{LevelRecall: 2, LevelUnderstand: 0, LevelApply: 2, LevelAnalyze: 1}, mix.quotas(5))
+	quotas := mix.quotas(4)
+	assert.Equal(t, 4, quotas[LevelRecall]+quotas[LevelApply]+quotas[LevelAnalyze])
+	assert.Empty(t, Mix(nil).quotas(0)[LevelRecall])
+}
+
+func TestDensityReportSummary(t *testing.T) {
+	assert.Equal(t, "0 card(s) from 0 words, 0.0 per 100 words", DensityReport{}.Summary())
+	report := DensityReport{Chunks: 3, Words: 800, Cards: 12, Dropped: 4, UnderMin: 1}
+	assert.InDelta(t, 1.5, report.PerHundredWords(), 0.001)
+	assert.Equal(t, "12 card(s) from 800 words, 1.5 per 100 words (4 card(s) over target dropped, 1 chunk(s) under the minimum)", report.Summary())
+}
+
+func TestTransformNoteDensityReplay(t *testing.T) {
+	useCassette(t, "density")
+
+	targeting := &Targeting{Density: Density{MaxCards: 2}}
+	deck, err := TransformNote(WithTargeting(context.Background(), targeting), filepath.Join("testdata", "notes.md"))
+	assert.NoError(t, err)
+
+	report := targeting.Report()
+	chunks, err := ChunkDocument(context.Background(), filepath.Join("testdata", "notes.md"))
+	assert.NoError(t, err)
+	assert.Equal(t, len(chunks), report.Chunks)
+	assert.Equal(t, 2*len(chunks), report.Cards, "the fake writes 3 cards per chunk, 2 are kept")
+	assert.Equal(t, len(chunks), report.Dropped)
+	assert.LessOrEqual(t, len(deck.Cards), report.Cards)
+	assert.Greater(t, report.Words, 0)
+}
@@ -0,0 +1,296 @@
+package transform
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/jaxxk/anki-cards-generator/internal/encryption"
+	"github.com/jaxxk/anki-cards-generator/internal/usage"
+	"github.com/jaxxk/anki-cards-generator/pkg/tokens"
+	"github.com/openai/openai-go"
+)
+
+// DefaultOutputTokenRatio is the expected number of completion tokens per input token of a
+// chunk. Cards restate most of the content, with explanations, in a more compact form.
+var DefaultOutputTokenRatio float64 = 0.75
+
+// ChunkEstimate is the dry-run estimate of the call made for one chunk.
+type ChunkEstimate struct {
+	Index        int
+	Words        int
+	ChunkTokens  int
+	InputTokens  int
+	OutputTokens int
+	Prompt       string
+}
+
+// StageEstimate is the dry-run estimate of the calls made besides the deck of every chunk.
+type StageEstimate struct {
+	Name         string
+	Calls        int
+	InputTokens  int
+	OutputTokens int
+}
+
+// Plan is the dry-run estimate of a whole document.
+type Plan struct {
+	Model  string
+	Chunks []ChunkEstimate
+	// Stages are the summary, refinement and judge calls
+	Stages       []StageEstimate
+	InputTokens  int
+	OutputTokens int
+	// Cost in US dollars, only meaningful when Priced is set
+	Cost   float64
+	Priced bool
+	// Repairs is the most the repairs of unparsable answers can add, left out of the totals
+	// above as answers rarely need one
+	Repairs StageEstimate
+	// MaxCost is Cost with every repair made
+	MaxCost float64
+}
+
+// judgeTokensPerCard is the size of the verdict on one card, and estimatedCardTokens the size
+// of one card, to tell how many cards a chunk gives.
+const (
+	judgeTokensPerCard  = 50
+	estimatedCardTokens = 60
+)
+
+// RenderPrompt returns the messages sent to the model for text, in a readable form.
+func RenderPrompt(text string) string {
+	var sb strings.Builder
+	for i, message := range requestMessages(DefaultChatCompletionConfigs(text)) {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		fmt.Fprintf(&sb, "[%s]\n%s\n", message.Role, strings.TrimSpace(message.Text))
+	}
+	return sb.String()
+}
+
+// renderedMessage is the role and text of one message of a request.
+type renderedMessage struct {
+	Role string
+	Text string
+}
+
+// requestMessages returns the role and text of every message of params.
+func requestMessages(params openai.ChatCompletionNewParams) []renderedMessage {
+	var messages []renderedMessage
+	for _, message := range params.Messages.Value {
+		serialized, err := json.Marshal(message)
+		if err != nil {
+			continue
+		}
+		decoded := struct {
+			Role    string          `json:"role"`
+			Content json.RawMessage `json:"content"`
+		}{}
+		if err := json.Unmarshal(serialized, &decoded); err != nil {
+			continue
+		}
+		rendered := renderedMessage{Role: decoded.Role}
+		// content is a string or a list of text parts
+		if err := json.Unmarshal(decoded.Content, &rendered.Text); err != nil {
+			parts := []struct {
+				Text string `json:"text"`
+			}{}
+			if err := json.Unmarshal(decoded.Content, &parts); err == nil {
+				texts := make([]string, 0, len(parts))
+				for _, part := range parts {
+					texts = append(texts, part.Text)
+				}
+				rendered.Text = strings.Join(texts, "\n")
+			}
+		}
+		messages = append(messages, rendered)
+	}
+	return messages
+}
+
+// requestInputTokens estimates the tokens sent with params: every message and the response
+// schema, when it is sent as the response format.
+func requestInputTokens(ctx context.Context, params openai.ChatCompletionNewParams) int {
+	tokens := 0
+	for _, message := range requestMessages(params) {
+		tokens += EstimateTokens(ctx, message.Text)
+	}
+	if params.ResponseFormat.Present {
+		if schema, err := json.Marshal(CreateResponseSchema().Schema.Value); err == nil {
+			tokens += EstimateTokens(ctx, string(schema))
+		}
+	}
+	return tokens
+}
+
+// promptOverheadTokens estimates the tokens sent to model with every chunk: the prompt and the
+// response schema.
+func promptOverheadTokens(model string) int {
+	encoding := tokens.ForModel(model)
+	overhead := encoding.Count(DefaultPrompt)
+	if schema, err := json.Marshal(CreateResponseSchema().Schema.Value); err == nil {
+		overhead += encoding.Count(string(schema))
+	}
+	return overhead
+}
+
+// PlanDocument chunks the document at docPath exactly like generate would and estimates the
+// tokens and cost of each call with the settings in ctx without contacting the model.
+func PlanDocument(ctx context.Context, docPath string, prices usage.PriceTable) (Plan, error) {
+	content, err := readDocument(docPath)
+	if err != nil {
+		return Plan{}, err
+	}
+	sections, err := SplitSectionsText(ctx, string(content))
+	if err != nil {
+		return Plan{}, err
+	}
+	chunks := sectionChunks(ctx, sections)
+	return planChunks(ctx, content, chunks, chunks, prices)
+}
+
+// PlanChanges estimates the calls TransformNoteIncremental would make on docPath with the
+// settings in ctx: only the chunks of parts that changed since the last run, or every chunk
+// when full is set.
+func PlanChanges(ctx context.Context, docPath string, full bool, prices usage.PriceTable) (Plan, error) {
+	content, err := readDocument(docPath)
+	if err != nil {
+		return Plan{}, err
+	}
+	sections, err := SplitSectionsText(ctx, string(content))
+	if err != nil {
+		return Plan{}, err
+	}
+	_, reusable, _, err := reusableRecords(ctx, docPath, full)
+	if err != nil {
+		return Plan{}, err
+	}
+	var chunks []string
+	for _, part := range GroupSections(ctx, sections) {
+		if _, ok := reusable[part.Hash]; !ok {
+			chunks = append(chunks, part.Chunks...)
+		}
+	}
+	return planChunks(ctx, content, sectionChunks(ctx, sections), chunks, prices)
+}
+
+// planChunks estimates the calls made with the settings in ctx for each of chunks, and the
+// summary of content, the whole document of allChunks, when it is not stored yet.
+func planChunks(ctx context.Context, content []byte, allChunks, chunks []string, prices usage.PriceTable) (Plan, error) {
+	plan := Plan{Model: string(ModelFromContext(ctx))}
+	summary, stored, err := planSummary(ctx, content, allChunks)
+	if err != nil {
+		return Plan{}, err
+	}
+	if !stored.IsZero() {
+		ctx = context.WithValue(ctx, documentSummaryKey{}, stored)
+	}
+	targeting := TargetingFromContext(ctx)
+	refinement := RefinementFromContext(ctx)
+	refine := StageEstimate{Name: "refine"}
+	grounding := GroundingFromContext(ctx)
+	judge := StageEstimate{Name: "judge"}
+	plan.Repairs = StageEstimate{Name: "repair"}
+
+	// a summary still to be written is sent with every chunk too
+	summaryTokens := 0
+	if SummaryPassFromContext(ctx) && stored.IsZero() {
+		summaryTokens = summaryOutputTokens
+	}
+	for i, chunk := range chunks {
+		chunkTokens := EstimateTokens(ctx, chunk)
+		words := len(strings.Fields(chunk))
+		// the chunk is sent with its card count, level mix and summary, like generateDeck sends it
+		text := summaryPrompt(ctx, targeting.prompt(chunk, targeting.target(words)))
+		estimate := ChunkEstimate{
+			Index:        i + 1,
+			Words:        words,
+			ChunkTokens:  chunkTokens,
+			InputTokens:  requestInputTokens(ctx, DefaultChatCompletionConfigs(text)) + summaryTokens,
+			OutputTokens: int(float64(chunkTokens) * DefaultOutputTokenRatio),
+			Prompt:       RenderPrompt(text),
+		}
+		plan.Chunks = append(plan.Chunks, estimate)
+		plan.InputTokens += estimate.InputTokens
+		plan.OutputTokens += estimate.OutputTokens
+
+		// every pass sends the notes and the cards back with the rubric
+		if refinement.Passes > 0 {
+			refine.Calls += refinement.Passes
+			refine.InputTokens += refinement.Passes * (EstimateTokens(ctx, DefaultRefinePrompt) + EstimateTokens(ctx, refinement.rubric()) + chunkTokens + estimate.OutputTokens)
+			refine.OutputTokens += refinement.Passes * estimate.OutputTokens
+		}
+		if grounding != nil && grounding.Policy != GroundingOff && grounding.Judge && chunkTokens <= ChunkBudget(plan.Model) {
+			judge.Calls++
+			judge.InputTokens += EstimateTokens(ctx, DefaultJudgePrompt) + chunkTokens + estimate.OutputTokens
+			judge.OutputTokens += judgeTokensPerCard * max(1, estimate.OutputTokens/estimatedCardTokens)
+		}
+		plan.Repairs.Calls += DefaultRepairAttempts
+		plan.Repairs.InputTokens += DefaultRepairAttempts * (estimate.InputTokens + estimate.OutputTokens + EstimateTokens(ctx, DefaultRepairPrompt))
+		plan.Repairs.OutputTokens += DefaultRepairAttempts * estimate.OutputTokens
+	}
+	for _, stage := range []StageEstimate{summary, refine, judge} {
+		if stage.Calls == 0 {
+			continue
+		}
+		plan.Stages = append(plan.Stages, stage)
+		plan.InputTokens += stage.InputTokens
+		plan.OutputTokens += stage.OutputTokens
+	}
+	plan.Cost, plan.Priced = prices.Cost(plan.Model, plan.InputTokens, plan.OutputTokens)
+	plan.MaxCost, _ = prices.Cost(plan.Model, plan.InputTokens+plan.Repairs.InputTokens, plan.OutputTokens+plan.Repairs.OutputTokens)
+	return plan, nil
+}
+
+// planSummary estimates the calls summarizing chunks, the whole document content, and merging
+// their summaries when the summary pass in ctx is on and content was not summarized before.
+// stored is the summary kept from before, if any.
+func planSummary(ctx context.Context, content []byte, chunks []string) (stage StageEstimate, stored DocumentSummary, err error) {
+	stage = StageEstimate{Name: "summary"}
+	if !SummaryPassFromContext(ctx) {
+		return stage, DocumentSummary{}, nil
+	}
+	dir, name, err := summaryPath(content)
+	if err != nil {
+		return StageEstimate{}, DocumentSummary{}, err
+	}
+	// a summary that cannot be read is written again, like summarizeContent does
+	if path := filepath.Join(dir, name); encryption.Exists(path) {
+		summary := DocumentSummary{}
+		if err := encryption.ReadJSONFile(path, &summary); err == nil {
+			return stage, summary, nil
+		}
+	}
+
+	for _, chunk := range chunks {
+		stage.Calls++
+		stage.InputTokens += EstimateTokens(ctx, DefaultSummaryPrompt) + EstimateTokens(ctx, chunk)
+		stage.OutputTokens += summaryOutputTokens
+	}
+	parts := make([]int, len(chunks))
+	for i := range parts {
+		parts[i] = summaryOutputTokens
+	}
+	for len(parts) > 1 {
+		merged := []int{}
+		for _, batch := range batchSizes(parts, ChunkBudget(string(ModelFromContext(ctx)))) {
+			if len(batch) == 1 {
+				merged = append(merged, batch[0])
+				continue
+			}
+			stage.Calls++
+			stage.InputTokens += EstimateTokens(ctx, DefaultSummaryMergePrompt)
+			for _, tokens := range batch {
+				stage.InputTokens += tokens
+			}
+			stage.OutputTokens += summaryOutputTokens
+			merged = append(merged, summaryOutputTokens)
+		}
+		parts = merged
+	}
+	return stage, DocumentSummary{}, nil
+}
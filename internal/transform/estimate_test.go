@@ -0,0 +1,119 @@
+package transform
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jaxxk/anki-cards-generator/internal/encryption"
+	"github.com/jaxxk/anki-cards-generator/internal/usage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanDocument(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	plan, err := PlanDocument(context.Background(), filepath.Join("testdata", "notes.md"), usage.DefaultPriceTable)
+	assert.NoError(t, err)
+	assert.Len(t, plan.Chunks, 2, "short sections of notes.md are sent together")
+	assert.Empty(t, plan.Stages, "no summary, refinement or judge without settings")
+	assert.True(t, plan.Priced)
+	assert.Greater(t, plan.Cost, 0.0)
+	assert.Equal(t, DefaultRepairAttempts*len(plan.Chunks), plan.Repairs.Calls)
+	assert.Greater(t, plan.MaxCost, plan.Cost, "repairs are only in the upper bound")
+
+	words := 0
+	for _, chunk := range plan.Chunks {
+		words += chunk.Words
+		assert.Greater(t, chunk.InputTokens, chunk.ChunkTokens, "input includes the prompt")
+		assert.Contains(t, chunk.Prompt, "[user]")
+	}
+	assert.Equal(t, 923, words, "every word but the --- separators")
+}
+
+func TestPlanDocumentStages(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	docPath := filepath.Join("testdata", "notes.md")
+	base, err := PlanDocument(context.Background(), docPath, usage.DefaultPriceTable)
+	assert.NoError(t, err)
+
+	ctx := WithRefinement(context.Background(), Refinement{Passes: 2})
+	ctx = WithGrounding(ctx, &Grounding{Policy: GroundingTag, Judge: true})
+	ctx = WithSummaryPass(ctx, true)
+	plan, err := PlanDocument(ctx, docPath, usage.DefaultPriceTable)
+	assert.NoError(t, err)
+
+	calls := map[string]int{}
+	for _, stage := range plan.Stages {
+		calls[stage.Name] = stage.Calls
+		assert.Greater(t, stage.InputTokens, 0)
+		assert.Greater(t, stage.OutputTokens, 0)
+	}
+	assert.Equal(t, map[string]int{"summary": 3, "refine": 4, "judge": 2}, calls, "2 chunk summaries and 1 merge, 2 passes per chunk, 1 judge per chunk")
+	assert.Greater(t, plan.InputTokens, base.InputTokens)
+	assert.Greater(t, plan.Chunks[0].InputTokens, base.Chunks[0].InputTokens, "the summary is sent with every chunk")
+	assert.Greater(t, plan.Cost, base.Cost)
+}
+
+func TestPlanDocumentTargeting(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	docPath := filepath.Join("testdata", "notes.md")
+	base, err := PlanDocument(context.Background(), docPath, usage.DefaultPriceTable)
+	assert.NoError(t, err)
+
+	targeting := &Targeting{Density: Density{MinCards: 3}, Mix: Mix{LevelRecall: 40, LevelApply: 60}}
+	plan, err := PlanDocument(WithTargeting(context.Background(), targeting), docPath, usage.DefaultPriceTable)
+	assert.NoError(t, err)
+	for i, chunk := range plan.Chunks {
+		assert.Contains(t, chunk.Prompt, "Write at least 3 flashcards.")
+		assert.Contains(t, chunk.Prompt, targeting.Mix.instruction())
+		assert.Greater(t, chunk.InputTokens, base.Chunks[i].InputTokens, "the instructions are counted")
+	}
+}
+
+func TestPlanDocumentStoredSummary(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	key, err := encryption.GenerateRandomKey()
+	assert.NoError(t, err)
+	t.Setenv(encryption.ENC_KEY, base64.StdEncoding.EncodeToString(key))
+	t.Setenv(encryption.ENCRYPT_AT_REST, "1")
+
+	docPath := filepath.Join("testdata", "notes.md")
+	ctx := WithSummaryPass(context.Background(), true)
+	unsummarized, err := PlanDocument(ctx, docPath, usage.DefaultPriceTable)
+	assert.NoError(t, err)
+	assert.Len(t, unsummarized.Stages, 1)
+
+	content, err := os.ReadFile(docPath)
+	assert.NoError(t, err)
+	dir, name, err := summaryPath(content)
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(dir, 0700))
+	summary := DocumentSummary{Outline: []string{"Channels connect goroutines."}, Glossary: []GlossaryEntry{}}
+	_, err = encryption.WriteJSONFile(summary, dir, name)
+	assert.NoError(t, err)
+
+	plan, err := PlanDocument(ctx, docPath, usage.DefaultPriceTable)
+	assert.NoError(t, err)
+	assert.Empty(t, plan.Stages, "the encrypted summary is found")
+	for i, chunk := range plan.Chunks {
+		assert.Contains(t, chunk.Prompt, "Channels connect goroutines.", "the stored summary is sent with every chunk")
+		assert.Less(t, chunk.InputTokens, unsummarized.Chunks[i].InputTokens, "the short summary is counted instead of a placeholder")
+	}
+}
+
+func TestRenderPrompt(t *testing.T) {
+	text := "Closing a channel signals that no more values will be sent."
+	structured := RenderPrompt(text)
+	assert.Equal(t, "[developer]\n"+strings.TrimSpace(DefaultPrompt)+"\n\n[user]\n"+text+"\n", structured)
+	structuredTokens := requestInputTokens(context.Background(), DefaultChatCompletionConfigs(text))
+
+	DefaultStructuredOutput = false
+	t.Cleanup(func() { DefaultStructuredOutput = true })
+	prompt := RenderPrompt(text)
+	assert.Contains(t, prompt, "follows this JSON schema", "the schema is shown when it is sent in a message")
+	assert.True(t, strings.HasSuffix(prompt, "[user]\n"+text+"\n"))
+	assert.InDelta(t, structuredTokens, requestInputTokens(context.Background(), DefaultChatCompletionConfigs(text)), float64(EstimateTokens(context.Background(), DefaultSchemaPrompt)), "the schema is counted either way")
+}
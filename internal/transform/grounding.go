@@ -0,0 +1,311 @@
+package transform
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/jaxxk/anki-cards-generator/pkg/logging"
+)
+
+// GroundingPolicy says what happens to cards whose back the notes do not support.
+type GroundingPolicy string
+
+const (
+	GroundingOff  GroundingPolicy = "off"
+	GroundingFlag GroundingPolicy = "flag"
+	GroundingTag  GroundingPolicy = "tag"
+	GroundingDrop GroundingPolicy = "drop"
+)
+
+// UNGROUNDED_TAG is added to unsupported cards by GroundingTag.
+var UNGROUNDED_TAG = "ungrounded"
+
+// DefaultMinOverlap is the share of a sentence's words that must appear in the notes.
+var DefaultMinOverlap = 0.5
+
+// minOverlapTerms is how many words a sentence needs before its overlap is checked, shorter
+// ones say too little to judge.
+const minOverlapTerms = 4
+
+// ParseGroundingPolicy parses off, flag, tag or drop.
+func ParseGroundingPolicy(s string) (GroundingPolicy, error) {
+	switch policy := GroundingPolicy(strings.ToLower(s)); policy {
+	case GroundingOff, GroundingFlag, GroundingTag, GroundingDrop:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown grounding policy %q, expected off, flag, tag or drop", s)
+}
+
+// UnsupportedClaim is a statement of a card's back the notes do not support.
+type UnsupportedClaim struct {
+	Claim  string
+	Reason string
+}
+
+// GroundingFinding is a card with unsupported claims and what was done to it.
+type GroundingFinding struct {
+	Card   Flashcards
+	Claims []UnsupportedClaim
+	Action GroundingPolicy
+}
+
+// GroundingReport lists the unsupported cards of a run.
+type GroundingReport struct {
+	Checked  int
+	Findings []GroundingFinding
+}
+
+// Summary describes the report in one line.
+func (r GroundingReport) Summary() string {
+	return fmt.Sprintf("%d of %d card(s) unsupported by the notes", len(r.Findings), r.Checked)
+}
+
+// Grounding checks generated cards against the notes they were generated from. Every key
+// term and number of a back must appear in the notes and most of its words must too. With
+// Judge set the model verifies the cards as well and its verdict decides, since it also
+// accepts paraphrases.
+type Grounding struct {
+	Policy GroundingPolicy
+	Judge  bool
+	// MinOverlap is the share of a sentence's words found in the notes, DefaultMinOverlap if zero
+	MinOverlap float64
+
+	mu     sync.Mutex
+	report GroundingReport
+}
+
+// Report returns the cards checked so far.
+func (g *Grounding) Report() GroundingReport {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return GroundingReport{Checked: g.report.Checked, Findings: append([]GroundingFinding{}, g.report.Findings...)}
+}
+
+type groundingKey struct{}
+
+// WithGrounding returns a context whose generated cards are checked by g.
+func WithGrounding(ctx context.Context, g *Grounding) context.Context {
+	return context.WithValue(ctx, groundingKey{}, g)
+}
+
+// GroundingFromContext returns the Grounding in ctx, or nil when cards are not checked.
+func GroundingFromContext(ctx context.Context) *Grounding {
+	g, _ := ctx.Value(groundingKey{}).(*Grounding)
+	return g
+}
+
+var (
+	codeFencePattern  = regexp.MustCompile("(?s)```.*?```")
+	inlineCodePattern = regexp.MustCompile("`([^`\n]+)`")
+	numberPattern     = regexp.MustCompile(`\d+(?:[.,]\d+)*`)
+	// dotted names and calls, snake_case, camelCase and CamelCase, and acronyms
+	identifierPattern = regexp.MustCompile(`\b(?:[A-Za-z_]\w*\.)+[A-Za-z_]\w*(?:\(\))?|\b\w+\(\)|\b[A-Za-z]\w*_\w+\b|\b[a-z]+[A-Z]\w*\b|\b[A-Z][a-z]+[A-Z]\w*\b|\b[A-Z]{2,}s?\b`)
+	sentenceEnd       = regexp.MustCompile(`[.!?](?:\s+|$)|\n+`)
+)
+
+// CheckGrounding returns the claims of card's back that source does not support: sentences
+// with numbers or key terms missing from source, or too few of their words in it. Fenced code
+// is left out, it illustrates rather than states.
+func CheckGrounding(card Flashcards, source string, minOverlap float64) []UnsupportedClaim {
+	if minOverlap <= 0 {
+		minOverlap = DefaultMinOverlap
+	}
+	lowerSource := strings.ToLower(source)
+	sourceNumbers := map[string]bool{}
+	for _, number := range numberPattern.FindAllString(source, -1) {
+		sourceNumbers[number] = true
+	}
+	sourceTerms := map[string]bool{}
+	for _, term := range questionTerms(source) {
+		sourceTerms[term] = true
+	}
+
+	var claims []UnsupportedClaim
+	back := codeFencePattern.ReplaceAllString(card.Back, "\n")
+	for _, sentence := range sentences(back) {
+		var missing []string
+		for _, number := range numberPattern.FindAllString(sentence, -1) {
+			if !sourceNumbers[number] {
+				missing = appendMissing(missing, number)
+			}
+		}
+		for _, term := range keyTerms(sentence) {
+			if !strings.Contains(lowerSource, strings.ToLower(strings.TrimSuffix(term, "()"))) {
+				missing = appendMissing(missing, term)
+			}
+		}
+		if len(missing) > 0 {
+			claims = append(claims, UnsupportedClaim{Claim: sentence, Reason: strings.Join(missing, ", ") + " not in the notes"})
+			continue
+		}
+
+		terms := distinctTerms(sentence)
+		if len(terms) < minOverlapTerms {
+			continue
+		}
+		found := 0
+		for _, term := range terms {
+			if sourceTerms[term] {
+				found++
+			}
+		}
+		if overlap := float64(found) / float64(len(terms)); overlap < minOverlap {
+			claims = append(claims, UnsupportedClaim{Claim: sentence, Reason: fmt.Sprintf("only %.0f%% of its words are in the notes", overlap*100)})
+		}
+	}
+	return claims
+}
+
+// sentences splits text after every sentence-ending punctuation mark and line break.
+func sentences(text string) []string {
+	var result []string
+	start := 0
+	for _, end := range sentenceEnd.FindAllStringIndex(text, -1) {
+		// keep the punctuation with its sentence
+		if sentence := strings.TrimSpace(text[start:end[1]]); sentence != "" {
+			result = append(result, sentence)
+		}
+		start = end[1]
+	}
+	if sentence := strings.TrimSpace(text[start:]); sentence != "" {
+		result = append(result, sentence)
+	}
+	return result
+}
+
+// keyTerms returns the inline code and identifier-like words of sentence.
+func keyTerms(sentence string) []string {
+	var terms []string
+	for _, match := range inlineCodePattern.FindAllStringSubmatch(sentence, -1) {
+		terms = append(terms, strings.TrimSpace(match[1]))
+	}
+	sentence = inlineCodePattern.ReplaceAllString(sentence, " ")
+	terms = append(terms, identifierPattern.FindAllString(sentence, -1)...)
+	return terms
+}
+
+// distinctTerms returns the distinct content terms of text, leaving out numbers.
+func distinctTerms(text string) []string {
+	seen := map[string]bool{}
+	var terms []string
+	for _, term := range questionTerms(text) {
+		if seen[term] || strings.IndexFunc(term, unicode.IsLetter) < 0 {
+			continue
+		}
+		seen[term] = true
+		terms = append(terms, term)
+	}
+	return terms
+}
+
+func appendMissing(missing []string, term string) []string {
+	for _, existing := range missing {
+		if existing == term {
+			return missing
+		}
+	}
+	return append(missing, term)
+}
+
+// groundCards checks the cards generated from source with the Grounding in ctx, if any, and
+// flags, tags or drops the unsupported ones by its policy.
+func groundCards(ctx context.Context, source string, cards []Flashcards) ([]Flashcards, error) {
+	g := GroundingFromContext(ctx)
+	if g == nil || g.Policy == GroundingOff || len(cards) == 0 {
+		return cards, nil
+	}
+	logger := logging.FromContext(ctx)
+
+	claims := make([][]UnsupportedClaim, len(cards))
+	for i, card := range cards {
+		claims[i] = CheckGrounding(card, source, g.MinOverlap)
+	}
+	if g.Judge {
+		verdicts, err := judgeCards(ctx, source, cards)
+		if err != nil {
+			return nil, err
+		}
+		if verdicts != nil {
+			for i := range cards {
+				verdict, ok := verdicts[i+1]
+				switch {
+				case !ok:
+					// keep the lexical check for cards the judge skipped
+				case verdict.Supported:
+					claims[i] = nil
+				default:
+					judged := make([]UnsupportedClaim, 0, len(verdict.UnsupportedClaims)+len(claims[i]))
+					for _, claim := range verdict.UnsupportedClaims {
+						judged = append(judged, UnsupportedClaim{Claim: claim, Reason: "rejected by the judge"})
+					}
+					if len(judged) == 0 {
+						judged = append(judged, UnsupportedClaim{Claim: cards[i].Back, Reason: "rejected by the judge"})
+					}
+					claims[i] = append(judged, claims[i]...)
+				}
+			}
+		}
+	}
+
+	kept := make([]Flashcards, 0, len(cards))
+	var findings []GroundingFinding
+	for i, card := range cards {
+		if len(claims[i]) == 0 {
+			kept = append(kept, card)
+			continue
+		}
+		logger.Debugf("Card %q is not supported by its notes: %v", card.Front, claims[i])
+		switch g.Policy {
+		case GroundingTag:
+			card.Tags = mergeTags(card.Tags, []string{UNGROUNDED_TAG})
+			kept = append(kept, card)
+		case GroundingFlag:
+			kept = append(kept, card)
+		}
+		findings = append(findings, GroundingFinding{Card: card, Claims: claims[i], Action: g.Policy})
+	}
+
+	g.mu.Lock()
+	g.report.Checked += len(cards)
+	g.report.Findings = append(g.report.Findings, findings...)
+	g.mu.Unlock()
+	return kept, nil
+}
+
+// judgePrompt lists the notes and the numbered cards to verify.
+func judgePrompt(source string, cards []Flashcards) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Notes:\n%s\n\nFlashcards:\n", source)
+	for i, card := range cards {
+		fmt.Fprintf(&sb, "Card %d\nFront: %s\nBack: %s\n\n", i+1, card.Front, card.Back)
+	}
+	return sb.String()
+}
+
+// judgeCards asks the model whether source supports cards, returning the verdicts by card
+// number. Verdicts are cached like decks. Sources too long for one request are not judged
+// and return nil.
+func judgeCards(ctx context.Context, source string, cards []Flashcards) (map[int]Verdict, error) {
+	logger := logging.FromContext(ctx)
+	if EstimateTokens(ctx, source) > ChunkBudget(string(ModelFromContext(ctx))) {
+		logger.Warnf("Notes are too long to judge in one request, keeping the lexical grounding check")
+		return nil, nil
+	}
+
+	prompt := judgePrompt(source, cards)
+	response := Verdicts{}
+	inputTokens := EstimateTokens(ctx, DefaultJudgePrompt) + EstimateTokens(ctx, prompt)
+	if _, err := cachedCompletion(ctx, "judge/v1", judgeChatCompletionConfigs(prompt), inputTokens, 50*len(cards), &response); err != nil {
+		return nil, fmt.Errorf("failed to judge flashcards: %w", err)
+	}
+
+	verdicts := map[int]Verdict{}
+	for _, verdict := range response.Verdicts {
+		verdicts[verdict.Card] = verdict
+	}
+	return verdicts, nil
+}
@@ -0,0 +1,112 @@
+package transform
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const groundingNotes = `A buffered channel has a capacity. Sends block only when the buffer is full.
+Since Go 1.22 each iteration of a loop has its own variable. Use sync.WaitGroup to wait for goroutines.`
+
+func TestCheckGrounding(t *testing.T) {
+	tests := []struct {
+		name   string
+		back   string
+		claims []string
+	}{
+		{"quoted", "Sends block only when the buffer is full.", nil},
+		{"paraphrased", "Sending blocks only if the buffer of the channel is full.", nil},
+		{"known identifier", "Wait for goroutines with sync.WaitGroup.", nil},
+		{"code is left out", "For example:\n```go\nch := make(chan int, 3)\n```", nil},
+		{"missing number", "Since Go 1.21 each iteration has its own variable.", []string{"1.21 not in the notes"}},
+		{"missing identifier", "Use errgroup.Group to wait for goroutines.", []string{"errgroup.Group not in the notes"}},
+		{"missing inline code", "Call `runtime.Gosched` to yield.", []string{"runtime.Gosched not in the notes"}},
+		{"missing acronym", "Buffered channels are a FIFO queue.", []string{"FIFO not in the notes"}},
+		{"unrelated words", "The garbage collector pauses every program for several milliseconds.", []string{"only 0% of its words are in the notes"}},
+		{"one claim of two", "A buffered channel has a capacity. Its default capacity is 64.", []string{"64 not in the notes"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := CheckGrounding(Flashcards{Front: "What about channels?", Back: tt.back}, groundingNotes, 0)
+			var reasons []string
+			for _, claim := range claims {
+				reasons = append(reasons, claim.Reason)
+			}
+			assert.Equal(t, tt.claims, reasons)
+		})
+	}
+}
+
+func TestParseGroundingPolicy(t *testing.T) {
+	policy, err := ParseGroundingPolicy("Drop")
+	assert.NoError(t, err)
+	assert.Equal(t, GroundingDrop, policy)
+
+	_, err = ParseGroundingPolicy("ignore")
+	assert.ErrorContains(t, err, "unknown grounding policy")
+}
+
+func TestGroundCardsPolicies(t *testing.T) {
+	cards := []Flashcards{
+		{Front: "When do sends block?", Back: "Sends block only when the buffer is full."},
+		{Front: "What is the default capacity?", Back: "The default capacity is 64.", Tags: []string{"go"}},
+	}
+
+	kept, err := groundCards(context.Background(), groundingNotes, cards)
+	assert.NoError(t, err)
+	assert.Equal(t, cards, kept, "cards are not checked without a Grounding")
+
+	tests := []struct {
+		policy GroundingPolicy
+		kept   []Flashcards
+	}{
+		{GroundingFlag, cards},
+		{GroundingTag, []Flashcards{cards[0], {Front: cards[1].Front, Back: cards[1].Back, Tags: []string{"go", UNGROUNDED_TAG}}}},
+		{GroundingDrop, cards[:1]},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			grounding := &Grounding{Policy: tt.policy}
+			kept, err := groundCards(WithGrounding(context.Background(), grounding), groundingNotes, cards)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.kept, kept)
+
+			report := grounding.Report()
+			assert.Equal(t, 2, report.Checked)
+			if assert.Len(t, report.Findings, 1) {
+				assert.Equal(t, cards[1].Front, report.Findings[0].Card.Front)
+				assert.Equal(t, tt.policy, report.Findings[0].Action)
+				assert.Equal(t, []UnsupportedClaim{{Claim: "The default capacity is 64.", Reason: "64 not in the notes"}}, report.Findings[0].Claims)
+			}
+		})
+	}
+
+	grounding := &Grounding{Policy: GroundingOff}
+	kept, err = groundCards(WithGrounding(context.Background(), grounding), groundingNotes, cards)
+	assert.NoError(t, err)
+	assert.Equal(t, cards, kept)
+	assert.Zero(t, grounding.Report().Checked)
+}
+
+func TestGroundCardsJudgeReplay(t *testing.T) {
+	useCassette(t, "grounding")
+
+	cards := []Flashcards{
+		{Front: "When do sends block?", Back: "Sends block only when the buffer is full."},
+		// every word is in the notes, but the judge knows better
+		{Front: "When do sends block?", Back: "Sends block only when the buffer is empty."},
+	}
+	grounding := &Grounding{Policy: GroundingDrop, Judge: true}
+	kept, err := groundCards(WithGrounding(context.Background(), grounding), groundingNotes, cards)
+	assert.NoError(t, err)
+	assert.Equal(t, cards[:1], kept)
+	assert.Empty(t, CheckGrounding(cards[1], groundingNotes, 0))
+
+	report := grounding.Report()
+	if assert.Len(t, report.Findings, 1) {
+		assert.Equal(t, []UnsupportedClaim{{Claim: "Sends block only when the buffer is empty.", Reason: "rejected by the judge"}}, report.Findings[0].Claims)
+	}
+	assert.Equal(t, "1 of 2 card(s) unsupported by the notes", report.Summary())
+}
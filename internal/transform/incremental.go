@@ -0,0 +1,255 @@
+package transform
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jaxxk/anki-cards-generator/internal/encryption"
+	"github.com/jaxxk/anki-cards-generator/pkg/logging"
+	"github.com/jaxxk/anki-cards-generator/pkg/utils"
+)
+
+// MANIFEST_DIR holds, for every source file, the sections and cards of its last run.
+var MANIFEST_DIR = "manifests"
+
+// Manifest is the output of the last run on a source file, section by section.
+type Manifest struct {
+	Source string `json:"source"`
+	Title  string `json:"title"`
+	// Settings identifies the model, prompt and parameters the cards were generated with
+	Settings  string          `json:"settings"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+	Sections  []SectionRecord `json:"sections"`
+}
+
+// SectionRecord holds the cards generated for one part, a run of one or more sections.
+type SectionRecord struct {
+	// Hash is the Part's hash, the hash of its section when it has only one
+	Hash string `json:"hash"`
+	// Heading is the heading of the part's first section
+	Heading string       `json:"heading,omitempty"`
+	Cards   []Flashcards `json:"cards"`
+}
+
+// Cards returns the cards of every section, in document order.
+func (m Manifest) Cards() []Flashcards {
+	cards := []Flashcards{}
+	for _, section := range m.Sections {
+		cards = append(cards, section.Cards...)
+	}
+	return cards
+}
+
+// manifestPath returns where the manifest of source is kept, named after its absolute path.
+func manifestPath(source string) (string, string, error) {
+	processingPath, err := utils.CreateProcessingDir()
+	if err != nil {
+		return "", "", err
+	}
+	absolute, err := filepath.Abs(source)
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(absolute))
+	return filepath.Join(processingPath, MANIFEST_DIR), hex.EncodeToString(sum[:8]) + ".json", nil
+}
+
+// LoadManifest returns the manifest of the last run on source. ok is false if there was none.
+func LoadManifest(source string) (manifest Manifest, ok bool, err error) {
+	dir, name, err := manifestPath(source)
+	if err != nil {
+		return Manifest{}, false, err
+	}
+	if err := encryption.ReadJSONFile(filepath.Join(dir, name), &manifest); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return Manifest{}, false, nil
+		}
+		return Manifest{}, false, fmt.Errorf("failed to load manifest of %s: %w", source, err)
+	}
+	return manifest, true, nil
+}
+
+// SaveManifest stores manifest as the last run on its source.
+func SaveManifest(manifest Manifest) error {
+	dir, name, err := manifestPath(manifest.Source)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create manifest directory: %w", err)
+	}
+	if _, err := encryption.WriteJSONFile(manifest, dir, name); err != nil {
+		return fmt.Errorf("failed to save manifest of %s: %w", manifest.Source, err)
+	}
+	return nil
+}
+
+// generationSettings identifies everything but the notes that shapes generated cards, so
+// cards are not reused after switching model, prompt, refinement, density or summary pass.
+func generationSettings(ctx context.Context) (string, error) {
+	settings, err := deckCacheKey(ctx, "")
+	if err != nil {
+		return "", err
+	}
+	refinement, err := refinementSettings(ctx)
+	if err != nil {
+		return "", err
+	}
+	summary, err := summarySettings(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, extra := range []string{refinement, densitySettings(ctx), summary} {
+		if extra != "" {
+			settings += "+" + extra
+		}
+	}
+	return settings, nil
+}
+
+// DeckDiff compares the cards of two runs. Cards are matched by their front.
+type DeckDiff struct {
+	Added   []Flashcards
+	Changed []Flashcards
+	Removed []Flashcards
+	// ReusedSections and GeneratedSections count sections taken from the last run or sent to the model
+	ReusedSections    int
+	GeneratedSections int
+}
+
+// DiffCards compares the cards of the last run with the new ones.
+func DiffCards(previous, current []Flashcards) DeckDiff {
+	diff := DeckDiff{}
+	previousBacks := map[string]string{}
+	for _, card := range previous {
+		previousBacks[normalizeFront(card.Front)] = card.Back
+	}
+	currentFronts := map[string]bool{}
+	for _, card := range current {
+		front := normalizeFront(card.Front)
+		currentFronts[front] = true
+		back, ok := previousBacks[front]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, card)
+		case back != card.Back:
+			diff.Changed = append(diff.Changed, card)
+		}
+	}
+	for _, card := range previous {
+		if !currentFronts[normalizeFront(card.Front)] {
+			diff.Removed = append(diff.Removed, card)
+		}
+	}
+	return diff
+}
+
+func normalizeFront(front string) string {
+	return strings.ToLower(strings.Join(strings.Fields(front), " "))
+}
+
+// Summary describes the diff in one line.
+func (d DeckDiff) Summary() string {
+	return fmt.Sprintf("%d added, %d changed, %d removed (%d section(s) reused, %d generated)",
+		len(d.Added), len(d.Changed), len(d.Removed), d.ReusedSections, d.GeneratedSections)
+}
+
+// reusableRecords returns the manifest of the last run on docPath, its records by part hash
+// and the generation settings in ctx. No record is reusable when full is set or the last run
+// used other settings.
+func reusableRecords(ctx context.Context, docPath string, full bool) (Manifest, map[string]SectionRecord, string, error) {
+	settings, err := generationSettings(ctx)
+	if err != nil {
+		return Manifest{}, nil, "", err
+	}
+	previous, found, err := LoadManifest(docPath)
+	if err != nil {
+		// a broken manifest only costs a full run
+		logging.FromContext(ctx).Warnf("Ignoring the last run on %s: %v", docPath, err)
+		previous, found = Manifest{}, false
+	}
+	reusable := map[string]SectionRecord{}
+	if found && !full && previous.Settings == settings {
+		for _, record := range previous.Sections {
+			reusable[record.Hash] = record
+		}
+	}
+	return previous, reusable, settings, nil
+}
+
+// TransformNoteIncremental generates a deck from the notes at docPath, sending only parts with
+// new or modified sections to the model and reusing the cards of parts unchanged since the
+// last run on the same file. With full set, every part is sent. The run is saved as the file's
+// manifest and its cards are compared with the last run's.
+func TransformNoteIncremental(ctx context.Context, docPath string, full bool) (Deck, DeckDiff, error) {
+	sections, err := SplitSections(ctx, docPath)
+	if err != nil {
+		return Deck{}, DeckDiff{}, err
+	}
+	parts := GroupSections(ctx, sections)
+	previous, reusable, settings, err := reusableRecords(ctx, docPath, full)
+	if err != nil {
+		return Deck{}, DeckDiff{}, err
+	}
+
+	ctx, err = withSummaryFor(withRunFor(ctx, docPath), docPath)
+	if err != nil {
+		return Deck{}, DeckDiff{}, err
+	}
+	manifest := Manifest{Source: docPath, Title: previous.Title, Settings: settings}
+	diff := DeckDiff{}
+	for _, part := range parts {
+		heading := part.Sections[0].Heading
+		if record, ok := reusable[part.Hash]; ok {
+			record.Heading = heading
+			TargetingFromContext(ctx).count(len(strings.Fields(part.Text)))
+			manifest.Sections = append(manifest.Sections, record)
+			diff.ReusedSections += len(part.Sections)
+			continue
+		}
+
+		record := SectionRecord{Hash: part.Hash, Heading: heading, Cards: []Flashcards{}}
+		for _, chunk := range part.Chunks {
+			if err := ctx.Err(); err != nil {
+				return Deck{}, DeckDiff{}, err
+			}
+			deck, err := generateDeck(ctx, chunk, len(strings.Fields(chunk)))
+			if err != nil {
+				return Deck{}, DeckDiff{}, fmt.Errorf("failed to create deck: %w", err)
+			}
+			if manifest.Title == "" {
+				manifest.Title = deck.Title
+			}
+			record.Cards = append(record.Cards, deck.Cards...)
+		}
+		manifest.Sections = append(manifest.Sections, record)
+		diff.GeneratedSections += len(part.Sections)
+	}
+
+	manifest.UpdatedAt = time.Now().UTC()
+	if err := SaveManifest(manifest); err != nil {
+		return Deck{}, DeckDiff{}, err
+	}
+
+	// the manifest keeps the cards as generated, so reused parts are checked again
+	cards := []Flashcards{}
+	for i, record := range manifest.Sections {
+		grounded, err := groundCards(ctx, parts[i].Text, record.Cards)
+		if err != nil {
+			return Deck{}, DeckDiff{}, err
+		}
+		cards = append(cards, grounded...)
+	}
+	cards = dedupCards(ctx, cards)
+	previousCards, _ := Dedup(previous.Cards(), DedupOptions{})
+	changes := DiffCards(previousCards, cards)
+	diff.Added, diff.Changed, diff.Removed = changes.Added, changes.Changed, changes.Removed
+	return Deck{Title: manifest.Title, Cards: cards}, diff, nil
+}
@@ -0,0 +1,84 @@
+package transform
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jaxxk/anki-cards-generator/internal/usage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransformNoteIncremental(t *testing.T) {
+	useCassette(t, "incremental")
+	notes, err := os.ReadFile(filepath.Join("testdata", "notes.md"))
+	assert.NoError(t, err)
+	docPath := filepath.Join(t.TempDir(), "notes.md")
+	assert.NoError(t, os.WriteFile(docPath, notes, 0644))
+	calls := func() int {
+		entries, err := usage.Load()
+		assert.NoError(t, err)
+		return len(entries)
+	}
+
+	plan := func(full bool) int {
+		plan, err := PlanChanges(context.Background(), docPath, full, usage.DefaultPriceTable)
+		assert.NoError(t, err)
+		return len(plan.Chunks)
+	}
+	assert.Equal(t, 2, plan(false), "without a last run every part is estimated")
+
+	// the first run generates every part: goroutines and channels together, then synchronization
+	first, diff, err := TransformNoteIncremental(context.Background(), docPath, false)
+	assert.NoError(t, err)
+	assert.Equal(t, "Fake Deck", first.Title)
+	assert.Len(t, first.Cards, 6)
+	assert.Len(t, diff.Added, 6)
+	assert.Equal(t, 3, diff.GeneratedSections)
+	assert.Equal(t, 2, calls())
+
+	// editing one section only sends its part
+	edited := strings.Replace(string(notes), "A goroutine is a function executing", "A goroutine is a cheap function executing", 1)
+	assert.NotEqual(t, string(notes), edited, "the edit must hit the notes")
+	assert.Equal(t, 0, plan(false))
+	assert.NoError(t, os.WriteFile(docPath, []byte(edited), 0644))
+	assert.Equal(t, 1, plan(false), "only the edited part is estimated")
+	assert.Equal(t, 2, plan(true))
+	second, diff, err := TransformNoteIncremental(context.Background(), docPath, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls())
+	assert.Equal(t, 1, diff.ReusedSections)
+	assert.Equal(t, 2, diff.GeneratedSections)
+	assert.Len(t, second.Cards, 6)
+	assert.Equal(t, first.Cards[3:], second.Cards[3:], "unchanged parts keep their cards")
+	assert.NotEmpty(t, append(diff.Added, diff.Changed...))
+
+	// dropping a section removes its cards without a call
+	withoutLast := edited[:strings.Index(edited, "## Synchronization")]
+	assert.NoError(t, os.WriteFile(docPath, []byte(withoutLast), 0644))
+	third, diff, err := TransformNoteIncremental(context.Background(), docPath, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls())
+	assert.Len(t, third.Cards, 3)
+	assert.Len(t, diff.Removed, 3)
+	assert.Empty(t, diff.Added)
+}
+
+func TestDiffCards(t *testing.T) {
+	previous := []Flashcards{
+		{Front: "What is a goroutine?", Back: "A lightweight thread."},
+		{Front: "What is a channel?", Back: "A conduit."},
+	}
+	current := []Flashcards{
+		{Front: "what is a  goroutine?", Back: "A lightweight thread."},
+		{Front: "What is a channel?", Back: "A typed conduit."},
+		{Front: "What is a mutex?", Back: "A lock."},
+	}
+	diff := DiffCards(previous, current)
+	assert.Equal(t, []Flashcards{current[2]}, diff.Added)
+	assert.Equal(t, []Flashcards{current[1]}, diff.Changed)
+	assert.Empty(t, diff.Removed)
+	assert.Equal(t, []Flashcards{previous[1]}, DiffCards(previous, current[:1]).Removed)
+}
@@ -0,0 +1,64 @@
+package transform
+
+import (
+	"context"
+
+	"github.com/jaxxk/anki-cards-generator/pkg/tokens"
+)
+
+// DefaultChunkTokens is the target size of the notes sent in one request. Smaller chunks
+// give the model fewer facts to cover at once, which keeps cards specific.
+var DefaultChunkTokens = 1000
+
+// ModelLimits are the token limits of a model.
+type ModelLimits struct {
+	ContextWindow int
+	MaxOutput     int
+}
+
+// DefaultModelLimits holds the limits of the models poggers is used with. Unknown models get
+// fallbackLimits.
+var DefaultModelLimits = map[string]ModelLimits{
+	"gpt-4o-mini":   {ContextWindow: 128000, MaxOutput: 16384},
+	"gpt-4o":        {ContextWindow: 128000, MaxOutput: 16384},
+	"gpt-4.1":       {ContextWindow: 1047576, MaxOutput: 32768},
+	"gpt-4.1-mini":  {ContextWindow: 1047576, MaxOutput: 32768},
+	"gpt-4.1-nano":  {ContextWindow: 1047576, MaxOutput: 32768},
+	"o3-mini":       {ContextWindow: 200000, MaxOutput: 100000},
+	"gpt-3.5-turbo": {ContextWindow: 16385, MaxOutput: 4096},
+}
+
+var fallbackLimits = ModelLimits{ContextWindow: 16385, MaxOutput: 4096}
+
+// LimitsFor returns the limits of model.
+func LimitsFor(model string) ModelLimits {
+	if limits, ok := DefaultModelLimits[model]; ok {
+		return limits
+	}
+	return fallbackLimits
+}
+
+// ChunkBudget returns how many tokens of notes fit in one request to model: at most
+// DefaultChunkTokens, and little enough that the prompt, the chunk and the cards expected
+// back (DefaultOutputTokenRatio per chunk token) stay within the model's limits.
+func ChunkBudget(model string) int {
+	limits := LimitsFor(model)
+	budget := DefaultChunkTokens
+	if fits := int(float64(limits.ContextWindow-promptOverheadTokens(model)) / (1 + DefaultOutputTokenRatio)); fits < budget {
+		budget = fits
+	}
+	if fits := int(float64(limits.MaxOutput) / DefaultOutputTokenRatio); fits < budget {
+		budget = fits
+	}
+	return max(budget, 1)
+}
+
+// EstimateTokens counts the tokens of text in the encoding of the model in ctx.
+func EstimateTokens(ctx context.Context, text string) int {
+	return modelEncoding(ctx).Count(text)
+}
+
+// modelEncoding returns the encoding of the model in ctx.
+func modelEncoding(ctx context.Context) *tokens.Encoding {
+	return tokens.ForModel(string(ModelFromContext(ctx)))
+}
@@ -0,0 +1,335 @@
+// Package llmtest provides a scripted fake of the OpenAI chat completions API. It generates
+// the cassettes under testdata without a real API key, and serves tests that need a live
+// endpoint to talk to. The checked-in cassettes therefore hold the fake's scripted answers,
+// not exchanges with a real model: they pin the requests poggers sends and how it handles
+// the answers, not what a model would write.
+package llmtest
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/jaxxk/anki-cards-generator/internal/encryption"
+	"github.com/jaxxk/anki-cards-generator/internal/transform/cassette"
+)
+
+// Message is a chat message as sent by the client.
+type Message struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// Text returns the message content whether it was sent as a string or as text parts.
+func (m Message) Text() string {
+	var text string
+	if err := json.Unmarshal(m.Content, &text); err == nil {
+		return text
+	}
+	var parts []struct {
+		Text string `json:"text"`
+	}
+	json.Unmarshal(m.Content, &parts)
+	texts := make([]string, len(parts))
+	for i, part := range parts {
+		texts[i] = part.Text
+	}
+	return strings.Join(texts, "")
+}
+
+// Request is the part of a chat completion request the fake looks at.
+type Request struct {
+	Model          string    `json:"model"`
+	Messages       []Message `json:"messages"`
+	ResponseFormat struct {
+		JSONSchema struct {
+			Name string `json:"name"`
+		} `json:"json_schema"`
+	} `json:"response_format"`
+}
+
+// SchemaName returns the name of the JSON schema the response must follow.
+func (r Request) SchemaName() string {
+	return r.ResponseFormat.JSONSchema.Name
+}
+
+// LastUserMessage returns the text of the last user message.
+func (r Request) LastUserMessage() string {
+	for i := len(r.Messages) - 1; i >= 0; i-- {
+		if r.Messages[i].Role == "user" {
+			return r.Messages[i].Text()
+		}
+	}
+	return ""
+}
+
+// Responder returns the assistant content for a request.
+type Responder func(req Request) string
+
+// Server is a fake chat completions endpoint.
+type Server struct {
+	URL string
+
+	mu        sync.Mutex
+	responder Responder
+	requests  []Request
+}
+
+// Start serves a fake until the test ends. Its URL can be used as the OpenAI base URL.
+// A nil responder answers with DefaultResponder.
+func Start(tb testing.TB, responder Responder) *Server {
+	tb.Helper()
+	if responder == nil {
+		responder = DefaultResponder
+	}
+	server := &Server{responder: responder}
+	httpServer := httptest.NewServer(http.HandlerFunc(server.handle))
+	tb.Cleanup(httpServer.Close)
+	server.URL = httpServer.URL
+	return server
+}
+
+// Requests returns every request received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request{}, s.requests...)
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, "/chat/completions") {
+		http.NotFound(w, r)
+		return
+	}
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	index := len(s.requests)
+	s.mu.Unlock()
+
+	content := s.responder(req)
+	promptTokens := 0
+	for _, message := range req.Messages {
+		promptTokens += approxTokens(message.Text())
+	}
+	completionTokens := approxTokens(content)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      fmt.Sprintf("chatcmpl-fake-%d", index),
+		"object":  "chat.completion",
+		"created": 1700000000,
+		"model":   req.Model,
+		"choices": []map[string]interface{}{{
+			"index":         0,
+			"message":       map[string]string{"role": "assistant", "content": content},
+			"finish_reason": "stop",
+		}},
+		"usage": map[string]int{
+			"prompt_tokens":     promptTokens,
+			"completion_tokens": completionTokens,
+			"total_tokens":      promptTokens + completionTokens,
+		},
+	})
+}
+
+// approxTokens estimates tokens the way OpenAI's rule of thumb does, one per four characters.
+func approxTokens(text string) int {
+	return (len(text) + 3) / 4
+}
+
+// DefaultResponder answers grounding checks with VerdictResponder, refinement passes with
+// RefineResponder and everything else with DeckResponder.
+func DefaultResponder(req Request) string {
+	switch {
+	case req.SchemaName() == "verdicts":
+		return VerdictResponder(req)
+	case req.SchemaName() == "summary":
+		return SummaryResponder(req)
+	case strings.HasPrefix(req.LastUserMessage(), "Rubric:\n"):
+		return RefineResponder(req)
+	}
+	return DeckResponder(req)
+}
+
+// RefineResponder answers a refinement pass with the deck it was given, finding nothing to
+// revise.
+func RefineResponder(req Request) string {
+	_, deck, _ := strings.Cut(req.LastUserMessage(), "\n\nFlashcards:\n")
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(deck)); err != nil {
+		return `{"Title":"Fake Deck","cards":[]}`
+	}
+	return compact.String()
+}
+
+// VerdictResponder judges every card of a grounding check supported when its back is quoted
+// word for word from the notes.
+func VerdictResponder(req Request) string {
+	type verdict struct {
+		Card              int      `json:"card"`
+		Supported         bool     `json:"supported"`
+		UnsupportedClaims []string `json:"unsupported_claims"`
+	}
+	response := struct {
+		Verdicts []verdict `json:"verdicts"`
+	}{Verdicts: []verdict{}}
+
+	notes, cards, _ := strings.Cut(req.LastUserMessage(), "\nFlashcards:\n")
+	notes = strings.Join(strings.Fields(notes), " ")
+	for i, card := range strings.Split("\n"+cards, "\nCard ")[1:] {
+		_, back, _ := strings.Cut(card, "\nBack: ")
+		back = strings.Join(strings.Fields(back), " ")
+		supported := strings.Contains(notes, back)
+		claims := []string{}
+		if !supported {
+			claims = append(claims, back)
+		}
+		response.Verdicts = append(response.Verdicts, verdict{Card: i + 1, Supported: supported, UnsupportedClaims: claims})
+	}
+	content, _ := json.Marshal(response)
+	return string(content)
+}
+
+// SummaryResponder summarizes notes with one outline point per sentence, up to three, and a
+// glossary entry naming each point by its first two words. Merging summaries, asked for with
+// "Part 1:", concatenates their outlines and glossaries.
+func SummaryResponder(req Request) string {
+	type entry struct {
+		Term       string `json:"term"`
+		Definition string `json:"definition"`
+	}
+	summary := struct {
+		Outline  []string `json:"outline"`
+		Glossary []entry  `json:"glossary"`
+	}{Outline: []string{}, Glossary: []entry{}}
+
+	text := req.LastUserMessage()
+	if strings.HasPrefix(text, "Part 1:\n") {
+		glossary := false
+		for _, line := range strings.Split(text, "\n") {
+			switch {
+			case line == "Outline:":
+				glossary = false
+			case line == "Glossary:":
+				glossary = true
+			case !strings.HasPrefix(line, "- "):
+			case glossary:
+				term, definition, _ := strings.Cut(strings.TrimPrefix(line, "- "), ": ")
+				summary.Glossary = append(summary.Glossary, entry{Term: term, Definition: definition})
+			default:
+				summary.Outline = append(summary.Outline, strings.TrimPrefix(line, "- "))
+			}
+		}
+	} else {
+		for _, sentence := range strings.Split(text, ".") {
+			words := strings.Fields(sentence)
+			if len(words) < 3 {
+				continue
+			}
+			point := strings.Join(words, " ") + "."
+			summary.Outline = append(summary.Outline, point)
+			summary.Glossary = append(summary.Glossary, entry{Term: strings.Join(words[:2], " "), Definition: point})
+			if len(summary.Outline) == 3 {
+				break
+			}
+		}
+	}
+	content, _ := json.Marshal(summary)
+	return string(content)
+}
+
+// deckLevels are the levels DeckResponder gives its cards, in order.
+var deckLevels = []string{"recall", "apply", "analyze"}
+
+// DeckResponder answers with a deck holding one card per sentence of the user message, up to
+// three, at the levels recall, apply and analyze and difficulties 2, 3 and 4.
+func DeckResponder(req Request) string {
+	type card struct {
+		Front      string `json:"front"`
+		Back       string `json:"back"`
+		Difficulty int    `json:"difficulty"`
+		Level      string `json:"level"`
+	}
+	deck := struct {
+		Title string `json:"Title"`
+		Cards []card `json:"cards"`
+	}{Title: "Fake Deck"}
+
+	for _, sentence := range strings.Split(req.LastUserMessage(), ".") {
+		words := strings.Fields(sentence)
+		if len(words) < 3 {
+			continue
+		}
+		deck.Cards = append(deck.Cards, card{
+			Front:      fmt.Sprintf("What does the note say about %s?", strings.Join(words[:3], " ")),
+			Back:       strings.Join(words, " ") + ".",
+			Difficulty: len(deck.Cards) + 2,
+			Level:      deckLevels[len(deck.Cards)],
+		})
+		if len(deck.Cards) == 3 {
+			break
+		}
+	}
+	content, _ := json.Marshal(deck)
+	return string(content)
+}
+
+// UseCassette points poggers at the cassette at path for the rest of the test, with HOME moved
+// to a temporary directory. The cassette is replayed from its start unless record is set, in
+// which case it is regenerated from a fresh Start(tb, nil) fake so no real API key is needed.
+func UseCassette(tb testing.TB, path string, record bool) {
+	tb.Helper()
+	UseCassetteWith(tb, path, record, nil)
+}
+
+// UseCassetteWith is UseCassette recording the answers of responder.
+func UseCassetteWith(tb testing.TB, path string, record bool, responder Responder) {
+	tb.Helper()
+	tb.Setenv("HOME", tb.TempDir())
+	tb.Setenv(encryption.CREDENTIAL_HELPER, "")
+	// the same variable OpenAI SDKs use, read by the transform package
+	tb.Setenv("OPENAI_BASE_URL", "")
+	tb.Setenv(cassette.PATH_ENV, path)
+	cassette.Close(path)
+	tb.Cleanup(func() { cassette.Close(path) })
+	if !record {
+		tb.Setenv(cassette.MODE_ENV, string(cassette.ModeReplay))
+		return
+	}
+
+	tb.Setenv(cassette.MODE_ENV, string(cassette.ModeRecord))
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		tb.Fatalf("failed to remove old cassette: %v", err)
+	}
+	server := Start(tb, responder)
+	tb.Setenv("OPENAI_BASE_URL", server.URL+"/v1/")
+
+	// recording goes through the regular key lookup, so store a fake key
+	key, err := encryption.GenerateRandomKey()
+	if err != nil {
+		tb.Fatalf("failed to generate encryption key: %v", err)
+	}
+	tb.Setenv(encryption.ENC_KEY, base64.StdEncoding.EncodeToString(key))
+	store, err := encryption.LoadStore()
+	if err == nil {
+		err = store.Add(encryption.OpenAICredential, "sk-fake")
+	}
+	if err == nil {
+		err = store.Save()
+	}
+	if err != nil {
+		tb.Fatalf("failed to store fake API key: %v", err)
+	}
+}
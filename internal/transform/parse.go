@@ -0,0 +1,196 @@
+package transform
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+var fencedBlockPattern = regexp.MustCompile("(?s)```[A-Za-z0-9_-]*[ \t]*\n?(.*?)```")
+
+// ParseDeck parses the deck in a model's answer. It tolerates what models without strict
+// structured output do: JSON wrapped in prose or code fences, trailing commas, lowercase
+// keys, "flashcards" instead of "cards", and a bare array of cards instead of a deck. Levels
+// are matched case-insensitively and dropped when they are not Bloom levels, and difficulties,
+// numbers or numeric strings, are brought within 1 to 5, 0 when they are below.
+func ParseDeck(raw string) (Deck, error) {
+	deck := Deck{}
+	err := decodeFirstJSON(raw, func(text string) error {
+		var err error
+		deck, err = parseDeckJSON(text)
+		return err
+	})
+	if err != nil {
+		return Deck{}, err
+	}
+	deck.unrecognizedLevels = normalizeCards(deck.Cards)
+	return deck, nil
+}
+
+// normalizeCards makes the levels and difficulties of cards valid, and returns the levels that
+// were not Bloom levels.
+func normalizeCards(cards []Flashcards) (unrecognized []string) {
+	for i := range cards {
+		card := &cards[i]
+		if card.Level != "" {
+			level, err := ParseBloomLevel(strings.TrimSpace(string(card.Level)))
+			if err != nil {
+				unrecognized = append(unrecognized, string(card.Level))
+			}
+			card.Level = level
+		}
+		switch {
+		case card.Difficulty < 1:
+			card.Difficulty = 0
+		case card.Difficulty > 5:
+			card.Difficulty = 5
+		}
+	}
+	return unrecognized
+}
+
+// parseDeckJSON parses text, a JSON object or array, as a deck.
+func parseDeckJSON(text string) (Deck, error) {
+	text = removeTrailingCommas(text)
+
+	if strings.HasPrefix(text, "[") {
+		cards := []Flashcards{}
+		if err := json.Unmarshal([]byte(text), &cards); err != nil {
+			return Deck{}, fmt.Errorf("failed to parse array of cards: %w", err)
+		}
+		return Deck{Cards: cards}, nil
+	}
+
+	// keys match case-insensitively
+	var lenient struct {
+		Title      string        `json:"Title"`
+		Cards      *[]Flashcards `json:"cards"`
+		Flashcards *[]Flashcards `json:"flashcards"`
+	}
+	if err := json.Unmarshal([]byte(text), &lenient); err != nil {
+		return Deck{}, fmt.Errorf("failed to parse deck: %w", err)
+	}
+	deck := Deck{Title: lenient.Title, Cards: []Flashcards{}}
+	switch {
+	case lenient.Cards != nil:
+		deck.Cards = *lenient.Cards
+	case lenient.Flashcards != nil:
+		deck.Cards = *lenient.Flashcards
+	default:
+		return Deck{}, errors.New(`failed to parse deck: the object has no "cards" array`)
+	}
+	return deck, nil
+}
+
+// decodeFirstJSON passes the JSON objects and arrays of raw to decode, code fences first, until
+// one decodes. Every opening bracket is tried in turn, so brackets in the prose before the JSON,
+// such as "[1]" or "{name}", do not hide it. The error of the first one is returned when none
+// decodes.
+func decodeFirstJSON(raw string, decode func(text string) error) error {
+	var candidates []string
+	for _, match := range fencedBlockPattern.FindAllStringSubmatch(raw, -1) {
+		if block := strings.TrimSpace(match[1]); strings.HasPrefix(block, "{") || strings.HasPrefix(block, "[") {
+			candidates = append(candidates, block)
+		}
+	}
+	candidates = append(candidates, raw)
+
+	var firstErr error
+	for _, text := range candidates {
+		for start := strings.IndexAny(text, "{["); start >= 0; {
+			value, err := balancedJSON(text[start:])
+			if err == nil {
+				if err = decode(value); err == nil {
+					return nil
+				}
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			if value == "" {
+				// the rest of text is inside the unterminated bracket
+				break
+			}
+			next := strings.IndexAny(text[start+1:], "{[")
+			if next < 0 {
+				break
+			}
+			start += 1 + next
+		}
+	}
+	if firstErr == nil {
+		return errors.New("no JSON object or array in the response")
+	}
+	return firstErr
+}
+
+// balancedJSON returns the start of text up to the bracket closing its first one.
+func balancedJSON(text string) (string, error) {
+	depth := 0
+	inString, escaped := false, false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case inString:
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+			if depth == 0 {
+				return text[:i+1], nil
+			}
+		}
+	}
+	return "", fmt.Errorf("unterminated JSON in the response, it ends after %d characters", len(text))
+}
+
+// removeTrailingCommas drops the commas right before a closing bracket, outside of strings.
+func removeTrailingCommas(text string) string {
+	var sb strings.Builder
+	inString, escaped := false, false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case c == '"':
+			inString = !inString
+		case !inString && c == ',':
+			next := strings.TrimLeft(text[i+1:], " \t\r\n")
+			if strings.HasPrefix(next, "}") || strings.HasPrefix(next, "]") {
+				continue
+			}
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// decodeResponse parses a model's answer into v, leniently like ParseDeck.
+func decodeResponse(content string, v interface{}) error {
+	if deck, ok := v.(*Deck); ok {
+		parsed, err := ParseDeck(content)
+		if err != nil {
+			return err
+		}
+		*deck = parsed
+		return nil
+	}
+	target := reflect.ValueOf(v).Elem()
+	return decodeFirstJSON(content, func(text string) error {
+		// a failed attempt may have filled part of v
+		target.Set(reflect.Zero(target.Type()))
+		return json.Unmarshal([]byte(removeTrailingCommas(text)), v)
+	})
+}
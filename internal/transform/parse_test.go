@@ -0,0 +1,150 @@
+package transform
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jaxxk/anki-cards-generator/internal/transform/llmtest"
+	"github.com/jaxxk/anki-cards-generator/internal/usage"
+	"github.com/openai/openai-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDeck(t *testing.T) {
+	card := Flashcards{Front: "What is a channel?", Back: "A typed conduit, e.g. `ch <- v` sends {v}."}
+	tests := []struct {
+		name string
+		raw  string
+		deck Deck
+	}{
+		{"strict", `{"Title":"Go","cards":[{"front":"What is a channel?","back":"A typed conduit, e.g. ` + "`ch <- v`" + ` sends {v}."}]}`, Deck{Title: "Go", Cards: []Flashcards{card}}},
+		{"code fence", "```json\n{\"Title\": \"Go\", \"cards\": [{\"front\": \"What is a channel?\", \"back\": \"A typed conduit, e.g. `ch <- v` sends {v}.\"}]}\n```", Deck{Title: "Go", Cards: []Flashcards{card}}},
+		{"prose", "Sure! Here is your deck:\n{\"title\": \"Go\", \"cards\": [{\"front\": \"What is a channel?\", \"back\": \"A typed conduit, e.g. `ch <- v` sends {v}.\"}]}\nLet me know if you need more.", Deck{Title: "Go", Cards: []Flashcards{card}}},
+		{"trailing commas", "{\"Title\": \"Go\", \"cards\": [{\"front\": \"What is a channel?\", \"back\": \"A typed conduit, e.g. `ch <- v` sends {v}.\",},\n],}", Deck{Title: "Go", Cards: []Flashcards{card}}},
+		{"bare array", "[{\"front\": \"What is a channel?\", \"back\": \"A typed conduit, e.g. `ch <- v` sends {v}.\"}]", Deck{Cards: []Flashcards{card}}},
+		{"flashcards key", "{\"Title\": \"Go\", \"flashcards\": [{\"Front\": \"What is a channel?\", \"Back\": \"A typed conduit, e.g. `ch <- v` sends {v}.\"}]}", Deck{Title: "Go", Cards: []Flashcards{card}}},
+		{"comma in string", `{"Title": "Go", "cards": [{"front": "Q", "back": "a, ]"}]}`, Deck{Title: "Go", Cards: []Flashcards{{Front: "Q", Back: "a, ]"}}}},
+		{"empty deck", `{"Title": "Go", "cards": []}`, Deck{Title: "Go", Cards: []Flashcards{}}},
+		{"brackets in prose", "As in [1], each {card} has a front:\n{\"Title\": \"Go\", \"cards\": [{\"front\": \"What is a channel?\", \"back\": \"A typed conduit, e.g. `ch <- v` sends {v}.\"}]}", Deck{Title: "Go", Cards: []Flashcards{card}}},
+		{"object before deck", `Settings: {"temperature": 0} then {"Title": "Go", "cards": []}`, Deck{Title: "Go", Cards: []Flashcards{}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deck, err := ParseDeck(tt.raw)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.deck, deck)
+		})
+	}
+}
+
+func TestParseDeckErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		err  string
+	}{
+		{"no JSON", "I could not find any facts in these notes.", "no JSON object or array"},
+		{"truncated", `{"Title": "Go", "cards": [{"front": "Q", "back": "A`, "unterminated JSON"},
+		{"no cards", `{"Title": "Go"}`, `no "cards" array`},
+		{"wrong type", `{"Title": "Go", "cards": "none"}`, "failed to parse deck"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseDeck(tt.raw)
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
+
+// proseResponder answers with prose until it is asked to repair its answer.
+func proseResponder(req llmtest.Request) string {
+	if strings.HasPrefix(req.LastUserMessage(), "Your response could not be parsed") {
+		return llmtest.DeckResponder(llmtest.Request{Messages: req.Messages[:2]})
+	}
+	return "Here are some flashcards about channels, I hope they help."
+}
+
+func TestCreateDeckRepairReplay(t *testing.T) {
+	llmtest.UseCassetteWith(t, filepath.Join("testdata", "cassettes", "repair.json"), *record, proseResponder)
+
+	run := usage.NewRun("notes.md", usage.Budget{}, usage.DefaultPriceTable)
+	ctx := usage.WithRun(context.Background(), run)
+	deck, err := createDeck(ctx, "Channels are typed conduits. Closing a channel signals that no more values will be sent.")
+	assert.NoError(t, err)
+	assert.Equal(t, "Fake Deck", deck.Title)
+	assert.Len(t, deck.Cards, 2)
+
+	entries, err := usage.Load()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2, "the answer and its repair are both recorded")
+}
+
+func TestCreateDeckRepairGivesUp(t *testing.T) {
+	llmtest.UseCassetteWith(t, filepath.Join("testdata", "cassettes", "repair_failed.json"), *record, func(llmtest.Request) string {
+		return "No flashcards today."
+	})
+	repairAttempts := DefaultRepairAttempts
+	DefaultRepairAttempts = 2
+	t.Cleanup(func() { DefaultRepairAttempts = repairAttempts })
+
+	run := usage.NewRun("notes.md", usage.Budget{}, usage.DefaultPriceTable)
+	_, err := createDeck(usage.WithRun(context.Background(), run), "Channels are typed conduits.")
+	assert.ErrorContains(t, err, "invalid JSON response from transform package: no JSON object or array")
+
+	entries, err := usage.Load()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 3, "one answer and two repairs")
+}
+
+func TestDecodeResponseSkipsOtherJSON(t *testing.T) {
+	verdicts := Verdicts{}
+	err := decodeResponse(`See [1] and {name}: {"verdicts": [{"card": 1, "supported": true, "unsupported_claims": []}]}`, &verdicts)
+	assert.NoError(t, err)
+	assert.Equal(t, Verdicts{Verdicts: []Verdict{{Card: 1, Supported: true, UnsupportedClaims: []string{}}}}, verdicts)
+}
+
+func TestStructuredOutputOff(t *testing.T) {
+	structured, err := json.Marshal(judgeChatCompletionConfigs("notes"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(structured), "json_schema")
+
+	DefaultStructuredOutput = false
+	t.Cleanup(func() { DefaultStructuredOutput = true })
+	for name, params := range map[string]openai.ChatCompletionNewParams{
+		"deck":    DefaultChatCompletionConfigs("notes"),
+		"judge":   judgeChatCompletionConfigs("notes"),
+		"summary": summaryChatCompletionConfigs(DefaultSummaryPrompt, "notes"),
+	} {
+		serialized, err := json.Marshal(params)
+		assert.NoError(t, err, name)
+		assert.NotContains(t, string(serialized), "response_format", name)
+		assert.Contains(t, string(serialized), "follows this JSON schema", name)
+		messages := params.Messages.Value
+		assert.Len(t, messages, 3, name)
+		assert.Equal(t, openai.UserMessage("notes"), messages[2], "the notes stay last")
+	}
+}
+
+func TestParseDeckNormalizesLevels(t *testing.T) {
+	deck, err := ParseDeck(`{"Title": "Go", "cards": [
+		{"front": "A", "back": "a", "level": "Recall", "difficulty": 0},
+		{"front": "B", "back": "b", "level": " APPLY ", "difficulty": 9},
+		{"front": "C", "back": "c", "level": "evaluate", "difficulty": -2},
+		{"front": "D", "back": "d", "level": "", "difficulty": 3},
+		{"front": "E", "back": "e", "level": "recall", "difficulty": "3"},
+		{"front": "F", "back": "f", "level": "recall", "difficulty": " 7 "},
+		{"front": "G", "back": "g", "level": "recall", "difficulty": "hard"}
+	]}`)
+	assert.NoError(t, err)
+	levels, difficulties := []BloomLevel{}, []int{}
+	for _, card := range deck.Cards {
+		levels = append(levels, card.Level)
+		difficulties = append(difficulties, card.Difficulty)
+	}
+	assert.Equal(t, []BloomLevel{LevelRecall, LevelApply, "", "", LevelRecall, LevelRecall, LevelRecall}, levels)
+	assert.Equal(t, []int{0, 5, 0, 3, 3, 5, 0}, difficulties)
+	assert.Equal(t, []string{"evaluate"}, deck.unrecognizedLevels)
+}
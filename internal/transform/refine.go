@@ -0,0 +1,191 @@
+package transform
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jaxxk/anki-cards-generator/internal/cache"
+	"github.com/jaxxk/anki-cards-generator/internal/encryption"
+	"github.com/jaxxk/anki-cards-generator/internal/usage"
+	"github.com/jaxxk/anki-cards-generator/pkg/logging"
+	"github.com/jaxxk/anki-cards-generator/pkg/utils"
+)
+
+// REFINE_LOG_FILE is the audit log of refinement passes in the processing directory, one JSON
+// entry per line.
+var REFINE_LOG_FILE = "refinements.jsonl"
+
+// Refinement has the model critique the cards of every chunk against a rubric and revise them,
+// Passes times over.
+type Refinement struct {
+	Passes int
+	// Rubric is what the cards are held to, DefaultRubric if empty
+	Rubric string
+}
+
+func (r Refinement) rubric() string {
+	if strings.TrimSpace(r.Rubric) == "" {
+		return DefaultRubric
+	}
+	return r.Rubric
+}
+
+type refinementKey struct{}
+
+// WithRefinement returns a context whose generated cards are refined by r.
+func WithRefinement(ctx context.Context, r Refinement) context.Context {
+	return context.WithValue(ctx, refinementKey{}, r)
+}
+
+// RefinementFromContext returns the Refinement in ctx, with no passes if there is none.
+func RefinementFromContext(ctx context.Context) Refinement {
+	r, _ := ctx.Value(refinementKey{}).(Refinement)
+	return r
+}
+
+// RefinementEntry records the cards before and after one refinement pass.
+type RefinementEntry struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source,omitempty"`
+	// Chunk identifies the notes the cards were generated from
+	Chunk  string       `json:"chunk"`
+	Pass   int          `json:"pass"`
+	Cached bool         `json:"cached,omitempty"`
+	Before []Flashcards `json:"before"`
+	After  []Flashcards `json:"after"`
+}
+
+// appendRefinement adds entry to the audit log. Entries are sealed when at-rest encryption is
+// enabled.
+func appendRefinement(entry RefinementEntry) error {
+	processingPath, err := utils.CreateProcessingDir()
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to serialize refinement entry: %w", err)
+	}
+	if _, err := encryption.AppendLine(line, processingPath, REFINE_LOG_FILE); err != nil {
+		return fmt.Errorf("failed to append to refinement log: %w", err)
+	}
+	return nil
+}
+
+// LoadRefinements returns every entry of the refinement audit log, oldest first.
+func LoadRefinements() ([]RefinementEntry, error) {
+	processingPath, err := utils.CreateProcessingDir()
+	if err != nil {
+		return nil, err
+	}
+	lines, err := encryption.ReadLines(filepath.Join(processingPath, REFINE_LOG_FILE))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read refinement log: %w", err)
+	}
+	entries := make([]RefinementEntry, 0, len(lines))
+	for i, line := range lines {
+		var entry RefinementEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse refinement log entry %d: %w", i+1, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// refinePrompt holds the rubric, the notes and the deck to revise.
+func refinePrompt(rubric, text string, deck Deck) (string, error) {
+	// tags are not part of the schema, the model never sees them
+	cards := make([]Flashcards, len(deck.Cards))
+	for i, card := range deck.Cards {
+		cards[i] = Flashcards{Front: card.Front, Back: card.Back, Difficulty: card.Difficulty, Level: card.Level}
+	}
+	serialized, err := json.MarshalIndent(Deck{Title: deck.Title, Cards: cards}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize flashcards: %w", err)
+	}
+	return fmt.Sprintf("Rubric:\n%s\n\nNotes:\n%s\n\nFlashcards:\n%s", rubric, text, serialized), nil
+}
+
+// refineDeck runs the refinement passes in ctx over the deck generated from text. A pass that
+// returns no cards is ignored, the cards before it are kept.
+func refineDeck(ctx context.Context, text string, deck Deck) (Deck, error) {
+	refinement := RefinementFromContext(ctx)
+	if refinement.Passes <= 0 || len(deck.Cards) == 0 {
+		return deck, nil
+	}
+	logger := logging.FromContext(ctx)
+	source := ""
+	if run := usage.FromContext(ctx); run != nil {
+		source = run.Source
+	}
+	sum := sha256.Sum256([]byte(text))
+	chunk := hex.EncodeToString(sum[:8])
+
+	for pass := 1; pass <= refinement.Passes; pass++ {
+		prompt, err := refinePrompt(refinement.rubric(), text, deck)
+		if err != nil {
+			return Deck{}, err
+		}
+		revised := Deck{}
+		inputTokens := EstimateTokens(ctx, DefaultRefinePrompt) + EstimateTokens(ctx, prompt)
+		cached, err := cachedCompletion(ctx, "refine/v1", refineChatCompletionConfigs(prompt), inputTokens, EstimateTokens(ctx, prompt), &revised)
+		if err != nil {
+			return Deck{}, fmt.Errorf("failed to refine flashcards: %w", err)
+		}
+		if len(revised.Cards) == 0 {
+			logger.Warnf("Refinement pass %d returned no cards, keeping the cards before it", pass)
+			break
+		}
+
+		entry := RefinementEntry{
+			Time: time.Now().UTC(), Source: source, Chunk: chunk, Pass: pass, Cached: cached,
+			Before: deck.Cards, After: revised.Cards,
+		}
+		if err := appendRefinement(entry); err != nil {
+			// the audit log is not worth failing a call that was already paid for
+			logger.Errorf("Failed to log refinement: %v", err)
+		}
+		logger.Infof("Refinement pass %d: %d card(s) before, %d after", pass, len(deck.Cards), len(revised.Cards))
+		deck.Cards = revised.Cards
+		deck.unrecognizedLevels = append(deck.unrecognizedLevels, revised.unrecognizedLevels...)
+	}
+	return deck, nil
+}
+
+// generateDeck creates the deck of one chunk of notes of words words, with the summary of its
+// document as context, refines it and keeps the number of cards targeted.
+func generateDeck(ctx context.Context, text string, words int) (Deck, error) {
+	targeting := TargetingFromContext(ctx)
+	target := targeting.target(words)
+	deck, err := createDeck(ctx, targeting.prompt(text, target))
+	if err != nil {
+		return Deck{}, err
+	}
+	if deck, err = refineDeck(ctx, text, deck); err != nil {
+		return Deck{}, err
+	}
+	targeting.unrecognized(deck.unrecognizedLevels)
+	deck.Cards = targeting.keep(ctx, target, words, deck.Cards)
+	return deck, nil
+}
+
+// refinementSettings identifies the refinement in ctx, empty when there is none.
+func refinementSettings(ctx context.Context) (string, error) {
+	refinement := RefinementFromContext(ctx)
+	if refinement.Passes <= 0 {
+		return "", nil
+	}
+	params, err := json.Marshal(refineChatCompletionConfigs(refinement.rubric()))
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize refinement settings: %w", err)
+	}
+	return cache.Key([]byte("refine/v1"), params, []byte(strconv.Itoa(refinement.Passes))), nil
+}
@@ -0,0 +1,89 @@
+package transform
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jaxxk/anki-cards-generator/internal/transform/llmtest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefinePrompt(t *testing.T) {
+	deck := Deck{Title: "Go", Cards: []Flashcards{{Front: "What is a goroutine?", Back: "A function.", Tags: []string{"go"}}}}
+	prompt, err := refinePrompt(Refinement{}.rubric(), "A goroutine is a function.", deck)
+	assert.NoError(t, err)
+	assert.Contains(t, prompt, "Rubric:\n"+DefaultRubric)
+	assert.Contains(t, prompt, "Notes:\nA goroutine is a function.")
+	assert.Contains(t, prompt, `"front": "What is a goroutine?"`)
+	assert.NotContains(t, prompt, "tags", "tags are not part of the schema")
+	assert.Equal(t, "Only ask about channels.", Refinement{Rubric: "Only ask about channels."}.rubric())
+}
+
+// revisingResponder answers every refinement pass by dropping the last card, while more than
+// one is left, and prefixing the fronts of the others with "Revised: ".
+func revisingResponder(req llmtest.Request) string {
+	if !strings.HasPrefix(req.LastUserMessage(), "Rubric:\n") {
+		return llmtest.DefaultResponder(req)
+	}
+	deck := Deck{}
+	_, cards, _ := strings.Cut(req.LastUserMessage(), "\n\nFlashcards:\n")
+	json.Unmarshal([]byte(cards), &deck)
+	if len(deck.Cards) > 1 {
+		deck.Cards = deck.Cards[:len(deck.Cards)-1]
+	}
+	for i := range deck.Cards {
+		deck.Cards[i].Front = "Revised: " + deck.Cards[i].Front
+	}
+	content, _ := json.Marshal(deck)
+	return string(content)
+}
+
+func TestRefineDeckReplay(t *testing.T) {
+	llmtest.UseCassetteWith(t, filepath.Join("testdata", "cassettes", "refine.json"), *record, revisingResponder)
+
+	text := "Channels are typed conduits. Closing a channel signals that no more values will be sent. A nil channel blocks forever."
+	ctx := WithRefinement(context.Background(), Refinement{Passes: 2})
+	deck, err := generateDeck(ctx, text, 15)
+	assert.NoError(t, err)
+	if assert.Len(t, deck.Cards, 1, "every pass dropped a card") {
+		assert.True(t, strings.HasPrefix(deck.Cards[0].Front, "Revised: Revised: "), "the second pass revised the first")
+	}
+
+	entries, err := LoadRefinements()
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, 1, entries[0].Pass)
+		assert.Equal(t, 2, entries[1].Pass)
+		assert.Len(t, entries[0].Before, 3)
+		assert.Len(t, entries[0].After, 2)
+		assert.Equal(t, entries[0].After, entries[1].Before)
+		assert.Equal(t, deck.Cards, entries[1].After)
+		assert.Equal(t, entries[0].Chunk, entries[1].Chunk)
+	}
+
+	// without passes the deck is not refined or logged
+	plain, err := generateDeck(context.Background(), text, 15)
+	assert.NoError(t, err)
+	assert.Len(t, plain.Cards, 3)
+	entries, err = LoadRefinements()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestGenerationSettingsRefinement(t *testing.T) {
+	plain, err := generationSettings(context.Background())
+	assert.NoError(t, err)
+	refined, err := generationSettings(WithRefinement(context.Background(), Refinement{Passes: 1}))
+	assert.NoError(t, err)
+	twice, err := generationSettings(WithRefinement(context.Background(), Refinement{Passes: 2}))
+	assert.NoError(t, err)
+	other, err := generationSettings(WithRefinement(context.Background(), Refinement{Passes: 1, Rubric: "Be brief."}))
+	assert.NoError(t, err)
+
+	assert.NotEqual(t, plain, refined)
+	assert.NotEqual(t, refined, twice)
+	assert.NotEqual(t, refined, other)
+}
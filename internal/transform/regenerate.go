@@ -0,0 +1,72 @@
+package transform
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jaxxk/anki-cards-generator/internal/cache"
+	"github.com/jaxxk/anki-cards-generator/pkg/logging"
+)
+
+// regeneratePrompt asks for a new version of card, with notes, the chunk it was made from, when
+// it is known.
+func regeneratePrompt(notes string, card Flashcards) string {
+	var sb strings.Builder
+	if notes != "" {
+		fmt.Fprintf(&sb, "Notes:\n%s\n\n", notes)
+	}
+	fmt.Fprintf(&sb, "Flashcard:\nfront: %s\nback: %s", card.Front, card.Back)
+	return sb.String()
+}
+
+// cardChunk returns the chunk that shares the most terms with card, empty when none shares any.
+func cardChunk(chunks []string, card Flashcards) string {
+	terms := distinctTerms(card.Front + " " + card.Back)
+	best, bestShared := "", 0
+	for _, chunk := range chunks {
+		chunkTerms := map[string]bool{}
+		for _, term := range distinctTerms(chunk) {
+			chunkTerms[term] = true
+		}
+		shared := 0
+		for _, term := range terms {
+			if chunkTerms[term] {
+				shared++
+			}
+		}
+		if shared > bestShared {
+			best, bestShared = chunk, shared
+		}
+	}
+	return best
+}
+
+// RegenerateCard asks the model for a new version of card, about the same fact, keeping its
+// tags. The chunk of the notes at source the card was most likely made from is sent along, so
+// the new card is checked against the notes rather than the old card alone. The response cache
+// is bypassed so asking again gives another card.
+func RegenerateCard(ctx context.Context, source string, card Flashcards) (Flashcards, error) {
+	ctx = cache.WithCache(withRunFor(ctx, source), nil)
+	notes := ""
+	if chunks, err := ChunkDocument(ctx, source); err != nil {
+		logging.FromContext(ctx).Warnf("Regenerating the card without the notes of %s: %v", source, err)
+	} else {
+		notes = cardChunk(chunks, card)
+	}
+
+	prompt := regeneratePrompt(notes, card)
+	deck := Deck{}
+	inputTokens := EstimateTokens(ctx, DefaultRegeneratePrompt) + EstimateTokens(ctx, prompt)
+	outputTokens := EstimateTokens(ctx, regeneratePrompt("", card))
+	if _, err := cachedCompletion(ctx, "regenerate/v1", regenerateChatCompletionConfigs(prompt), inputTokens, outputTokens, &deck); err != nil {
+		return Flashcards{}, fmt.Errorf("failed to regenerate card: %w", err)
+	}
+	if len(deck.Cards) == 0 {
+		return Flashcards{}, errors.New("failed to regenerate card: the model returned no card")
+	}
+	regenerated := deck.Cards[0]
+	regenerated.Tags = card.Tags
+	return regenerated, nil
+}
@@ -0,0 +1,114 @@
+package transform
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// LineRange is a range of lines of a document, from 1, both ends included.
+type LineRange struct {
+	Start int
+	End   int
+}
+
+// Region is a changed part of a document, with the section it belongs to as context.
+type Region struct {
+	Heading string
+	// Context is the whole section, empty when it is too long to send along
+	Context string
+	// Changed holds the words of the changed lines
+	Changed   string
+	StartLine int
+	EndLine   int
+}
+
+// ChangedRegions returns one region for every section of content that has changed lines.
+// Changes outside of sections, such as to "---" markers or frontmatter, are ignored.
+func ChangedRegions(ctx context.Context, content string, changed []LineRange) ([]Region, error) {
+	sections, err := SplitSectionsText(ctx, content)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(content, "\n")
+	budget := ChunkBudget(string(ModelFromContext(ctx)))
+
+	var regions []Region
+	for _, section := range sections {
+		region := Region{Heading: section.Heading}
+		var words []string
+		for _, lineRange := range changed {
+			start, end := max(lineRange.Start, section.StartLine), min(lineRange.End, section.EndLine)
+			for line := start; line <= end && line <= len(lines); line++ {
+				lineWords := strings.Fields(lines[line-1])
+				if len(lineWords) == 0 {
+					continue
+				}
+				words = append(words, lineWords...)
+				if region.StartLine == 0 || line < region.StartLine {
+					region.StartLine = line
+				}
+				region.EndLine = max(region.EndLine, line)
+			}
+		}
+		if len(words) == 0 {
+			continue
+		}
+		region.Changed = strings.Join(words, " ")
+		if EstimateTokens(ctx, section.Text)+EstimateTokens(ctx, region.Changed) <= budget {
+			region.Context = section.Text
+		}
+		regions = append(regions, region)
+	}
+	return regions, nil
+}
+
+// regionPrompt asks for cards about the changed part of a region only.
+func regionPrompt(region Region) string {
+	var sb strings.Builder
+	switch {
+	case region.Context != "":
+		fmt.Fprintf(&sb, "Context, the section %q of the notes:\n%s\n\n", region.Heading, region.Context)
+	case region.Heading != "":
+		fmt.Fprintf(&sb, "Context, the notes' section %q.\n\n", region.Heading)
+	}
+	sb.WriteString("Write flashcards only about the following part of the notes, which was just added or changed:\n")
+	sb.WriteString(region.Changed)
+	return sb.String()
+}
+
+// TransformRegions generates a deck with cards about the changed regions of content, the notes
+// named source, only. A region over ChunkBudget is sent in several chunks. With the summary
+// pass on, content is summarized for context, so the cards and the summary come from the same
+// revision of the notes.
+func TransformRegions(ctx context.Context, source string, content []byte, regions []Region) (Deck, error) {
+	ctx, err := withSummaryOf(withRunFor(ctx, source), source, content)
+	if err != nil {
+		return Deck{}, err
+	}
+	budget := ChunkBudget(string(ModelFromContext(ctx)))
+	encoding := modelEncoding(ctx)
+	joinedDeck := Deck{Cards: []Flashcards{}}
+	for _, region := range regions {
+		for _, chunk := range chunkWords(encoding, strings.Fields(region.Changed), budget) {
+			if err := ctx.Err(); err != nil {
+				return Deck{}, err
+			}
+			part := region
+			part.Changed = chunk
+			deck, err := generateDeck(ctx, regionPrompt(part), len(strings.Fields(chunk)))
+			if err != nil {
+				return Deck{}, fmt.Errorf("failed to create deck for lines %d-%d of %s: %w", region.StartLine, region.EndLine, source, err)
+			}
+			if deck.Cards, err = groundCards(ctx, region.Context+"\n"+chunk, deck.Cards); err != nil {
+				return Deck{}, err
+			}
+			if joinedDeck.Title == "" {
+				joinedDeck.Title = deck.Title
+			}
+			joinedDeck.Cards = append(joinedDeck.Cards, deck.Cards...)
+		}
+	}
+	joinedDeck.Cards = dedupCards(ctx, joinedDeck.Cards)
+	return joinedDeck, nil
+}
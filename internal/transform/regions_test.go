@@ -0,0 +1,94 @@
+package transform
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jaxxk/anki-cards-generator/internal/usage"
+	"github.com/stretchr/testify/assert"
+)
+
+const regionNotes = `# Go Notes
+## Goroutines
+Goroutines are cheap.
+They are multiplexed onto threads.
+
+## Channels
+Channels are typed.
+Closing a channel signals no more values.
+---
+Unrelated trailing notes.
+`
+
+func TestChangedRegions(t *testing.T) {
+	regions, err := ChangedRegions(context.Background(), regionNotes, []LineRange{{Start: 4, End: 4}, {Start: 7, End: 9}})
+	assert.NoError(t, err)
+	assert.Len(t, regions, 2)
+
+	assert.Equal(t, "## Goroutines", regions[0].Heading)
+	assert.Equal(t, "They are multiplexed onto threads.", regions[0].Changed)
+	assert.Contains(t, regions[0].Context, "Goroutines are cheap.")
+	assert.Equal(t, 4, regions[0].StartLine)
+	assert.Equal(t, 4, regions[0].EndLine)
+
+	// the "---" marker is not part of any section
+	assert.Equal(t, "## Channels", regions[1].Heading)
+	assert.Equal(t, "Channels are typed. Closing a channel signals no more values.", regions[1].Changed)
+	assert.Equal(t, 7, regions[1].StartLine)
+	assert.Equal(t, 8, regions[1].EndLine)
+
+	// a blank changed line makes no region
+	regions, err = ChangedRegions(context.Background(), regionNotes, []LineRange{{Start: 5, End: 5}})
+	assert.NoError(t, err)
+	assert.Empty(t, regions)
+}
+
+func TestChangedRegionsOversizedSection(t *testing.T) {
+	notes := "## Long\n" + strings.Repeat("Channels synchronize goroutines by passing values between them.\n", 400)
+	regions, err := ChangedRegions(context.Background(), notes, []LineRange{{Start: 2, End: 2}})
+	assert.NoError(t, err)
+	assert.Len(t, regions, 1)
+	assert.Empty(t, regions[0].Context, "a section over the chunk budget is not sent along")
+	assert.NotContains(t, regionPrompt(regions[0]), "Channels synchronize goroutines by passing values between them. Channels")
+	assert.Contains(t, regionPrompt(regions[0]), `"## Long"`)
+}
+
+func TestTransformRegionsReplay(t *testing.T) {
+	useCassette(t, "regions")
+
+	regions, err := ChangedRegions(context.Background(), regionNotes, []LineRange{{Start: 4, End: 4}, {Start: 7, End: 7}})
+	assert.NoError(t, err)
+	deck, err := TransformRegions(context.Background(), filepath.Join("testdata", "notes.md"), []byte(regionNotes), regions)
+	assert.NoError(t, err)
+	assert.Equal(t, "Fake Deck", deck.Title)
+	assert.NotEmpty(t, deck.Cards)
+}
+
+func TestTransformRegionsChunksLongRegions(t *testing.T) {
+	useCassette(t, "regions_chunked")
+
+	notes := "## Long\n" + strings.Repeat("Channels synchronize goroutines by passing values between them.\n", 400)
+	regions, err := ChangedRegions(context.Background(), notes, []LineRange{{Start: 2, End: 401}})
+	assert.NoError(t, err)
+	assert.Len(t, regions, 1)
+	chunks := chunkWords(modelEncoding(context.Background()), strings.Fields(regions[0].Changed), ChunkBudget(string(DefaultModel)))
+	assert.Greater(t, len(chunks), 1)
+
+	// the summary is made from content, not from the file at source, which does not exist
+	source := filepath.Join(t.TempDir(), "missing.md")
+	ctx := usage.WithRun(context.Background(), usage.NewRun(source, usage.Budget{}, usage.DefaultPriceTable))
+	deck, err := TransformRegions(WithSummaryPass(ctx, true), source, []byte(notes), regions)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, deck.Cards)
+	entries, err := usage.Load()
+	assert.NoError(t, err)
+	decks := 0
+	for _, entry := range entries {
+		if entry.Deck != "" {
+			decks++
+		}
+	}
+	assert.Equal(t, len(chunks), decks, "one call for every chunk of the region")
+}
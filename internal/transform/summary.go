@@ -0,0 +1,298 @@
+package transform
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jaxxk/anki-cards-generator/internal/cache"
+	"github.com/jaxxk/anki-cards-generator/internal/encryption"
+	"github.com/jaxxk/anki-cards-generator/pkg/logging"
+	"github.com/jaxxk/anki-cards-generator/pkg/utils"
+)
+
+// SUMMARY_DIR holds the summaries of source files, named after the hash of their content.
+var SUMMARY_DIR = "summaries"
+
+// MaxOutlinePoints and MaxGlossaryTerms cap a summary, which is sent along with every chunk.
+var MaxOutlinePoints = 12
+var MaxGlossaryTerms = 20
+
+// summaryOutputTokens is the size of the summary expected back from one request.
+const summaryOutputTokens = 600
+
+// IsZero reports whether the summary holds nothing.
+func (s DocumentSummary) IsZero() bool {
+	return len(s.Outline) == 0 && len(s.Glossary) == 0
+}
+
+// String formats the summary as it is sent to the model.
+func (s DocumentSummary) String() string {
+	var sb strings.Builder
+	if len(s.Outline) > 0 {
+		sb.WriteString("Outline:\n")
+		for _, point := range s.Outline {
+			fmt.Fprintf(&sb, "- %s\n", point)
+		}
+	}
+	if len(s.Glossary) > 0 {
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString("Glossary:\n")
+		for _, entry := range s.Glossary {
+			fmt.Fprintf(&sb, "- %s: %s\n", entry.Term, entry.Definition)
+		}
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// clip drops empty points and repeated terms, and caps the summary at MaxOutlinePoints and
+// MaxGlossaryTerms.
+func (s DocumentSummary) clip() DocumentSummary {
+	clipped := DocumentSummary{Outline: []string{}, Glossary: []GlossaryEntry{}}
+	for _, point := range s.Outline {
+		if point = strings.TrimSpace(point); point != "" && len(clipped.Outline) < MaxOutlinePoints {
+			clipped.Outline = append(clipped.Outline, point)
+		}
+	}
+	seen := map[string]bool{}
+	for _, entry := range s.Glossary {
+		term := strings.ToLower(strings.TrimSpace(entry.Term))
+		if term == "" || seen[term] || len(clipped.Glossary) >= MaxGlossaryTerms {
+			continue
+		}
+		seen[term] = true
+		clipped.Glossary = append(clipped.Glossary, GlossaryEntry{Term: strings.TrimSpace(entry.Term), Definition: strings.TrimSpace(entry.Definition)})
+	}
+	return clipped
+}
+
+type summaryPassKey struct{}
+
+// WithSummaryPass returns a context in which, when on is set, every document is summarized
+// before its chunks are generated, and the summary is sent along with every chunk.
+func WithSummaryPass(ctx context.Context, on bool) context.Context {
+	return context.WithValue(ctx, summaryPassKey{}, on)
+}
+
+// SummaryPassFromContext reports whether documents are summarized first in ctx.
+func SummaryPassFromContext(ctx context.Context) bool {
+	on, _ := ctx.Value(summaryPassKey{}).(bool)
+	return on
+}
+
+type documentSummaryKey struct{}
+
+// withSummaryFor puts the summary of the notes at docPath in ctx when the summary pass is on.
+func withSummaryFor(ctx context.Context, docPath string) (context.Context, error) {
+	if !SummaryPassFromContext(ctx) {
+		return ctx, nil
+	}
+	summary, err := SummarizeDocument(ctx, docPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize %s: %w", docPath, err)
+	}
+	return context.WithValue(ctx, documentSummaryKey{}, summary), nil
+}
+
+// withSummaryOf puts the summary of content, the notes named source, in ctx when the summary
+// pass is on.
+func withSummaryOf(ctx context.Context, source string, content []byte) (context.Context, error) {
+	if !SummaryPassFromContext(ctx) {
+		return ctx, nil
+	}
+	summary, err := summarizeContent(ctx, source, content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to summarize %s: %w", source, err)
+	}
+	return context.WithValue(ctx, documentSummaryKey{}, summary), nil
+}
+
+// summaryPrompt puts the summary of the document in ctx, if any, before the text of a chunk.
+func summaryPrompt(ctx context.Context, text string) string {
+	summary, _ := ctx.Value(documentSummaryKey{}).(DocumentSummary)
+	if summary.IsZero() {
+		return text
+	}
+	return fmt.Sprintf("The whole notes, for context only. Do not write flashcards about them, and do not define again the terms of the glossary unless the part asks about them:\n%s\n\nWrite flashcards about this part of the notes:\n%s", summary, text)
+}
+
+// summaryKey identifies content and everything sent to the model with it, so editing the
+// notes or the prompts gives a new summary.
+func summaryKey(content []byte) (string, error) {
+	mapParams, err := json.Marshal(summaryChatCompletionConfigs(DefaultSummaryPrompt, ""))
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize summary settings: %w", err)
+	}
+	mergeParams, err := json.Marshal(summaryChatCompletionConfigs(DefaultSummaryMergePrompt, ""))
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize summary settings: %w", err)
+	}
+	return cache.Key([]byte("summary/v1"), content, mapParams, mergeParams)[:16], nil
+}
+
+// summaryPath returns where the summary of content is kept.
+func summaryPath(content []byte) (string, string, error) {
+	processingPath, err := utils.CreateProcessingDir()
+	if err != nil {
+		return "", "", err
+	}
+	key, err := summaryKey(content)
+	if err != nil {
+		return "", "", err
+	}
+	return filepath.Join(processingPath, SUMMARY_DIR), key + ".json", nil
+}
+
+// SummarizeDocument returns the outline and glossary of the notes at docPath. Every chunk is
+// summarized, then the summaries are merged as many at a time as fit in ChunkBudget until one
+// is left. The summary is kept in the processing directory, so the same notes are only
+// summarized once.
+func SummarizeDocument(ctx context.Context, docPath string) (DocumentSummary, error) {
+	content, err := readDocument(docPath)
+	if err != nil {
+		return DocumentSummary{}, err
+	}
+	return summarizeContent(ctx, docPath, content)
+}
+
+// summarizeContent summarizes content, the notes named source, like SummarizeDocument.
+func summarizeContent(ctx context.Context, source string, content []byte) (DocumentSummary, error) {
+	logger := logging.FromContext(ctx)
+	sections, err := SplitSectionsText(ctx, string(content))
+	if err != nil {
+		return DocumentSummary{}, err
+	}
+	chunks := sectionChunks(ctx, sections)
+	dir, name, err := summaryPath(content)
+	if err != nil {
+		return DocumentSummary{}, err
+	}
+
+	stored := DocumentSummary{}
+	if err := encryption.ReadJSONFile(filepath.Join(dir, name), &stored); err == nil {
+		logger.Debugf("Using the stored summary of %s", source)
+		return stored, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		// a broken summary only costs summarizing again
+		logger.Warnf("Ignoring the stored summary of %s: %v", source, err)
+	}
+
+	parts := make([]DocumentSummary, 0, len(chunks))
+	for _, chunk := range chunks {
+		if err := ctx.Err(); err != nil {
+			return DocumentSummary{}, err
+		}
+		part, err := summarize(ctx, DefaultSummaryPrompt, chunk)
+		if err != nil {
+			return DocumentSummary{}, err
+		}
+		parts = append(parts, part)
+	}
+	summary, err := mergeSummaries(ctx, parts)
+	if err != nil {
+		return DocumentSummary{}, err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return DocumentSummary{}, fmt.Errorf("failed to create summary directory: %w", err)
+	}
+	if _, err := encryption.WriteJSONFile(summary, dir, name); err != nil {
+		return DocumentSummary{}, fmt.Errorf("failed to save summary of %s: %w", source, err)
+	}
+	logger.Infof("Summarized %s in %d outline point(s) and %d glossary term(s)", source, len(summary.Outline), len(summary.Glossary))
+	return summary, nil
+}
+
+// summarize sends text with prompt and returns the summary the model answers with.
+func summarize(ctx context.Context, prompt, text string) (DocumentSummary, error) {
+	summary := DocumentSummary{}
+	inputTokens := EstimateTokens(ctx, prompt) + EstimateTokens(ctx, text)
+	if _, err := cachedCompletion(ctx, "summary/v1", summaryChatCompletionConfigs(prompt, text), inputTokens, summaryOutputTokens, &summary); err != nil {
+		return DocumentSummary{}, fmt.Errorf("failed to summarize notes: %w", err)
+	}
+	return summary.clip(), nil
+}
+
+// mergeSummaries merges the summaries of consecutive parts of the notes, level by level, until
+// one is left.
+func mergeSummaries(ctx context.Context, parts []DocumentSummary) (DocumentSummary, error) {
+	if len(parts) == 0 {
+		return DocumentSummary{Outline: []string{}, Glossary: []GlossaryEntry{}}, nil
+	}
+	for len(parts) > 1 {
+		merged := []DocumentSummary{}
+		for _, batch := range summaryBatches(ctx, parts, ChunkBudget(string(ModelFromContext(ctx)))) {
+			if len(batch) == 1 {
+				merged = append(merged, batch[0])
+				continue
+			}
+			summary, err := summarize(ctx, DefaultSummaryMergePrompt, mergePrompt(batch))
+			if err != nil {
+				return DocumentSummary{}, err
+			}
+			merged = append(merged, summary)
+		}
+		parts = merged
+	}
+	return parts[0], nil
+}
+
+// summaryBatches groups consecutive summaries into batches of at most budget tokens. Every
+// batch but a last lone one holds two summaries or more, so merging always makes progress.
+func summaryBatches(ctx context.Context, parts []DocumentSummary, budget int) [][]DocumentSummary {
+	sizes := make([]int, len(parts))
+	for i, part := range parts {
+		sizes[i] = EstimateTokens(ctx, part.String())
+	}
+	var batches [][]DocumentSummary
+	for _, batch := range batchSizes(sizes, budget) {
+		batches = append(batches, parts[:len(batch)])
+		parts = parts[len(batch):]
+	}
+	return batches
+}
+
+// batchSizes groups consecutive sizes like summaryBatches groups the summaries of those sizes.
+func batchSizes(sizes []int, budget int) [][]int {
+	var batches [][]int
+	var batch []int
+	tokens := 0
+	for _, size := range sizes {
+		if len(batch) >= 2 && tokens+size > budget {
+			batches = append(batches, batch)
+			batch, tokens = nil, 0
+		}
+		batch = append(batch, size)
+		tokens += size
+	}
+	return append(batches, batch)
+}
+
+// mergePrompt numbers the summaries of consecutive parts of the notes.
+func mergePrompt(parts []DocumentSummary) string {
+	var sb strings.Builder
+	for i, part := range parts {
+		fmt.Fprintf(&sb, "Part %d:\n%s\n\n", i+1, part)
+	}
+	return strings.TrimSuffix(sb.String(), "\n\n")
+}
+
+// summarySettings identifies the summary pass in ctx, empty when it is off. The summary itself
+// is left out, as it changes with every edit of the notes and would stop any section from
+// being reused, here and in the deck cache (see summaryDeckCacheKey).
+func summarySettings(ctx context.Context) (string, error) {
+	if !SummaryPassFromContext(ctx) {
+		return "", nil
+	}
+	key, err := summaryKey(nil)
+	if err != nil {
+		return "", err
+	}
+	return "summary/" + key, nil
+}
@@ -0,0 +1,120 @@
+package transform
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jaxxk/anki-cards-generator/internal/usage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDocumentSummaryClip(t *testing.T) {
+	summary := DocumentSummary{
+		Outline: []string{" Channels connect goroutines. ", "", "Select waits on channels."},
+		Glossary: []GlossaryEntry{
+			{Term: "channel", Definition: "A typed conduit."},
+			{Term: "Channel", Definition: "Said again."},
+			{Term: " ", Definition: "No term."},
+		},
+	}.clip()
+	assert.Equal(t, []string{"Channels connect goroutines.", "Select waits on channels."}, summary.Outline)
+	assert.Equal(t, []GlossaryEntry{{Term: "channel", Definition: "A typed conduit."}}, summary.Glossary)
+	assert.Equal(t, "Outline:\n- Channels connect goroutines.\n- Select waits on channels.\n\nGlossary:\n- channel: A typed conduit.", summary.String())
+
+	long := DocumentSummary{}
+	for i := 0; i < MaxOutlinePoints+5; i++ {
+		long.Outline = append(long.Outline, "A point.")
+	}
+	assert.Len(t, long.clip().Outline, MaxOutlinePoints)
+}
+
+func TestSummaryPrompt(t *testing.T) {
+	text := "Closing a channel signals that no more values will be sent."
+	assert.Equal(t, text, summaryPrompt(context.Background(), text), "without a summary the chunk is sent alone")
+
+	summary := DocumentSummary{Outline: []string{"Channels connect goroutines."}}
+	ctx := context.WithValue(context.Background(), documentSummaryKey{}, summary)
+	prompt := summaryPrompt(ctx, text)
+	assert.Contains(t, prompt, "Outline:\n- Channels connect goroutines.")
+	assert.True(t, strings.HasSuffix(prompt, "this part of the notes:\n"+text))
+}
+
+func TestSummaryDeckCacheKey(t *testing.T) {
+	text := "Closing a channel signals that no more values will be sent."
+	plain, err := summaryDeckCacheKey(context.Background(), text)
+	assert.NoError(t, err)
+
+	ctx := WithSummaryPass(context.Background(), true)
+	withSummary := func(outline string) string {
+		key, err := summaryDeckCacheKey(context.WithValue(ctx, documentSummaryKey{}, DocumentSummary{Outline: []string{outline}}), text)
+		assert.NoError(t, err)
+		return key
+	}
+	before := withSummary("Channels connect goroutines.")
+	assert.NotEqual(t, plain, before, "decks made with a summary are kept apart")
+	assert.Equal(t, before, withSummary("Channels connect goroutines. Select waits on them."), "editing another section keeps the deck")
+}
+
+func TestSummaryBatches(t *testing.T) {
+	part := DocumentSummary{Outline: []string{"Channels connect goroutines and carry values of one type."}}
+	parts := []DocumentSummary{part, part, part, part, part}
+	tokens := EstimateTokens(context.Background(), part.String())
+
+	batches := summaryBatches(context.Background(), parts, 2*tokens)
+	if assert.Len(t, batches, 3) {
+		assert.Len(t, batches[0], 2)
+		assert.Len(t, batches[2], 1)
+	}
+	assert.Len(t, summaryBatches(context.Background(), parts, 100*tokens), 1)
+	assert.Len(t, summaryBatches(context.Background(), parts, 1), 3, "batches hold two summaries even over the budget")
+}
+
+func TestSummarizeDocumentReplay(t *testing.T) {
+	useCassette(t, "summary")
+
+	// sections big enough to be sent apart
+	section := func(heading, text string) string {
+		return "# " + heading + "\n" + strings.TrimSpace(strings.Repeat(text+" ", 40)) + "\n\n"
+	}
+	notes := section("Channels", "Channels are typed conduits. Sends block until a receiver is ready.") +
+		section("Select", "Select waits on several channel operations. A default case makes it non-blocking.") +
+		section("Closing", "Closing a channel signals that no more values will be sent. Receives on a closed channel return the zero value.")
+	path := filepath.Join(t.TempDir(), "channels.md")
+	assert.NoError(t, os.WriteFile(path, []byte(notes), 0600))
+	ctx := usage.WithRun(context.Background(), usage.NewRun(path, usage.Budget{}, usage.DefaultPriceTable))
+
+	summary, err := SummarizeDocument(ctx, path)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, summary.Outline)
+	assert.LessOrEqual(t, len(summary.Outline), MaxOutlinePoints)
+	assert.NotEmpty(t, summary.Glossary)
+	entries, err := usage.Load()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 4, "one summary per section and one merge")
+
+	// the summary of the same notes is stored
+	again, err := SummarizeDocument(ctx, path)
+	assert.NoError(t, err)
+	assert.Equal(t, summary, again)
+	entries, err = usage.Load()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 4)
+
+	deck, err := TransformNote(WithSummaryPass(ctx, true), path)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, deck.Cards)
+	entries, err = usage.Load()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 7, "the stored summary is used and every chunk is generated")
+}
+
+func TestGenerationSettingsSummary(t *testing.T) {
+	plain, err := generationSettings(context.Background())
+	assert.NoError(t, err)
+	summarized, err := generationSettings(WithSummaryPass(context.Background(), true))
+	assert.NoError(t, err)
+	assert.NotEqual(t, plain, summarized)
+}
@@ -1,20 +1,27 @@
 package transform
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/jaxxk/anki-cards-generator/internal/cache"
+	"github.com/jaxxk/anki-cards-generator/internal/encryption"
+	"github.com/jaxxk/anki-cards-generator/internal/usage"
 	"github.com/jaxxk/anki-cards-generator/pkg/logging"
 	"github.com/jaxxk/anki-cards-generator/pkg/utils"
+	"github.com/openai/openai-go"
 )
 
 const FILE_SIZE_LIMIT int64 = 500000000
 
 // SaveDeck saves the deck object to a json file. Returns the path to the json file.
+// The file is encrypted when at-rest encryption is enabled.
 func SaveDeck(deck Deck) (string, error) {
 	processingPath, err := utils.CreateProcessingDir()
 	if err != nil {
@@ -26,7 +33,7 @@ func SaveDeck(deck Deck) (string, error) {
 		return "", err
 	}
 
-	jsonPath, err := utils.WriteJSONToFile(deck, processingPath, randomFileName)
+	jsonPath, err := encryption.WriteJSONFile(deck, processingPath, randomFileName)
 	if err != nil {
 		return "", fmt.Errorf("failed to write JSON to file: %w", err)
 	}
@@ -34,6 +41,69 @@ func SaveDeck(deck Deck) (string, error) {
 	return jsonPath, nil
 }
 
+// WriteDeck replaces the deck saved at path, encrypting it when at-rest encryption is enabled.
+// Returns the path written, which gains or loses the encrypted extension with the setting.
+func WriteDeck(path string, deck Deck) (string, error) {
+	folder, name := filepath.Split(path)
+	written, err := encryption.WriteJSONFile(deck, folder, strings.TrimSuffix(name, encryption.ENCRYPTED_EXT))
+	if err != nil {
+		return "", fmt.Errorf("failed to write JSON to file: %w", err)
+	}
+	if written != path {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to remove previous deck file: %w", err)
+		}
+	}
+	return written, nil
+}
+
+// LoadDeck reads a deck saved by SaveDeck, decrypting it if it was encrypted at rest.
+func LoadDeck(path string) (Deck, error) {
+	deck := Deck{}
+	if err := encryption.ReadJSONFile(path, &deck); err != nil {
+		return Deck{}, fmt.Errorf("failed to load deck: %w", err)
+	}
+	return deck, nil
+}
+
+// SavedDeck describes a deck saved in the processing directory.
+type SavedDeck struct {
+	Path    string
+	Title   string
+	Cards   int
+	ModTime time.Time
+}
+
+// ListDecks returns the decks saved in the processing directory, newest first.
+func ListDecks() ([]SavedDeck, error) {
+	processingPath, err := utils.CreateProcessingDir()
+	if err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(processingPath, "deck-*.json*"))
+	if err != nil {
+		return nil, err
+	}
+
+	decks := []SavedDeck{}
+	for _, path := range paths {
+		if !strings.HasSuffix(path, ".json") && !strings.HasSuffix(path, ".json"+encryption.ENCRYPTED_EXT) {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		deck, err := LoadDeck(path)
+		if err != nil {
+			return nil, err
+		}
+		decks = append(decks, SavedDeck{Path: path, Title: deck.Title, Cards: len(deck.Cards), ModTime: info.ModTime()})
+	}
+	sort.Slice(decks, func(i, j int) bool { return decks[i].ModTime.After(decks[j].ModTime) })
+	return decks, nil
+}
+
 // Returns a channel of Deck, will block until all of the file has been scanned. Handles the closing of the two channels.
 func streamDocument(ctx context.Context, docPath string) (<-chan Deck, <-chan error) {
 	decksCh := make(chan Deck)
@@ -44,36 +114,13 @@ func streamDocument(ctx context.Context, docPath string) (<-chan Deck, <-chan er
 		defer close(decksCh)
 		defer close(errCh)
 
-		// Check file size
-		fileInfo, err := os.Stat(docPath)
-		if err != nil {
-			errCh <- fmt.Errorf("failed to stat file: %w", err)
-			return
-		}
-		if fileInfo.Size() > FILE_SIZE_LIMIT {
-			errCh <- fmt.Errorf("file too large to process (%d bytes), limit %d",
-				fileInfo.Size(), FILE_SIZE_LIMIT)
-			return
-		}
-
-		// Open file
-		file, err := os.Open(docPath)
+		chunks, err := ChunkDocument(ctx, docPath)
 		if err != nil {
-			errCh <- fmt.Errorf("failed to open file: %w", err)
+			errCh <- err
 			return
 		}
-		defer file.Close()
-
-		// Set up scanner
-		scanner := bufio.NewScanner(file)
-		scanner.Split(bufio.ScanWords)
 
-		maxWords := 800
-		minWords := 500
-		var words []string
-
-		//  Read and accumulate words
-		for scanner.Scan() {
+		for _, chunk := range chunks {
 			// Respect context cancellation
 			select {
 			case <-ctx.Done():
@@ -83,44 +130,17 @@ func streamDocument(ctx context.Context, docPath string) (<-chan Deck, <-chan er
 				// proceed
 			}
 
-			word := scanner.Text()
-
-			// If we’ve reached the triple dash (after minWords) or hit maxWords, we create a new deck.
-			if (len(words) > minWords && word == "---") || len(words) >= maxWords {
-				accumulatedText := strings.Join(words, " ")
-				deck, err := createDeck(ctx, accumulatedText)
-				if err != nil {
-					errCh <- fmt.Errorf("failed to create deck: %w", err)
-					return
-				}
-
-				// Stream the deck to the channel
-				decksCh <- deck
-
-				// Reset the words slice
-				words = nil
-
-				// If the current word *is* the marker ("---"), skip adding it to the next chunk
-				if word == "---" {
-					continue
-				}
-			}
-
-			words = append(words, word)
-		}
-
-		if err := scanner.Err(); err != nil {
-			errCh <- fmt.Errorf("error while reading file: %w", err)
-			return
-		}
-
-		if len(words) > 0 {
-			accumulatedText := strings.Join(words, " ")
-			deck, err := createDeck(ctx, accumulatedText)
+			deck, err := generateDeck(ctx, chunk, len(strings.Fields(chunk)))
 			if err != nil {
-				errCh <- fmt.Errorf("failed to create deck from leftover words: %w", err)
+				errCh <- fmt.Errorf("failed to create deck: %w", err)
+				return
+			}
+			if deck.Cards, err = groundCards(ctx, chunk, deck.Cards); err != nil {
+				errCh <- err
 				return
 			}
+
+			// Stream the deck to the channel
 			decksCh <- deck
 		}
 	}()
@@ -128,9 +148,39 @@ func streamDocument(ctx context.Context, docPath string) (<-chan Deck, <-chan er
 	return decksCh, errCh
 }
 
-// createDeck calls (NewChatCompletion) and parses the JSON response into a Deck.
+// createDeck calls (NewChatCompletion) with text, after the summary of its document in ctx if
+// any, and parses the JSON response into a Deck. Decks are served from the cache in ctx when
+// the same request was answered before, and calls are refused when they would exceed the
+// budget of the usage run in ctx.
 func createDeck(ctx context.Context, text string) (Deck, error) {
 	logger := logging.FromContext(ctx)
+
+	responseCache := cache.FromContext(ctx)
+	var key string
+	if responseCache != nil {
+		var err error
+		if key, err = summaryDeckCacheKey(ctx, text); err != nil {
+			return Deck{}, err
+		}
+		cached := Deck{}
+		if ok, err := responseCache.Get(key, &cached); err != nil {
+			logger.Warnf("Failed to read response cache: %v", err)
+		} else if ok {
+			logger.Debugf("Using cached deck for chunk %s", key)
+			return cached, nil
+		}
+	}
+
+	text = summaryPrompt(ctx, text)
+
+	// Stop before a call that would exceed the budget
+	if run := usage.FromContext(ctx); run != nil {
+		chunkTokens := EstimateTokens(ctx, text)
+		if err := run.Allow(string(ModelFromContext(ctx)), requestInputTokens(ctx, DefaultChatCompletionConfigs(text)), int(float64(chunkTokens)*DefaultOutputTokenRatio)); err != nil {
+			return Deck{}, err
+		}
+	}
+
 	result, err := NewChatCompletion(ctx, text)
 	if err != nil {
 		return Deck{}, fmt.Errorf("failed to create a new chat completion: %w", err)
@@ -141,15 +191,143 @@ func createDeck(ctx context.Context, text string) (Deck, error) {
 	}
 
 	rawOutput := result.Choices[0].Message.Content
-	newDeck := Deck{}
-	err = json.Unmarshal([]byte(rawOutput), &newDeck)
-	if err != nil {
-		logger.Errorf("Failed to parse flashcards JSON: %v", err)
-		return Deck{}, fmt.Errorf("invalid JSON response from transform package")
+	newDeck, parseErr := ParseDeck(rawOutput)
+	recordUsage(ctx, result, newDeck.Title)
+	for attempt := 1; parseErr != nil && attempt <= DefaultRepairAttempts; attempt++ {
+		logger.Warnf("Failed to parse flashcards JSON, asking the model to repair it (%d/%d): %v", attempt, DefaultRepairAttempts, parseErr)
+		if rawOutput, err = repairResponse(ctx, text, rawOutput, parseErr); err != nil {
+			return Deck{}, fmt.Errorf("failed to repair flashcards JSON: %w", err)
+		}
+		newDeck, parseErr = ParseDeck(rawOutput)
+	}
+	if parseErr != nil {
+		logger.Errorf("Failed to parse flashcards JSON: %v", parseErr)
+		return Deck{}, fmt.Errorf("invalid JSON response from transform package: %w", parseErr)
+	}
+
+	if responseCache != nil {
+		if err := responseCache.Put(key, newDeck); err != nil {
+			logger.Warnf("Failed to write response cache: %v", err)
+		}
 	}
 	return newDeck, nil
 }
 
+// repairResponse shows the model its answer rawOutput to the request for text and why it could
+// not be parsed, and returns its new answer.
+func repairResponse(ctx context.Context, text, rawOutput string, parseErr error) (string, error) {
+	if run := usage.FromContext(ctx); run != nil {
+		chunkTokens := EstimateTokens(ctx, text)
+		inputTokens := requestInputTokens(ctx, repairChatCompletionConfigs(text, rawOutput, parseErr))
+		if err := run.Allow(string(ModelFromContext(ctx)), inputTokens, int(float64(chunkTokens)*DefaultOutputTokenRatio)); err != nil {
+			return "", err
+		}
+	}
+	result, err := newChatCompletion(ctx, repairChatCompletionConfigs(text, rawOutput, parseErr))
+	if err != nil {
+		return "", err
+	}
+	if result == nil || len(result.Choices) == 0 {
+		return "", fmt.Errorf("received empty response")
+	}
+	recordUsage(ctx, result, "")
+	return result.Choices[0].Message.Content, nil
+}
+
+// deckCacheKey identifies the request for text by everything sent to the model: the chunk,
+// the model, the prompt, the parameters and the response schema.
+func deckCacheKey(ctx context.Context, text string) (string, error) {
+	request := DefaultChatCompletionConfigs(text)
+	request.Model = openai.F(ModelFromContext(ctx))
+	params, err := json.Marshal(request)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize request for the cache: %w", err)
+	}
+	return cache.Key([]byte("deck/v1"), params), nil
+}
+
+// summaryDeckCacheKey identifies the request for text with the summary in ctx by text and the
+// summary settings, but not the summary itself: it changes with every edit of the notes, and
+// keying on it would send every chunk again when one section changes. A deck can so come from
+// an older summary of the notes, which is only context for the chunk it is about.
+func summaryDeckCacheKey(ctx context.Context, text string) (string, error) {
+	key, err := deckCacheKey(ctx, text)
+	if err != nil {
+		return "", err
+	}
+	if summary, _ := ctx.Value(documentSummaryKey{}).(DocumentSummary); summary.IsZero() {
+		return key, nil
+	}
+	settings, err := summarySettings(ctx)
+	if err != nil {
+		return "", err
+	}
+	return cache.Key([]byte(key), []byte(settings)), nil
+}
+
+// cachedCompletion sends params, or takes the answer from the cache in ctx when the same
+// request was answered before, and parses the answer into v. namespace versions the cache
+// keys of one kind of request. Calls are refused when inputTokens and outputTokens would
+// exceed the budget of the usage run in ctx. cached reports whether the cache answered.
+func cachedCompletion(ctx context.Context, namespace string, params openai.ChatCompletionNewParams, inputTokens, outputTokens int, v interface{}) (cached bool, err error) {
+	logger := logging.FromContext(ctx)
+
+	params.Model = openai.F(ModelFromContext(ctx))
+	responseCache := cache.FromContext(ctx)
+	var key string
+	if responseCache != nil {
+		serialized, err := json.Marshal(params)
+		if err != nil {
+			return false, fmt.Errorf("failed to serialize request for the cache: %w", err)
+		}
+		key = cache.Key([]byte(namespace), serialized)
+		if ok, err := responseCache.Get(key, v); err != nil {
+			logger.Warnf("Failed to read response cache: %v", err)
+		} else if ok {
+			return true, nil
+		}
+	}
+
+	if run := usage.FromContext(ctx); run != nil {
+		if err := run.Allow(string(ModelFromContext(ctx)), inputTokens, outputTokens); err != nil {
+			return false, err
+		}
+	}
+	result, err := newChatCompletion(ctx, params)
+	if err != nil {
+		return false, err
+	}
+	if result == nil || len(result.Choices) == 0 {
+		return false, fmt.Errorf("received empty response")
+	}
+	err = decodeResponse(result.Choices[0].Message.Content, v)
+	recordUsage(ctx, result, "")
+	if err != nil {
+		return false, fmt.Errorf("invalid JSON response: %w", err)
+	}
+
+	if responseCache != nil {
+		if err := responseCache.Put(key, v); err != nil {
+			logger.Warnf("Failed to write response cache: %v", err)
+		}
+	}
+	return false, nil
+}
+
+// recordUsage adds the tokens of a chat completion to the run in the context and the ledger.
+// A ledger that cannot be written is logged rather than failing a call that was already paid for.
+func recordUsage(ctx context.Context, result *openai.ChatCompletion, deckTitle string) {
+	run := usage.FromContext(ctx)
+	if run == nil {
+		return
+	}
+	// the response names a dated snapshot, prices are kept by the requested model
+	_, err := run.Record(string(ModelFromContext(ctx)), deckTitle, int(result.Usage.PromptTokens), int(result.Usage.CompletionTokens))
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Failed to record usage: %v", err)
+	}
+}
+
 // Reads a deck from the deck channel and appends it to one final deck. Will block until the deck channel is closed.
 // Depends on streamDocument
 func joinDeck(decksCh <-chan Deck) (Deck, error) {
@@ -173,7 +351,22 @@ func joinDeck(decksCh <-chan Deck) (Deck, error) {
 	return joinedDeck, nil
 }
 
+// withRunFor makes sure the calls made with ctx are recorded, under a new run on docPath
+// without a budget if ctx has none.
+func withRunFor(ctx context.Context, docPath string) context.Context {
+	if usage.FromContext(ctx) == nil {
+		ctx = usage.WithRun(ctx, usage.NewRun(docPath, usage.Budget{}, usage.DefaultPriceTable))
+	}
+	return ctx
+}
+
+// TransformNote generates a deck from the notes at docPath. Every call is recorded in the usage
+// ledger under the usage.Run in ctx, or under a new run without a budget.
 func TransformNote(ctx context.Context, docPath string) (Deck, error) {
+	ctx, err := withSummaryFor(withRunFor(ctx, docPath), docPath)
+	if err != nil {
+		return Deck{}, err
+	}
 	deckChan, errChan := streamDocument(ctx, docPath)
 	deck, err := joinDeck(deckChan)
 	if err != nil {
@@ -182,5 +375,6 @@ func TransformNote(ctx context.Context, docPath string) (Deck, error) {
 	if err = <-errChan; err != nil {
 		return Deck{}, err
 	}
+	deck.Cards = dedupCards(ctx, deck.Cards)
 	return deck, err
 }
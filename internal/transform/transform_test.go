@@ -1,13 +1,31 @@
 package transform
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/jaxxk/anki-cards-generator/internal/cache"
+	"github.com/jaxxk/anki-cards-generator/internal/encryption"
+	"github.com/jaxxk/anki-cards-generator/internal/transform/llmtest"
+	"github.com/jaxxk/anki-cards-generator/internal/usage"
 	"github.com/jaxxk/anki-cards-generator/pkg/utils"
+	"github.com/openai/openai-go"
 	"github.com/stretchr/testify/assert"
 )
 
+var record = flag.Bool("record", false, "regenerate the cassettes in testdata/cassettes from the llmtest fake")
+
+// useCassette replays testdata/cassettes/<name>.json for the rest of the test.
+func useCassette(t *testing.T, name string) {
+	llmtest.UseCassette(t, filepath.Join("testdata", "cassettes", name+".json"), *record)
+}
+
 func TestSaveDeck(t *testing.T) {
 	mockDeck := Deck{Title: "Test Deck", Cards: []Flashcards{{Front: "Q1", Back: "A1"}}}
 
@@ -26,3 +44,203 @@ func TestSaveDeck(t *testing.T) {
 	// Clean up
 	os.RemoveAll(processingPath)
 }
+
+func TestSaveDeckEncryptedAtRest(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	key, err := encryption.GenerateRandomKey()
+	assert.NoError(t, err)
+	t.Setenv(encryption.ENC_KEY, base64.StdEncoding.EncodeToString(key))
+	t.Setenv(encryption.ENCRYPT_AT_REST, "1")
+
+	mockDeck := Deck{Title: "Secret Deck", Cards: []Flashcards{{Front: "Q1", Back: "A1"}}}
+	jsonPath, err := SaveDeck(mockDeck)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(jsonPath, encryption.ENCRYPTED_EXT))
+
+	loaded, err := LoadDeck(jsonPath)
+	assert.NoError(t, err)
+	assert.Equal(t, mockDeck, loaded)
+
+	decks, err := ListDecks()
+	assert.NoError(t, err)
+	assert.Len(t, decks, 1)
+	assert.Equal(t, "Secret Deck", decks[0].Title)
+	assert.Equal(t, 1, decks[0].Cards)
+}
+
+func TestCreateDeckReplay(t *testing.T) {
+	useCassette(t, "create_deck")
+
+	deck, err := createDeck(context.Background(), "Channels are typed conduits. Closing a channel signals that no more values will be sent.")
+	assert.NoError(t, err)
+	assert.Len(t, deck.Cards, 2)
+	assert.Equal(t, "What does the note say about Channels are typed?", deck.Cards[0].Front)
+}
+
+func TestStreamDocumentReplay(t *testing.T) {
+	useCassette(t, "notes")
+
+	decksCh, errCh := streamDocument(context.Background(), filepath.Join("testdata", "notes.md"))
+	var decks []Deck
+	for deck := range decksCh {
+		decks = append(decks, deck)
+	}
+	assert.NoError(t, <-errCh)
+	assert.Len(t, decks, 2, "notes.md should be split into two chunks")
+	for _, deck := range decks {
+		assert.NotEmpty(t, deck.Cards)
+	}
+}
+
+func TestTransformNoteReplay(t *testing.T) {
+	useCassette(t, "notes")
+
+	deck, err := TransformNote(context.Background(), filepath.Join("testdata", "notes.md"))
+	assert.NoError(t, err)
+	assert.Equal(t, "Fake Deck", deck.Title)
+	assert.Len(t, deck.Cards, 6)
+
+	// every call is in the usage ledger under one run
+	entries, err := usage.Load()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	for _, entry := range entries {
+		assert.Equal(t, entries[0].RunID, entry.RunID)
+		assert.Equal(t, "gpt-4o-mini", entry.Model)
+		assert.Equal(t, "Fake Deck", entry.Deck)
+		assert.Greater(t, entry.PromptTokens, 0)
+		assert.Greater(t, entry.CompletionTokens, 0)
+		assert.Greater(t, entry.Cost, 0.0)
+	}
+}
+
+func TestTransformNoteCached(t *testing.T) {
+	// always replay, this test makes fewer calls than TestTransformNoteReplay records
+	llmtest.UseCassette(t, filepath.Join("testdata", "cassettes", "notes.json"), false)
+	docPath := filepath.Join("testdata", "notes.md")
+	responseCache, err := cache.Open()
+	assert.NoError(t, err)
+	ctx := cache.WithCache(context.Background(), responseCache)
+
+	first, err := TransformNote(ctx, docPath)
+	assert.NoError(t, err)
+	second, err := TransformNote(ctx, docPath)
+	assert.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	// the second run is served from the cache and costs nothing
+	entries, err := usage.Load()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+
+}
+
+func TestDeckCacheKey(t *testing.T) {
+	key, err := deckCacheKey(context.Background(), "some notes")
+	assert.NoError(t, err)
+	same, err := deckCacheKey(context.Background(), "some notes")
+	assert.NoError(t, err)
+	assert.Equal(t, key, same)
+
+	other, err := deckCacheKey(context.Background(), "other notes")
+	assert.NoError(t, err)
+	assert.NotEqual(t, key, other)
+
+	// another model or prompt is another request
+	ctx := WithModel(context.Background(), openai.ChatModelGPT4o)
+	otherModel, err := deckCacheKey(ctx, "some notes")
+	assert.NoError(t, err)
+	assert.NotEqual(t, key, otherModel)
+	defer func(prompt string) { DefaultPrompt = prompt }(DefaultPrompt)
+	DefaultPrompt += "\nBe brief."
+	otherPrompt, err := deckCacheKey(ctx, "some notes")
+	assert.NoError(t, err)
+	assert.NotEqual(t, otherModel, otherPrompt)
+}
+
+func TestTransformNoteBudget(t *testing.T) {
+	// always replay, this test makes fewer calls than TestTransformNoteReplay records
+	llmtest.UseCassette(t, filepath.Join("testdata", "cassettes", "notes.json"), false)
+	docPath := filepath.Join("testdata", "notes.md")
+
+	// enough for the first chunk only
+	plan, err := PlanDocument(context.Background(), docPath, usage.DefaultPriceTable)
+	assert.NoError(t, err)
+	budget := plan.Chunks[0].InputTokens + plan.Chunks[0].OutputTokens
+	run := usage.NewRun(docPath, usage.Budget{MaxTokens: budget}, usage.DefaultPriceTable)
+	_, err = TransformNote(usage.WithRun(context.Background(), run), docPath)
+	assert.ErrorIs(t, err, usage.ErrBudgetExceeded)
+
+	entries, err := usage.Load()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "the second call is never made")
+	spent, _ := run.Spent()
+	assert.LessOrEqual(t, spent, budget)
+}
+
+func TestReplayUnknownRequest(t *testing.T) {
+	// always replay, recording would add the request to the cassette
+	llmtest.UseCassette(t, filepath.Join("testdata", "cassettes", "create_deck.json"), false)
+
+	_, err := createDeck(context.Background(), "text that was never recorded")
+	assert.ErrorContains(t, err, "no recorded interaction")
+}
+
+func TestWriteDeck(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	deck := Deck{Title: "Test Deck", Cards: []Flashcards{{Front: "Q1", Back: "A1"}}}
+	jsonPath, err := SaveDeck(deck)
+	assert.NoError(t, err)
+
+	deck.Cards[0].Tags = []string{"reviewed"}
+	written, err := WriteDeck(jsonPath, deck)
+	assert.NoError(t, err)
+	assert.Equal(t, jsonPath, written)
+	loaded, err := LoadDeck(written)
+	assert.NoError(t, err)
+	assert.Equal(t, deck, loaded)
+
+	// turning on encryption replaces the plain file
+	key, err := encryption.GenerateRandomKey()
+	assert.NoError(t, err)
+	t.Setenv(encryption.ENC_KEY, base64.StdEncoding.EncodeToString(key))
+	t.Setenv(encryption.ENCRYPT_AT_REST, "1")
+	written, err = WriteDeck(jsonPath, deck)
+	assert.NoError(t, err)
+	assert.Equal(t, jsonPath+encryption.ENCRYPTED_EXT, written)
+	assert.NoFileExists(t, jsonPath)
+	loaded, err = LoadDeck(written)
+	assert.NoError(t, err)
+	assert.Equal(t, deck, loaded)
+}
+
+func TestRegenerateCardReplay(t *testing.T) {
+	useCassette(t, "regenerate")
+
+	card := Flashcards{Front: "What is a goroutine?", Back: "A function.", Tags: []string{"go"}}
+	regenerated, err := RegenerateCard(context.Background(), filepath.Join("testdata", "notes.md"), card)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, regenerated.Front)
+	assert.Equal(t, []string{"go"}, regenerated.Tags)
+}
+
+func TestRegeneratePromptSendsNotes(t *testing.T) {
+	card := Flashcards{Front: "What does closing a channel signal?", Back: "No more values will be sent."}
+	chunks := []string{
+		"A goroutine is a lightweight thread managed by the Go runtime.",
+		"Closing a channel signals that no more values will be sent on it.",
+	}
+	assert.Equal(t, chunks[1], cardChunk(chunks, card))
+	assert.Empty(t, cardChunk(chunks, Flashcards{Front: "What is a monad?", Back: "A monoid."}))
+
+	prompt := regeneratePrompt(chunks[1], card)
+	assert.True(t, strings.HasPrefix(prompt, "Notes:\n"+chunks[1]))
+	assert.Contains(t, prompt, "front: "+card.Front)
+	assert.Equal(t, "Flashcard:\nfront: "+card.Front+"\nback: "+card.Back, regeneratePrompt("", card))
+
+	params := regenerateChatCompletionConfigs(prompt)
+	serialized, err := json.Marshal(params)
+	assert.NoError(t, err)
+	assert.Contains(t, string(serialized), "flashcard editor")
+	assert.NotContains(t, string(serialized), "specialized flashcard generator", "regenerating does not use the deck prompt")
+}
@@ -1,6 +1,11 @@
 package transform
 
 import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
 	"github.com/invopop/jsonschema"
 	"github.com/openai/openai-go"
 )
@@ -9,12 +14,60 @@ import (
 type Flashcards struct {
 	Front string `json:"front" jsonschema_description:"The front side of the flashcard"`
 	Back  string `json:"back" jsonschema_description:"The back side of the flashcard"`
+	// Difficulty and Level are zero for cards generated before they were asked for
+	Difficulty int        `json:"difficulty,omitempty" jsonschema:"required,enum=1,enum=2,enum=3,enum=4,enum=5" jsonschema_description:"How hard the flashcard is, from 1 (easiest) to 5 (hardest)"`
+	Level      BloomLevel `json:"level,omitempty" jsonschema:"required,enum=recall,enum=understand,enum=apply,enum=analyze" jsonschema_description:"The cognitive level the flashcard tests"`
+	// Tags are added to the Anki note, they are not asked of the model
+	Tags []string `json:"tags,omitempty" jsonschema:"-"`
+}
+
+// UnmarshalJSON decodes a card like the default decoder, but also takes a difficulty written as
+// a string, as models without structured output sometimes do: "3" is 3, and a string that is
+// not a number is no difficulty.
+func (card *Flashcards) UnmarshalJSON(data []byte) error {
+	type plain Flashcards
+	var decoded struct {
+		plain
+		Difficulty json.RawMessage `json:"difficulty"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*card = Flashcards(decoded.plain)
+	if len(decoded.Difficulty) == 0 || string(decoded.Difficulty) == "null" {
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(decoded.Difficulty, &text); err == nil {
+		card.Difficulty, _ = strconv.Atoi(strings.TrimSpace(text))
+		return nil
+	}
+	if err := json.Unmarshal(decoded.Difficulty, &card.Difficulty); err != nil {
+		return fmt.Errorf("invalid difficulty %s: %w", decoded.Difficulty, err)
+	}
+	return nil
+}
+
+// AnkiTags returns the tags of the card's Anki note: its Tags, then its difficulty and level
+// as "difficulty::N" and "bloom::level".
+func (card Flashcards) AnkiTags() []string {
+	var metadata []string
+	if card.Difficulty > 0 {
+		metadata = append(metadata, fmt.Sprintf("difficulty::%d", card.Difficulty))
+	}
+	if card.Level != "" {
+		metadata = append(metadata, "bloom::"+string(card.Level))
+	}
+	return mergeTags(append([]string{}, card.Tags...), metadata)
 }
 
 // Deck represents a collection of flashcards.
 type Deck struct {
 	Title string       `json:"Title" jsonschema_description:"The title of the deck"`
 	Cards []Flashcards `json:"cards" jsonschema_description:"A deck consisting of flashcards"`
+
+	// unrecognizedLevels are the levels of the model's answer that are not Bloom levels
+	unrecognizedLevels []string
 }
 
 func (deck *Deck) UpdateTitle(title string) {
@@ -43,3 +96,27 @@ func CreateResponseSchema() openai.ResponseFormatJSONSchemaJSONSchemaParam {
 	}
 	return schemaParam
 }
+
+// Verdict is the judgement of one flashcard against its source notes.
+type Verdict struct {
+	Card              int      `json:"card" jsonschema_description:"The number of the flashcard"`
+	Supported         bool     `json:"supported" jsonschema_description:"Whether the notes support everything the back states"`
+	UnsupportedClaims []string `json:"unsupported_claims" jsonschema_description:"The statements of the back the notes do not support"`
+}
+
+// Verdicts is the response of the grounding judge.
+type Verdicts struct {
+	Verdicts []Verdict `json:"verdicts" jsonschema_description:"One verdict per flashcard"`
+}
+
+// GlossaryEntry defines one term of a document.
+type GlossaryEntry struct {
+	Term       string `json:"term" jsonschema_description:"The term as the notes write it"`
+	Definition string `json:"definition" jsonschema_description:"What the term means, in one sentence taken from the notes"`
+}
+
+// DocumentSummary is the outline and glossary of a document, or of a part of it.
+type DocumentSummary struct {
+	Outline  []string        `json:"outline" jsonschema_description:"The main points of the notes in order, one short sentence each"`
+	Glossary []GlossaryEntry `json:"glossary" jsonschema_description:"The terms the notes define or rely on"`
+}
@@ -1,9 +1,11 @@
 package transform
 
 import (
+	"encoding/json"
 	"testing"
 
 	"github.com/openai/openai-go"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestGenerateSchema(t *testing.T) {
@@ -19,3 +21,39 @@ func TestCreateResponseSchema(t *testing.T) {
 		t.Errorf("Expected name 'deck', got %v", responseSchema.Name)
 	}
 }
+
+func TestResponseSchemaCardMetadata(t *testing.T) {
+	schema, err := json.Marshal(generateSchema[Deck]())
+	assert.NoError(t, err)
+	var parsed struct {
+		Properties struct {
+			Cards struct {
+				Items struct {
+					Required   []string                   `json:"required"`
+					Properties map[string]json.RawMessage `json:"properties"`
+				} `json:"items"`
+			} `json:"cards"`
+		} `json:"properties"`
+	}
+	assert.NoError(t, json.Unmarshal(schema, &parsed))
+	items := parsed.Properties.Cards.Items
+	// strict structured output needs every property required
+	assert.ElementsMatch(t, []string{"front", "back", "difficulty", "level"}, items.Required)
+	assert.NotContains(t, items.Properties, "tags")
+	assert.JSONEq(t, `[1,2,3,4,5]`, string(mustField(t, items.Properties["difficulty"], "enum")))
+	assert.JSONEq(t, `["recall","understand","apply","analyze"]`, string(mustField(t, items.Properties["level"], "enum")))
+}
+
+func mustField(t *testing.T, object json.RawMessage, field string) json.RawMessage {
+	t.Helper()
+	fields := map[string]json.RawMessage{}
+	assert.NoError(t, json.Unmarshal(object, &fields))
+	return fields[field]
+}
+
+func TestAnkiTags(t *testing.T) {
+	assert.Empty(t, Flashcards{Front: "Q", Back: "A"}.AnkiTags())
+	card := Flashcards{Difficulty: 2, Level: LevelRecall, Tags: []string{"go", "bloom::recall"}}
+	assert.Equal(t, []string{"go", "bloom::recall", "difficulty::2"}, card.AnkiTags())
+	assert.Equal(t, []string{"go", "bloom::recall"}, card.Tags, "the card's own tags are left alone")
+}
@@ -0,0 +1,73 @@
+// Package usage keeps the ledger of tokens spent on LLM calls and enforces per-run budgets.
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/jaxxk/anki-cards-generator/internal/encryption"
+	"github.com/jaxxk/anki-cards-generator/pkg/utils"
+)
+
+// LEDGER_FILE is the usage ledger in the processing directory, one JSON entry per line.
+var LEDGER_FILE = "usage.jsonl"
+
+// Entry records the tokens of one LLM call.
+type Entry struct {
+	Time             time.Time `json:"time"`
+	RunID            string    `json:"runId"`
+	Model            string    `json:"model"`
+	Source           string    `json:"source"`
+	Deck             string    `json:"deck,omitempty"`
+	PromptTokens     int       `json:"promptTokens"`
+	CompletionTokens int       `json:"completionTokens"`
+	// Cost in US dollars, zero for models without a price
+	Cost float64 `json:"cost,omitempty"`
+}
+
+// TotalTokens returns the prompt and completion tokens of the call.
+func (e Entry) TotalTokens() int {
+	return e.PromptTokens + e.CompletionTokens
+}
+
+// Append adds entry to the ledger. Entries are sealed when at-rest encryption is enabled.
+func Append(entry Entry) error {
+	processingPath, err := utils.CreateProcessingDir()
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to serialize usage entry: %w", err)
+	}
+	if _, err := encryption.AppendLine(line, processingPath, LEDGER_FILE); err != nil {
+		return fmt.Errorf("failed to append to usage ledger: %w", err)
+	}
+	return nil
+}
+
+// Load returns every entry of the ledger, oldest first.
+func Load() ([]Entry, error) {
+	processingPath, err := utils.CreateProcessingDir()
+	if err != nil {
+		return nil, err
+	}
+	lines, err := encryption.ReadLines(filepath.Join(processingPath, LEDGER_FILE))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read usage ledger: %w", err)
+	}
+
+	entries := make([]Entry, 0, len(lines))
+	for i, line := range lines {
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("failed to parse usage ledger entry %d: %w", i+1, err)
+		}
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	return entries, nil
+}
@@ -0,0 +1,67 @@
+package usage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// ModelPrice is the price of a model in US dollars per million tokens.
+type ModelPrice struct {
+	InputPerMillion  float64 `json:"input"`
+	OutputPerMillion float64 `json:"output"`
+}
+
+// PriceTable maps model names to their prices.
+type PriceTable map[string]ModelPrice
+
+// DefaultPriceTable holds list prices at the time of writing. Override or extend it with
+// LoadPriceTable when prices change.
+var DefaultPriceTable = PriceTable{
+	"gpt-4o-mini":   {InputPerMillion: 0.15, OutputPerMillion: 0.60},
+	"gpt-4o":        {InputPerMillion: 2.50, OutputPerMillion: 10.00},
+	"gpt-4.1":       {InputPerMillion: 2.00, OutputPerMillion: 8.00},
+	"gpt-4.1-mini":  {InputPerMillion: 0.40, OutputPerMillion: 1.60},
+	"gpt-4.1-nano":  {InputPerMillion: 0.10, OutputPerMillion: 0.40},
+	"o3-mini":       {InputPerMillion: 1.10, OutputPerMillion: 4.40},
+	"gpt-3.5-turbo": {InputPerMillion: 0.50, OutputPerMillion: 1.50},
+}
+
+// LoadPriceTable returns DefaultPriceTable overlaid with the prices in the JSON file at path,
+// e.g. {"gpt-4o-mini": {"input": 0.15, "output": 0.6}}. An empty path returns the defaults.
+func LoadPriceTable(path string) (PriceTable, error) {
+	table := PriceTable{}
+	for model, price := range DefaultPriceTable {
+		table[model] = price
+	}
+	if path == "" {
+		return table, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read price table: %w", err)
+	}
+	overrides := PriceTable{}
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to parse price table %s: %w", path, err)
+	}
+	for model, price := range overrides {
+		table[model] = price
+	}
+	return table, nil
+}
+
+// Cost returns the price in US dollars of a call to model. ok is false for unknown models.
+func (t PriceTable) Cost(model string, inputTokens, outputTokens int) (cost float64, ok bool) {
+	price, ok := t[model]
+	if !ok {
+		return 0, false
+	}
+	return (float64(inputTokens)*price.InputPerMillion + float64(outputTokens)*price.OutputPerMillion) / 1e6, true
+}
+
+// FormatCost formats a dollar amount for display.
+func FormatCost(cost float64) string {
+	return fmt.Sprintf("$%.4f", cost)
+}
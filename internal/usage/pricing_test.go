@@ -0,0 +1,31 @@
+package usage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriceTableCost(t *testing.T) {
+	cost, ok := DefaultPriceTable.Cost("gpt-4o-mini", 1_000_000, 1_000_000)
+	assert.True(t, ok)
+	assert.InDelta(t, 0.75, cost, 1e-9)
+
+	_, ok = DefaultPriceTable.Cost("no-such-model", 10, 10)
+	assert.False(t, ok)
+}
+
+func TestLoadPriceTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "prices.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"gpt-4o-mini": {"input": 1, "output": 2}, "local": {"input": 0, "output": 0}}`), 0644))
+
+	table, err := LoadPriceTable(path)
+	assert.NoError(t, err)
+	assert.Equal(t, ModelPrice{InputPerMillion: 1, OutputPerMillion: 2}, table["gpt-4o-mini"])
+	assert.Contains(t, table, "local")
+	assert.Equal(t, DefaultPriceTable["gpt-4o"], table["gpt-4o"])
+	// the defaults are left alone
+	assert.Equal(t, 0.15, DefaultPriceTable["gpt-4o-mini"].InputPerMillion)
+}
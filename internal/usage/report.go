@@ -0,0 +1,78 @@
+package usage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Grouping dimensions of a report.
+const (
+	GroupDay   = "day"
+	GroupModel = "model"
+	GroupDeck  = "deck"
+)
+
+// Row sums the entries sharing the same values of the grouping dimensions.
+type Row struct {
+	// Key holds the value of each dimension, in the order they were requested
+	Key              []string
+	Calls            int
+	PromptTokens     int
+	CompletionTokens int
+	Cost             float64
+}
+
+// ParseGroupBy parses a comma separated list of dimensions such as "day,model".
+func ParseGroupBy(spec string) ([]string, error) {
+	var dims []string
+	for _, dim := range strings.Split(spec, ",") {
+		dim = strings.TrimSpace(dim)
+		switch dim {
+		case GroupDay, GroupModel, GroupDeck:
+			dims = append(dims, dim)
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown grouping %q, expected %s, %s or %s", dim, GroupDay, GroupModel, GroupDeck)
+		}
+	}
+	return dims, nil
+}
+
+// Summarize groups entries by dims, with days in the local time zone. Rows are sorted by key.
+func Summarize(entries []Entry, dims []string) []Row {
+	rows := map[string]*Row{}
+	for _, entry := range entries {
+		key := make([]string, len(dims))
+		for i, dim := range dims {
+			switch dim {
+			case GroupDay:
+				key[i] = entry.Time.In(time.Local).Format("2006-01-02")
+			case GroupModel:
+				key[i] = entry.Model
+			case GroupDeck:
+				key[i] = entry.Deck
+			}
+		}
+		id := strings.Join(key, "\x00")
+		row, ok := rows[id]
+		if !ok {
+			row = &Row{Key: key}
+			rows[id] = row
+		}
+		row.Calls++
+		row.PromptTokens += entry.PromptTokens
+		row.CompletionTokens += entry.CompletionTokens
+		row.Cost += entry.Cost
+	}
+
+	result := make([]Row, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, *row)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return strings.Join(result[i].Key, "\x00") < strings.Join(result[j].Key, "\x00")
+	})
+	return result
+}
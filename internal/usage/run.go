@@ -0,0 +1,112 @@
+package usage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrBudgetExceeded is returned when a call would take a run over its budget.
+var ErrBudgetExceeded = errors.New("budget exceeded")
+
+// Budget caps the spending of a run. Zero values mean no limit.
+type Budget struct {
+	MaxCost   float64
+	MaxTokens int
+}
+
+// Run tracks the LLM calls made to generate one deck from one source file.
+type Run struct {
+	ID     string
+	Source string
+	// Deck is the deck title recorded with each call. When empty, the first title the model
+	// comes up with is used.
+	Deck   string
+	Budget Budget
+	Prices PriceTable
+
+	mu     sync.Mutex
+	tokens int
+	cost   float64
+}
+
+// NewRun starts a run with a random ID.
+func NewRun(source string, budget Budget, prices PriceTable) *Run {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &Run{ID: hex.EncodeToString(id), Source: source, Budget: budget, Prices: prices}
+}
+
+type contextKey string
+
+const runKey = contextKey("usageRun")
+
+// WithRun stores run in the context, for the LLM calls made with it.
+func WithRun(ctx context.Context, run *Run) context.Context {
+	return context.WithValue(ctx, runKey, run)
+}
+
+// FromContext returns the run stored in the context, or nil.
+func FromContext(ctx context.Context) *Run {
+	run, _ := ctx.Value(runKey).(*Run)
+	return run
+}
+
+// Spent returns the tokens and cost recorded so far.
+func (r *Run) Spent() (tokens int, cost float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tokens, r.cost
+}
+
+// Allow checks that a call to model estimated at inputTokens and outputTokens fits in what
+// is left of the budget. It returns an error wrapping ErrBudgetExceeded if it does not.
+func (r *Run) Allow(model string, inputTokens, outputTokens int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if max := r.Budget.MaxTokens; max > 0 && r.tokens+inputTokens+outputTokens > max {
+		return fmt.Errorf("%w: ~%d more tokens would bring the run to %d, over the limit of %d",
+			ErrBudgetExceeded, inputTokens+outputTokens, r.tokens+inputTokens+outputTokens, max)
+	}
+	if max := r.Budget.MaxCost; max > 0 {
+		cost, ok := r.Prices.Cost(model, inputTokens, outputTokens)
+		if !ok {
+			return fmt.Errorf("cannot enforce a cost limit without a price for %s", model)
+		}
+		if r.cost+cost > max {
+			return fmt.Errorf("%w: ~%s more would bring the run to %s, over the limit of %s",
+				ErrBudgetExceeded, FormatCost(cost), FormatCost(r.cost+cost), FormatCost(max))
+		}
+	}
+	return nil
+}
+
+// Record adds the tokens of a finished call to the run and appends it to the ledger.
+func (r *Run) Record(model, deck string, promptTokens, completionTokens int) (Entry, error) {
+	cost, _ := r.Prices.Cost(model, promptTokens, completionTokens)
+
+	r.mu.Lock()
+	r.tokens += promptTokens + completionTokens
+	r.cost += cost
+	if r.Deck == "" {
+		r.Deck = deck
+	}
+	entry := Entry{
+		Time:             time.Now().UTC(),
+		RunID:            r.ID,
+		Model:            model,
+		Source:           r.Source,
+		Deck:             r.Deck,
+		PromptTokens:     promptTokens,
+		CompletionTokens: completionTokens,
+		Cost:             cost,
+	}
+	r.mu.Unlock()
+
+	return entry, Append(entry)
+}
@@ -0,0 +1,78 @@
+package usage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunRecordsToLedger(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	run := NewRun("notes.md", Budget{}, DefaultPriceTable)
+	entry, err := run.Record("gpt-4o-mini", "Go Concurrency", 1000, 500)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.00045, entry.Cost, 1e-9)
+	_, err = run.Record("gpt-4o-mini", "Another Title", 100, 50)
+	assert.NoError(t, err)
+
+	entries, err := Load()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "notes.md", entries[1].Source)
+	assert.Equal(t, "Go Concurrency", entries[1].Deck, "the first title names the deck of the whole run")
+	assert.Equal(t, run.ID, entries[1].RunID)
+
+	tokens, cost := run.Spent()
+	assert.Equal(t, 1650, tokens)
+	assert.InDelta(t, 0.00045+0.0000450, cost, 1e-9)
+
+	data, err := os.ReadFile(filepath.Join(os.Getenv("HOME"), ".anki-cards-generator", LEDGER_FILE))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"runId":"`+run.ID+`"`)
+}
+
+func TestRunAllow(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	run := NewRun("notes.md", Budget{MaxTokens: 1000}, DefaultPriceTable)
+	assert.NoError(t, run.Allow("gpt-4o-mini", 600, 400))
+	_, err := run.Record("gpt-4o-mini", "", 600, 300)
+	assert.NoError(t, err)
+	assert.ErrorIs(t, run.Allow("gpt-4o-mini", 100, 1), ErrBudgetExceeded)
+
+	run = NewRun("notes.md", Budget{MaxCost: 0.01}, DefaultPriceTable)
+	assert.NoError(t, run.Allow("gpt-4o-mini", 10_000, 10_000))
+	assert.ErrorIs(t, run.Allow("gpt-4o", 10_000, 10_000), ErrBudgetExceeded)
+	assert.ErrorContains(t, run.Allow("unpriced", 1, 1), "without a price")
+
+	// no budget, no limit
+	run = NewRun("notes.md", Budget{}, DefaultPriceTable)
+	assert.NoError(t, run.Allow("unpriced", 1_000_000, 1_000_000))
+}
+
+func TestSummarize(t *testing.T) {
+	day1 := time.Date(2026, 10, 1, 12, 0, 0, 0, time.Local)
+	day2 := day1.AddDate(0, 0, 1)
+	entries := []Entry{
+		{Time: day1, Model: "gpt-4o-mini", Deck: "Go", PromptTokens: 100, CompletionTokens: 10, Cost: 1},
+		{Time: day1, Model: "gpt-4o-mini", Deck: "Go", PromptTokens: 200, CompletionTokens: 20, Cost: 2},
+		{Time: day2, Model: "gpt-4o", Deck: "Rust", PromptTokens: 300, CompletionTokens: 30, Cost: 3},
+	}
+
+	rows := Summarize(entries, []string{GroupDay, GroupModel, GroupDeck})
+	assert.Equal(t, []Row{
+		{Key: []string{"2026-10-01", "gpt-4o-mini", "Go"}, Calls: 2, PromptTokens: 300, CompletionTokens: 30, Cost: 3},
+		{Key: []string{"2026-10-02", "gpt-4o", "Rust"}, Calls: 1, PromptTokens: 300, CompletionTokens: 30, Cost: 3},
+	}, rows)
+
+	total := Summarize(entries, nil)
+	assert.Len(t, total, 1)
+	assert.Equal(t, 3, total[0].Calls)
+
+	_, err := ParseGroupBy("model,week")
+	assert.ErrorContains(t, err, "unknown grouping")
+}
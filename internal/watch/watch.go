@@ -0,0 +1,298 @@
+// Package watch polls a notes directory and hands every saved Markdown or text file to a
+// handler once it stopped changing, remembering what was handled across restarts.
+package watch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jaxxk/anki-cards-generator/internal/encryption"
+	"github.com/jaxxk/anki-cards-generator/pkg/logging"
+	"github.com/jaxxk/anki-cards-generator/pkg/utils"
+)
+
+// STATE_DIR holds, for every watched directory, the files handled so far.
+var STATE_DIR = "watch"
+
+// DefaultInterval is how often the directory is polled.
+var DefaultInterval = 2 * time.Second
+
+// DefaultDebounce is how long a file must stay unchanged before it is handled, so a burst
+// of saves is handled once.
+var DefaultDebounce = 3 * time.Second
+
+// Extensions are the files that are watched.
+var Extensions = []string{".md", ".markdown", ".txt"}
+
+// Handler handles a changed file, given by its absolute path.
+type Handler func(ctx context.Context, path string) error
+
+// FileState is what was last handled of a file.
+type FileState struct {
+	Hash    string    `json:"hash"`
+	ModTime time.Time `json:"modTime"`
+	Size    int64     `json:"size"`
+	// HandledAt is when the content with Hash was last handled
+	HandledAt time.Time `json:"handledAt"`
+}
+
+// State is the persistent state of a watched directory.
+type State struct {
+	Dir   string               `json:"dir"`
+	Files map[string]FileState `json:"files"`
+}
+
+// fingerprint identifies a version of a file without reading it.
+type fingerprint struct {
+	modTime time.Time
+	size    int64
+}
+
+// pending is a changed file waiting for its saves to settle.
+type pending struct {
+	fingerprint
+	seenAt time.Time
+}
+
+// Watcher polls Dir for changed files.
+type Watcher struct {
+	Dir      string
+	Interval time.Duration
+	Debounce time.Duration
+	State    State
+
+	pending map[string]pending
+	// failed holds the versions of files the handler failed on, so they are only retried
+	// once they change again
+	failed map[string]fingerprint
+	now    func() time.Time
+}
+
+// New creates a watcher of dir, loading the state of its last run.
+func New(dir string, interval, debounce time.Duration) (*Watcher, error) {
+	absolute, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", dir, err)
+	}
+	info, err := os.Stat(absolute)
+	if err != nil {
+		return nil, fmt.Errorf("failed to watch %s: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("failed to watch %s: not a directory", dir)
+	}
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	if debounce < 0 {
+		debounce = DefaultDebounce
+	}
+	state, err := LoadState(absolute)
+	if err != nil {
+		return nil, err
+	}
+	return &Watcher{
+		Dir:      absolute,
+		Interval: interval,
+		Debounce: debounce,
+		State:    state,
+		pending:  map[string]pending{},
+		failed:   map[string]fingerprint{},
+		now:      time.Now,
+	}, nil
+}
+
+// statePath returns where the state of dir is kept, named after its absolute path.
+func statePath(dir string) (string, string, error) {
+	processingPath, err := utils.CreateProcessingDir()
+	if err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256([]byte(dir))
+	return filepath.Join(processingPath, STATE_DIR), hex.EncodeToString(sum[:8]) + ".json", nil
+}
+
+// LoadState returns the state of the last run on dir, or an empty one.
+func LoadState(dir string) (State, error) {
+	state := State{Dir: dir, Files: map[string]FileState{}}
+	folder, name, err := statePath(dir)
+	if err != nil {
+		return State{}, err
+	}
+	if err := encryption.ReadJSONFile(filepath.Join(folder, name), &state); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return state, nil
+		}
+		return State{}, fmt.Errorf("failed to load watch state of %s: %w", dir, err)
+	}
+	if state.Files == nil {
+		state.Files = map[string]FileState{}
+	}
+	return state, nil
+}
+
+// SaveState stores the state of its directory.
+func SaveState(state State) error {
+	folder, name, err := statePath(state.Dir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(folder, 0700); err != nil {
+		return fmt.Errorf("failed to create watch state directory: %w", err)
+	}
+	if _, err := encryption.WriteJSONFile(state, folder, name); err != nil {
+		return fmt.Errorf("failed to save watch state of %s: %w", state.Dir, err)
+	}
+	return nil
+}
+
+// Run polls until ctx is done, handling every file that changed since it was last handled,
+// including files changed while the watcher was not running. It returns nil on cancellation.
+func (w *Watcher) Run(ctx context.Context, handle Handler) error {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		if err := w.Poll(ctx, handle); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll scans the directory once and handles the files whose saves have settled. Handler
+// errors are logged, only failing to scan or to save the state is returned.
+func (w *Watcher) Poll(ctx context.Context, handle Handler) error {
+	logger := logging.FromContext(ctx)
+	files, err := w.scan()
+	if err != nil {
+		return err
+	}
+
+	stateChanged := false
+	for path := range w.State.Files {
+		if _, ok := files[path]; !ok {
+			delete(w.State.Files, path)
+			stateChanged = true
+		}
+	}
+	for path := range w.pending {
+		if _, ok := files[path]; !ok {
+			delete(w.pending, path)
+		}
+	}
+
+	now := w.now()
+	for path, current := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		if last, ok := w.State.Files[path]; ok && last.ModTime.Equal(current.modTime) && last.Size == current.size {
+			continue
+		}
+		if failed, ok := w.failed[path]; ok && failed == current {
+			continue
+		}
+		waiting, ok := w.pending[path]
+		if !ok || waiting.fingerprint != current {
+			w.pending[path] = pending{fingerprint: current, seenAt: now}
+			if w.Debounce > 0 {
+				continue
+			}
+		} else if now.Sub(waiting.seenAt) < w.Debounce {
+			continue
+		}
+		delete(w.pending, path)
+
+		handled, err := w.handleFile(ctx, handle, path, current)
+		if err != nil {
+			logger.Errorf("Failed to process %v: %v", path, err)
+			w.failed[path] = current
+			continue
+		}
+		delete(w.failed, path)
+		w.State.Files[path] = handled
+		stateChanged = true
+	}
+
+	if stateChanged {
+		return SaveState(w.State)
+	}
+	return nil
+}
+
+// handleFile hands path to handle unless only its modification time changed.
+func (w *Watcher) handleFile(ctx context.Context, handle Handler, path string, current fingerprint) (FileState, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return FileState{}, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	sum := sha256.Sum256(content)
+	state := FileState{Hash: hex.EncodeToString(sum[:]), ModTime: current.modTime, Size: current.size}
+	if last, ok := w.State.Files[path]; ok && last.Hash == state.Hash {
+		state.HandledAt = last.HandledAt
+		return state, nil
+	}
+	if err := handle(ctx, path); err != nil {
+		return FileState{}, err
+	}
+	state.HandledAt = w.now()
+	return state, nil
+}
+
+// scan returns the watched files under the directory, skipping hidden ones.
+func (w *Watcher) scan() (map[string]fingerprint, error) {
+	files := map[string]fingerprint{}
+	err := filepath.WalkDir(w.Dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) && path != w.Dir {
+				return nil
+			}
+			return err
+		}
+		if path != w.Dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !watched(path) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		files[path] = fingerprint{modTime: info.ModTime(), size: info.Size()}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan %s: %w", w.Dir, err)
+	}
+	return files, nil
+}
+
+// watched reports whether path has one of the watched extensions.
+func watched(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, extension := range Extensions {
+		if ext == extension {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a watcher clock moved by hand.
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func newTestWatcher(t *testing.T, dir string, clock *fakeClock) *Watcher {
+	t.Helper()
+	watcher, err := New(dir, time.Millisecond, time.Second)
+	assert.NoError(t, err)
+	watcher.now = clock.Now
+	return watcher
+}
+
+func writeNote(t *testing.T, path, content string, modTime time.Time) {
+	t.Helper()
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	assert.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestPollDebouncesAndRemembers(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	notePath := filepath.Join(dir, "go.md")
+	writeNote(t, notePath, "# Go\nGoroutines are cheap.\n", clock.now)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n"), 0644))
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, ".git"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, ".git", "HEAD.md"), []byte("hidden\n"), 0644))
+
+	var handled []string
+	handle := func(ctx context.Context, path string) error {
+		handled = append(handled, path)
+		return nil
+	}
+	ctx := context.Background()
+	watcher := newTestWatcher(t, dir, clock)
+
+	// saved just now, wait for the debounce
+	assert.NoError(t, watcher.Poll(ctx, handle))
+	assert.Empty(t, handled)
+
+	// saved again before it settled
+	clock.now = clock.now.Add(500 * time.Millisecond)
+	writeNote(t, notePath, "# Go\nGoroutines are cheap threads.\n", clock.now)
+	assert.NoError(t, watcher.Poll(ctx, handle))
+	clock.now = clock.now.Add(500 * time.Millisecond)
+	assert.NoError(t, watcher.Poll(ctx, handle))
+	assert.Empty(t, handled)
+
+	clock.now = clock.now.Add(time.Second)
+	assert.NoError(t, watcher.Poll(ctx, handle))
+	assert.Equal(t, []string{notePath}, handled)
+	clock.now = clock.now.Add(time.Minute)
+	assert.NoError(t, watcher.Poll(ctx, handle))
+	assert.Len(t, handled, 1)
+
+	// a restart does not process the file again
+	watcher = newTestWatcher(t, dir, clock)
+	assert.NoError(t, watcher.Poll(ctx, handle))
+	clock.now = clock.now.Add(time.Minute)
+	assert.NoError(t, watcher.Poll(ctx, handle))
+	assert.Len(t, handled, 1)
+
+	// touching without changing the content is not a change
+	writeNote(t, notePath, "# Go\nGoroutines are cheap threads.\n", clock.now)
+	assert.NoError(t, watcher.Poll(ctx, handle))
+	clock.now = clock.now.Add(time.Minute)
+	assert.NoError(t, watcher.Poll(ctx, handle))
+	assert.Len(t, handled, 1)
+
+	// changes made while stopped are processed after a restart
+	writeNote(t, notePath, "# Go\nChannels are typed.\n", clock.now)
+	watcher = newTestWatcher(t, dir, clock)
+	assert.NoError(t, watcher.Poll(ctx, handle))
+	clock.now = clock.now.Add(time.Minute)
+	assert.NoError(t, watcher.Poll(ctx, handle))
+	assert.Len(t, handled, 2)
+}
+
+func TestPollRetriesFailedFileOnlyAfterChange(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	clock := &fakeClock{now: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	notePath := filepath.Join(dir, "go.md")
+	writeNote(t, notePath, "# Go\n", clock.now)
+
+	calls := 0
+	handle := func(ctx context.Context, path string) error {
+		calls++
+		return errors.New("anki is down")
+	}
+	ctx := context.Background()
+	watcher := newTestWatcher(t, dir, clock)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, watcher.Poll(ctx, handle))
+		clock.now = clock.now.Add(time.Minute)
+	}
+	assert.Equal(t, 1, calls)
+	assert.Empty(t, watcher.State.Files)
+
+	writeNote(t, notePath, "# Go\nGoroutines.\n", clock.now)
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, watcher.Poll(ctx, handle))
+		clock.now = clock.now.Add(time.Minute)
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestRunStopsOnCancel(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "go.md"), []byte("# Go\n"), 0644))
+	watcher, err := New(dir, time.Millisecond, 0)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = watcher.Run(ctx, func(ctx context.Context, path string) error {
+		cancel()
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Len(t, watcher.State.Files, 1)
+
+	state, err := LoadState(watcher.Dir)
+	assert.NoError(t, err)
+	assert.Len(t, state.Files, 1)
+}
+
+func TestNewRejectsFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "go.md")
+	assert.NoError(t, os.WriteFile(path, []byte("# Go\n"), 0644))
+	_, err := New(path, 0, 0)
+	assert.ErrorContains(t, err, "not a directory")
+}
@@ -0,0 +1,336 @@
+// Package tokens counts text in the byte-level BPE tokens used by OpenAI chat models.
+//
+// Encodings use the tiktoken file format, one base64 token and its rank per line. The
+// cl100k_base and o200k_base tables of OpenAI's tiktoken are bundled, each with its own
+// pre-tokenization rules, and ForModel picks the one a model is billed in.
+package tokens
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	_ "embed"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Names of the bundled encodings.
+const (
+	Cl100kBase = "cl100k_base"
+	O200kBase  = "o200k_base"
+)
+
+// DefaultEncoding names the encoding of the models ForModel does not know.
+const DefaultEncoding = O200kBase
+
+var (
+	//go:embed data/cl100k_base.tiktoken
+	cl100kBaseTable []byte
+	//go:embed data/o200k_base.tiktoken
+	o200kBaseTable []byte
+)
+
+// The patterns split text into the pieces BPE runs on, like the regular expressions of
+// tiktoken. RE2 has no lookahead, so the trailing-whitespace rule \s+(?!\S) is applied by
+// Pieces.
+var (
+	cl100kPattern = regexp.MustCompile(`^(?:(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+)`)
+	o200kPattern  = regexp.MustCompile(`^(?:[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]*[\p{Ll}\p{Lm}\p{Lo}\p{M}]+(?i:'s|'t|'re|'ve|'m|'ll|'d)?|[^\r\n\p{L}\p{N}]?[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]+[\p{Ll}\p{Lm}\p{Lo}\p{M}]*(?i:'s|'t|'re|'ve|'m|'ll|'d)?|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n/]*|\s*[\r\n]+|\s+)`)
+)
+
+// Encoding is a byte-level BPE vocabulary.
+type Encoding struct {
+	Name    string
+	ranks   map[string]int
+	decoder map[int][]byte
+	pattern *regexp.Regexp
+}
+
+// bundledEncoding is an encoding bundled with the package, loaded on first use.
+type bundledEncoding struct {
+	table    []byte
+	pattern  *regexp.Regexp
+	once     sync.Once
+	encoding *Encoding
+}
+
+var bundled = map[string]*bundledEncoding{
+	Cl100kBase: {table: cl100kBaseTable, pattern: cl100kPattern},
+	O200kBase:  {table: o200kBaseTable, pattern: o200kPattern},
+}
+
+// modelPrefixes maps model names to their encoding, like tiktoken does. The first prefix a
+// name starts with wins, so the longer prefixes of a family come first.
+var modelPrefixes = []struct {
+	prefix   string
+	encoding string
+}{
+	{"gpt-4o", O200kBase},
+	{"chatgpt-4o", O200kBase},
+	{"gpt-4.1", O200kBase},
+	{"gpt-4.5", O200kBase},
+	{"gpt-5", O200kBase},
+	{"gpt-oss", O200kBase},
+	{"o1", O200kBase},
+	{"o3", O200kBase},
+	{"o4", O200kBase},
+	{"gpt-4", Cl100kBase},
+	{"gpt-3.5", Cl100kBase},
+	{"gpt-35", Cl100kBase},
+	{"text-embedding-3", Cl100kBase},
+	{"text-embedding-ada-002", Cl100kBase},
+}
+
+// Get returns the bundled encoding called name.
+func Get(name string) (*Encoding, error) {
+	b, ok := bundled[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding %s", name)
+	}
+	b.once.Do(func() {
+		encoding, err := Load(name, bytes.NewReader(b.table))
+		if err != nil {
+			panic(fmt.Sprintf("tokens: bundled encoding %s is corrupt: %v", name, err))
+		}
+		encoding.pattern = b.pattern
+		b.encoding = encoding
+	})
+	return b.encoding, nil
+}
+
+// ForModel returns the encoding of model, DefaultEncoding when it is unknown. A provider
+// prefix such as "openai/" is ignored.
+func ForModel(model string) *Encoding {
+	name := strings.ToLower(model[strings.LastIndex(model, "/")+1:])
+	for _, candidate := range modelPrefixes {
+		if strings.HasPrefix(name, candidate.prefix) {
+			return mustGet(candidate.encoding)
+		}
+	}
+	return Default()
+}
+
+// Default returns the DefaultEncoding.
+func Default() *Encoding {
+	return mustGet(DefaultEncoding)
+}
+
+func mustGet(name string) *Encoding {
+	encoding, err := Get(name)
+	if err != nil {
+		panic(fmt.Sprintf("tokens: %v", err))
+	}
+	return encoding
+}
+
+// Count returns the number of tokens of text in the default encoding.
+func Count(text string) int {
+	return Default().Count(text)
+}
+
+// LoadFile loads a tiktoken file such as cl100k_base.tiktoken.
+func LoadFile(path string) (*Encoding, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open encoding: %w", err)
+	}
+	defer file.Close()
+	return Load(path, file)
+}
+
+// Load reads an encoding in the tiktoken format from r. It splits text into pieces with the
+// rules of cl100k_base.
+func Load(name string, r io.Reader) (*Encoding, error) {
+	encoding := &Encoding{Name: name, ranks: map[string]int{}, decoder: map[int][]byte{}, pattern: cl100kPattern}
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := bytes.Fields(scanner.Bytes())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected a token and a rank", name, line)
+		}
+		token, err := base64.StdEncoding.DecodeString(string(fields[0]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid token: %w", name, line, err)
+		}
+		rank, err := strconv.Atoi(string(fields[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid rank: %w", name, line, err)
+		}
+		encoding.ranks[string(token)] = rank
+		encoding.decoder[rank] = token
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read encoding %s: %w", name, err)
+	}
+	for b := 0; b < 256; b++ {
+		if _, ok := encoding.ranks[string([]byte{byte(b)})]; !ok {
+			return nil, fmt.Errorf("encoding %s has no token for byte %#x", name, b)
+		}
+	}
+	return encoding, nil
+}
+
+// Size returns the number of tokens in the vocabulary.
+func (e *Encoding) Size() int {
+	return len(e.ranks)
+}
+
+// Encode returns the token IDs of text.
+func (e *Encoding) Encode(text string) []int {
+	ids := []int{}
+	for _, piece := range e.Pieces(text) {
+		if rank, ok := e.ranks[piece]; ok {
+			ids = append(ids, rank)
+			continue
+		}
+		for _, part := range e.merge([]byte(piece)) {
+			ids = append(ids, e.ranks[string(part)])
+		}
+	}
+	return ids
+}
+
+// Count returns the number of tokens of text.
+func (e *Encoding) Count(text string) int {
+	count := 0
+	for _, piece := range e.Pieces(text) {
+		if _, ok := e.ranks[piece]; ok {
+			count++
+			continue
+		}
+		count += len(e.merge([]byte(piece)))
+	}
+	return count
+}
+
+// Decode returns the text of the token IDs. Unknown IDs are skipped.
+func (e *Encoding) Decode(ids []int) string {
+	var buf bytes.Buffer
+	for _, id := range ids {
+		buf.Write(e.decoder[id])
+	}
+	return buf.String()
+}
+
+// merge applies the BPE merges to piece, lowest rank first and leftmost first among equal
+// ranks, and returns the resulting tokens. The parts form a linked list and the ranks of
+// adjacent pairs a min-heap, so a piece of n bytes takes O(n log n) however long it is.
+func (e *Encoding) merge(piece []byte) [][]byte {
+	n := len(piece)
+	// part i covers piece[i:end[i]]; next and prev link the parts still standing, and
+	// version counts the merges into part i so pairs queued before them can be told stale
+	end := make([]int, n)
+	next := make([]int, n)
+	prev := make([]int, n)
+	version := make([]int, n)
+	for i := range piece {
+		end[i], next[i], prev[i] = i+1, i+1, i-1
+	}
+	pairs := &pairHeap{}
+	push := func(left int) {
+		right := next[left]
+		if right >= n {
+			return
+		}
+		if rank, ok := e.ranks[string(piece[left:end[right]])]; ok {
+			heap.Push(pairs, pair{rank: rank, left: left, right: right, leftVersion: version[left], rightVersion: version[right]})
+		}
+	}
+	for i := 0; i+1 < n; i++ {
+		push(i)
+	}
+
+	for pairs.Len() > 0 {
+		p := heap.Pop(pairs).(pair)
+		if version[p.left] != p.leftVersion || version[p.right] != p.rightVersion || next[p.left] != p.right {
+			continue
+		}
+		// the right part joins the left one
+		end[p.left] = end[p.right]
+		next[p.left] = next[p.right]
+		if next[p.left] < n {
+			prev[next[p.left]] = p.left
+		}
+		version[p.left]++
+		version[p.right]++
+		if prev[p.left] >= 0 {
+			push(prev[p.left])
+		}
+		push(p.left)
+	}
+
+	parts := [][]byte{}
+	for i := 0; i < n; i = next[i] {
+		parts = append(parts, piece[i:end[i]])
+	}
+	return parts
+}
+
+// pair is two adjacent parts of a piece that merge into the token of rank.
+type pair struct {
+	rank, left, right         int
+	leftVersion, rightVersion int
+}
+
+// pairHeap orders pairs by rank, then by position.
+type pairHeap []pair
+
+func (h pairHeap) Len() int { return len(h) }
+func (h pairHeap) Less(i, j int) bool {
+	if h[i].rank != h[j].rank {
+		return h[i].rank < h[j].rank
+	}
+	return h[i].left < h[j].left
+}
+func (h pairHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *pairHeap) Push(x interface{}) { *h = append(*h, x.(pair)) }
+func (h *pairHeap) Pop() interface{} {
+	old := *h
+	last := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return last
+}
+
+// Pieces splits text the way the encoder does before applying merges. Tokens never cross
+// piece boundaries.
+func (e *Encoding) Pieces(text string) []string {
+	pieces := []string{}
+	for len(text) > 0 {
+		loc := e.pattern.FindStringIndex(text)
+		end := 1
+		if loc != nil && loc[1] > 0 {
+			end = loc[1]
+		}
+		// \s+(?!\S): a whitespace run leaves its last character to the word that follows
+		if end < len(text) && isSpace(text[:end]) && !strings.ContainsAny(text[end-1:end], "\r\n") {
+			if _, size := utf8.DecodeLastRuneInString(text[:end]); end-size > 0 {
+				end -= size
+			}
+		}
+		pieces = append(pieces, text[:end])
+		text = text[end:]
+	}
+	return pieces
+}
+
+func isSpace(s string) bool {
+	for _, r := range s {
+		if !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
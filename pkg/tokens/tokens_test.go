@@ -0,0 +1,136 @@
+package tokens
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	texts := []string{
+		"",
+		"Goroutines are multiplexed onto OS threads.",
+		"func main() {\n\tfmt.Println(\"hi\")\n}\n",
+		"日本語のテキストと emoji 🚀 mixed in",
+		"trailing   spaces   \n\n\n",
+	}
+	encoding := Default()
+	for _, text := range texts {
+		ids := encoding.Encode(text)
+		assert.Equal(t, text, encoding.Decode(ids))
+		assert.Equal(t, len(ids), encoding.Count(text))
+	}
+}
+
+func TestCommonWordsAreSingleTokens(t *testing.T) {
+	for _, name := range []string{Cl100kBase, O200kBase} {
+		encoding, err := Get(name)
+		assert.NoError(t, err)
+		for _, word := range []string{" the", " function", " channel", "return"} {
+			assert.Equal(t, 1, encoding.Count(word), "%s: %q", name, word)
+		}
+	}
+}
+
+func TestCountTracksText(t *testing.T) {
+	prose := strings.Repeat("A channel is a typed conduit through which you can send and receive values. ", 20)
+	words := len(strings.Fields(prose))
+	// English prose is a little over one token per word
+	assert.InDelta(t, float64(words)*1.2, float64(Count(prose)), float64(words)*0.3)
+
+	// text without spaces is not one token per "word"
+	cjk := strings.Repeat("並行処理", 50)
+	assert.Greater(t, Count(cjk), 100)
+}
+
+// cl100kCounts are cl100k_base token counts published in OpenAI's guide to counting tokens
+// with tiktoken.
+var cl100kCounts = []struct {
+	text  string
+	count int
+}{
+	{"hello world", 2},
+	{"Hello, world!", 4},
+	{"tiktoken is great!", 6},
+	{"antidisestablishmentarianism", 6},
+	{"2 + 2 = 4", 7},
+	{"The quick brown fox jumps over the lazy dog.", 10},
+	{"お誕生日おめでとう", 9},
+}
+
+func TestCountMatchesTiktoken(t *testing.T) {
+	cl100k, err := Get(Cl100kBase)
+	assert.NoError(t, err)
+	for _, reference := range cl100kCounts {
+		assert.Equal(t, reference.count, cl100k.Count(reference.text), "%q", reference.text)
+	}
+	// token IDs from tiktoken's own tests
+	assert.Equal(t, []int{15339, 1917}, cl100k.Encode("hello world"))
+	assert.Equal(t, []int{24912, 2375}, Default().Encode("hello world"))
+}
+
+func TestForModel(t *testing.T) {
+	for model, encoding := range map[string]string{
+		"gpt-4o-mini":   O200kBase,
+		"gpt-4.1-nano":  O200kBase,
+		"o3-mini":       O200kBase,
+		"openai/gpt-4o": O200kBase,
+		"gpt-4":         Cl100kBase,
+		"gpt-4-turbo":   Cl100kBase,
+		"gpt-3.5-turbo": Cl100kBase,
+		"llama3.1:8b":   DefaultEncoding,
+		"":              DefaultEncoding,
+	} {
+		assert.Equal(t, encoding, ForModel(model).Name, model)
+	}
+	_, err := Get("poggers_base")
+	assert.ErrorContains(t, err, "unknown encoding")
+}
+
+func TestPieces(t *testing.T) {
+	cl100k, err := Get(Cl100kBase)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Hello", "  ", " world", "'s", " ", "123", "45"}, cl100k.Pieces("Hello   world's 12345"))
+	assert.Equal(t, []string{"Hello", "  ", " world's", " ", "123", "45"}, Default().Pieces("Hello   world's 12345"), "o200k_base keeps contractions with their word")
+	assert.Equal(t, []string{"a", "\n\n", "b"}, Default().Pieces("a\n\nb"))
+	assert.Equal(t, strings.Repeat("x ", 3), strings.Join(Default().Pieces(strings.Repeat("x ", 3)), ""))
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tiny.tiktoken")
+	var sb strings.Builder
+	for _, line := range strings.SplitN(string(cl100kBaseTable), "\n", 258)[:257] {
+		sb.WriteString(line + "\n")
+	}
+	assert.NoError(t, os.WriteFile(path, []byte(sb.String()), 0644))
+
+	encoding, err := LoadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 257, encoding.Size())
+	assert.Equal(t, "some text", encoding.Decode(encoding.Encode("some text")))
+	cl100k, err := Get(Cl100kBase)
+	assert.NoError(t, err)
+	assert.Greater(t, encoding.Count("some text"), cl100k.Count("some text"), "a smaller vocabulary needs more tokens")
+}
+
+func TestLoadRejectsIncompleteTables(t *testing.T) {
+	_, err := Load("bad", strings.NewReader("YQ== 0\n"))
+	assert.ErrorContains(t, err, "no token for byte")
+
+	_, err = Load("bad", strings.NewReader("not-base64! 0\n"))
+	assert.ErrorContains(t, err, "invalid token")
+}
+
+func TestCountLongPieces(t *testing.T) {
+	// an unspaced run is one piece, merged in O(n log n) however long it is
+	for _, text := range []string{strings.Repeat("a", 100000), strings.Repeat("並行処理の基本", 3000)} {
+		start := time.Now()
+		ids := Default().Encode(text)
+		assert.Equal(t, text, Default().Decode(ids))
+		assert.Less(t, time.Since(start), 5*time.Second)
+	}
+}